@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"tunnel/internal/har"
+)
+
+// harCapture 把经过隧道的请求写入 HAR 文件，未启用时为 nil，所有方法都可以安全调用
+type harCapture struct {
+	path   string
+	writer *har.Writer
+
+	mu      sync.Mutex
+	pending map[string]*har.Exchange // 等待本地服务响应的请求
+}
+
+// openCapture 按配置创建 HAR 文件 (已存在时覆盖)
+func (c *TunnelClient) openCapture() error {
+	cfg := c.config.Capture
+	writer, err := har.Create(cfg.HAR, "tunnel-client", har.Options{
+		MaxBodySize:   cfg.MaxBodySize,
+		RedactHeaders: cfg.RedactHeaders,
+	})
+	if err != nil {
+		return fmt.Errorf("创建 HAR 文件失败: %v", err)
+	}
+	c.capture = &harCapture{path: cfg.HAR, writer: writer, pending: make(map[string]*har.Exchange)}
+	tunnelLog.Info("正在把请求记录到 HAR 文件", "file", cfg.HAR, "max_body_size", cfg.MaxBodySize,
+		"redact_headers", cfg.RedactHeaders)
+	return nil
+}
+
+// begin 记录发往本地服务的请求。URL 为访客访问的公网地址 (来自服务器添加的转发头)
+func (h *harCapture) begin(id, publicURL, method string, headers http.Header, body []byte, fields map[string]string) {
+	if h == nil {
+		return
+	}
+	x := &har.Exchange{
+		Started:        time.Now(),
+		Method:         method,
+		URL:            publicURL,
+		RequestHeaders: headers,
+		RequestBody:    body,
+		Fields:         fields,
+	}
+	h.mu.Lock()
+	h.pending[id] = x
+	h.mu.Unlock()
+}
+
+// complete 写入本地服务的响应，wait 为请求本地服务的耗时
+func (h *harCapture) complete(id string, status int, headers http.Header, body []byte, wait time.Duration) {
+	if h == nil {
+		return
+	}
+	h.finish(id, func(x *har.Exchange) {
+		x.Status, x.ResponseHeaders, x.ResponseBody, x.Wait = status, headers, body, wait
+	})
+}
+
+// fail 写入失败的请求 (状态码为 0)
+func (h *harCapture) fail(id, message string) {
+	if h == nil {
+		return
+	}
+	h.finish(id, func(x *har.Exchange) {
+		x.Error = message
+	})
+}
+
+func (h *harCapture) finish(id string, fn func(x *har.Exchange)) {
+	h.mu.Lock()
+	x := h.pending[id]
+	delete(h.pending, id)
+	h.mu.Unlock()
+	if x == nil {
+		return
+	}
+	fn(x)
+	x.Total = time.Since(x.Started)
+	if err := h.writer.Write(x); err != nil {
+		tunnelLog.Warn("写入 HAR 文件失败", "file", h.path, "request_id", id, "error", err)
+	}
+}
+
+// close 关闭文件，未完成的请求不会写入
+func (h *harCapture) close() {
+	if h == nil {
+		return
+	}
+	h.writer.Close()
+	tunnelLog.Info("HAR 文件已保存", "file", h.path, "entries", h.writer.Count())
+}
+
+// publicRequestURL 访客请求的地址。服务器未发送转发头时使用注册的公网地址或本地服务地址
+func (c *TunnelClient) publicRequestURL(headers http.Header, path, query string) string {
+	scheme, host := headers.Get("X-Forwarded-Proto"), headers.Get("X-Forwarded-Host")
+	if host == "" {
+		c.mu.RLock()
+		publicURL := c.publicURL
+		c.mu.RUnlock()
+		if u, err := url.Parse(publicURL); err == nil && u.Host != "" {
+			scheme, host = u.Scheme, u.Host
+		}
+	}
+	if host == "" {
+		return c.originURL(path, query)
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: path, RawQuery: query}
+	return u.String()
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -17,6 +16,9 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+
+	"tunnel/internal/logging"
+	"tunnel/internal/tracing"
 )
 
 // Config 客户端配置
@@ -27,22 +29,57 @@ type Config struct {
 		ReconnectAttempts int   `yaml:"reconnectAttempts" json:"reconnectAttempts"`
 		ReconnectDelay    int   `yaml:"reconnectDelay" json:"reconnectDelay"`
 		MaxReconnectDelay int   `yaml:"maxReconnectDelay" json:"maxReconnectDelay"`
+		// 公网主机名 (服务器按此主机名路由请求，受令牌作用域限制)
+		Hostname          string `yaml:"hostname" json:"hostname"`
 		// TLS/SSL 配置
 		InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
 		ServerName         string `yaml:"serverName" json:"serverName"`
 		CACertFile         string `yaml:"caCertFile" json:"caCertFile"`
+		// 证书固定：服务器证书链公钥的 SHA-256 指纹 (base64)
+		PinnedSPKI         []string `yaml:"pinnedSpki" json:"pinnedSpki"`
+		// 客户端证书 (服务器启用 mTLS 时使用)
+		CertFile           string `yaml:"certFile" json:"certFile"`
+		KeyFile            string `yaml:"keyFile" json:"keyFile"`
+		// 要求服务器为本隧道执行的访问策略 (服务器可能限制或覆盖)
+		Policy struct {
+			BasicAuth          []string `yaml:"basicAuth" json:"basicAuth"`                   // "user:password"，密码在本地哈希后发送
+			AllowCIDRs         []string `yaml:"allowCidrs" json:"allowCidrs"`                 // 只允许这些地址访问
+			OAuthAllowedEmails []string `yaml:"oauthAllowedEmails" json:"oauthAllowedEmails"` // 通过服务器的身份提供者登录，"@example.com" 表示整个域名
+		} `yaml:"policy" json:"policy"`
 	} `yaml:"tunnel" json:"tunnel"`
 	Local struct {
 		Host string `yaml:"host" json:"host"`
 		Port int    `yaml:"port" json:"port"`
+		// 连接本地服务时发送 PROXY protocol 头部 (v1/v2)，留空表示不发送
+		ProxyProtocol string `yaml:"proxyProtocol" json:"proxyProtocol"`
 	} `yaml:"local" json:"local"`
+	// 日志级别和格式
+	Log logging.Config `yaml:"log" json:"log"`
+	// 本地指标和状态接口 (Prometheus 和 /ready)
+	Metrics struct {
+		Listen string `yaml:"listen" json:"listen"` // 如 127.0.0.1:20241，为空表示不启用
+		Path   string `yaml:"path" json:"path"`     // 默认 /metrics
+	} `yaml:"metrics" json:"metrics"`
+	// 链路追踪，以 OTLP/HTTP 导出
+	Tracing tracing.Config `yaml:"tracing" json:"tracing"`
+	// 请求检查器：在本地网页中查看最近的请求和响应，并可以重放
+	Inspect struct {
+		Listen      string `yaml:"listen" json:"listen"`           // 如 127.0.0.1:4040，为空表示不启用
+		MaxRequests int    `yaml:"maxRequests" json:"maxRequests"` // 保留的请求数，默认 100
+		MaxBodySize int    `yaml:"maxBodySize" json:"maxBodySize"` // 每个请求和响应保留的正文字节数，默认 65536
+	} `yaml:"inspect" json:"inspect"`
+	// 把经过隧道的请求写入 HAR 1.2 文件
+	Capture struct {
+		HAR           string   `yaml:"har" json:"har"`                     // 文件路径，为空表示不记录
+		MaxBodySize   int      `yaml:"maxBodySize" json:"maxBodySize"`     // 每个正文保留的字节数，默认 65536，负数表示不记录正文
+		RedactHeaders []string `yaml:"redactHeaders" json:"redactHeaders"` // 额外隐去的头部 (Authorization、Cookie 等总是隐去)
+	} `yaml:"capture" json:"capture"`
 }
 
 // DefaultConfig 默认配置
 func DefaultConfig() *Config {
 	config := &Config{}
 	config.Tunnel.URL = "ws://localhost:6001"
-	config.Tunnel.AuthToken = "default-token"
 	config.Tunnel.ReconnectAttempts = -1 // -1 表示无限重连
 	config.Tunnel.ReconnectDelay = 1000
 	config.Tunnel.MaxReconnectDelay = 60000 // 最大重连延迟60秒
@@ -52,6 +89,8 @@ func DefaultConfig() *Config {
 	config.Tunnel.CACertFile = ""
 	config.Local.Host = "localhost"
 	config.Local.Port = 3000
+	config.Inspect.MaxRequests = 100
+	config.Inspect.MaxBodySize = 64 * 1024
 	return config
 }
 
@@ -87,21 +126,92 @@ type TunnelClient struct {
 	reconnectCount  int
 	stopChan        chan struct{}
 	mu              sync.RWMutex
+	originClient    *http.Client
+	policyHeader    string
+	metrics         *clientMetrics
+	tracer          *tracing.Tracer
+	inspector       *inspector
+	capture         *harCapture
+	// 当前连接的状态，用于 /ready
+	clientID        string
+	publicURL       string
+	connectedAt     time.Time
+	lastError       string
+	// 服务器要求断开且不再重连 (如被管理员断开或令牌被删除)
+	noReconnect     bool
 }
 
 // NewTunnelClient 创建隧道客户端
 func NewTunnelClient(config *Config) *TunnelClient {
-	return &TunnelClient{
+	c := &TunnelClient{
 		config:   config,
 		stopChan: make(chan struct{}),
 	}
+	c.metrics = newClientMetrics(c)
+	return c
 }
 
 // Start 启动客户端
 func (c *TunnelClient) Start() error {
-	log.Printf("启动隧道客户端...")
-	log.Printf("连接地址: %s", c.config.Tunnel.URL)
-	log.Printf("本地服务: %s:%d", c.config.Local.Host, c.config.Local.Port)
+	tunnelLog.Info("启动隧道客户端", "server", c.config.Tunnel.URL,
+		"origin", fmt.Sprintf("%s:%d", c.config.Local.Host, c.config.Local.Port))
+	c.warnInsecureTLS()
+	
+	// 访问本地服务的HTTP客户端
+	version, err := proxyProtocolVersion(c.config.Local.ProxyProtocol)
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		originLog.Info("向本地服务发送 PROXY protocol 头部", "version", version)
+	}
+	c.originClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: newOriginTransport(version),
+	}
+	
+	// 访问策略只在启动时生成一次，重连时复用
+	if c.policyHeader, err = c.buildPolicyHeader(); err != nil {
+		return fmt.Errorf("访问策略配置错误: %v", err)
+	}
+	if c.policyHeader != "" {
+		tunnelLog.Info("要求服务器执行访问策略", "basic_auth_users", len(c.config.Tunnel.Policy.BasicAuth),
+			"allow_cidrs", c.config.Tunnel.Policy.AllowCIDRs, "oauth_allowed_emails", c.config.Tunnel.Policy.OAuthAllowedEmails)
+	}
+	
+	// 链路追踪，未启用时只把服务器传来的 traceparent 透传给本地服务
+	if c.tracer, err = tracing.New(c.config.Tracing, "tunnel-client"); err != nil {
+		return fmt.Errorf("链路追踪配置错误: %v", err)
+	}
+	if c.tracer != nil {
+		tunnelLog.Info("链路追踪已启用", "endpoint", c.tracer.Endpoint())
+	}
+	
+	// 请求检查器，与指标接口地址相同时由指标接口一并提供
+	if c.config.Inspect.Listen != "" {
+		if c.config.Inspect.MaxRequests <= 0 || c.config.Inspect.MaxBodySize < 0 {
+			return fmt.Errorf("请求检查器配置错误: maxRequests 应大于 0，maxBodySize 不能为负数")
+		}
+		c.inspector = newInspector(c.config.Inspect.MaxRequests, c.config.Inspect.MaxBodySize)
+		if c.config.Inspect.Listen != c.config.Metrics.Listen {
+			if err := c.startInspectServer(); err != nil {
+				return err
+			}
+		}
+	}
+	
+	if c.config.Capture.HAR != "" {
+		if err := c.openCapture(); err != nil {
+			return err
+		}
+	}
+	
+	// 本地指标和状态接口
+	if c.config.Metrics.Listen != "" {
+		if err := c.startMetricsServer(); err != nil {
+			return err
+		}
+	}
 	
 	// 启动连接
 	if err := c.connect(); err != nil {
@@ -119,41 +229,54 @@ func (c *TunnelClient) Start() error {
 
 // connect 连接到隧道服务器
 func (c *TunnelClient) connect() error {
-	log.Printf("连接到隧道服务器: %s", c.config.Tunnel.URL)
+	tunnelLog.Debug("连接到隧道服务器", "server", c.config.Tunnel.URL)
 	
 	// 设置请求头
 	headers := http.Header{}
-	headers.Set("Authorization", "Bearer "+c.config.Tunnel.AuthToken)
+	if c.config.Tunnel.AuthToken != "" {
+		headers.Set("Authorization", "Bearer "+c.config.Tunnel.AuthToken)
+	}
 	headers.Set("X-Tunnel-Host", c.config.Local.Host)
 	headers.Set("X-Tunnel-Port", fmt.Sprintf("%d", c.config.Local.Port))
+	if c.config.Tunnel.Hostname != "" {
+		headers.Set("X-Tunnel-Hostname", c.config.Tunnel.Hostname)
+	}
+	headers.Set("X-Tunnel-Type", "http")
+	if c.policyHeader != "" {
+		headers.Set("X-Tunnel-Policy", c.policyHeader)
+	}
+	// 告知服务器这是断线后的重连，用于统计重连次数
+	c.mu.Lock()
+	attempt := c.reconnectCount
+	c.mu.Unlock()
+	if attempt > 0 {
+		headers.Set("X-Tunnel-Reconnect", fmt.Sprintf("%d", attempt))
+	}
 	
 	// 创建WebSocket拨号器
 	dialer := *websocket.DefaultDialer
 	
 	// 检查是否为WSS连接
 	if strings.HasPrefix(c.config.Tunnel.URL, "wss://") {
-		// 配置TLS
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: c.config.Tunnel.InsecureSkipVerify,
-		}
-		
-		// 设置服务器名称
-		if c.config.Tunnel.ServerName != "" {
-			tlsConfig.ServerName = c.config.Tunnel.ServerName
-		}
-		
-		// 如果指定了CA证书文件，这里可以加载（暂时跳过实现）
-		if c.config.Tunnel.CACertFile != "" {
-			log.Printf("注意: CA证书文件配置暂未实现: %s", c.config.Tunnel.CACertFile)
+		tlsConfig, err := c.buildTLSConfig()
+		if err != nil {
+			return err
 		}
 		
 		dialer.TLSClientConfig = tlsConfig
-		log.Printf("使用WSS连接，InsecureSkipVerify: %v", c.config.Tunnel.InsecureSkipVerify)
+		tlsLog.Debug("使用WSS连接", "insecure_skip_verify", c.config.Tunnel.InsecureSkipVerify, "ca", c.config.Tunnel.CACertFile,
+			"pins", len(c.config.Tunnel.PinnedSPKI), "cert", c.config.Tunnel.CertFile)
 	}
 	
 	// 建立WebSocket连接
-	conn, _, err := dialer.Dial(c.config.Tunnel.URL, headers)
+	conn, resp, err := dialer.Dial(c.config.Tunnel.URL, headers)
 	if err != nil {
+		// 握手被拒绝时带上服务器返回的原因
+		if resp != nil {
+			reason, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			resp.Body.Close()
+			return fmt.Errorf("WebSocket连接失败: %s %s", resp.Status, strings.TrimSpace(string(reason)))
+		}
 		return fmt.Errorf("WebSocket连接失败: %v", err)
 	}
 	
@@ -161,9 +284,16 @@ func (c *TunnelClient) connect() error {
 	c.conn = conn
 	c.connected = true
 	c.reconnectCount = 0
+	c.connectedAt = time.Now()
+	c.lastError = ""
 	c.mu.Unlock()
 	
-	log.Printf("隧道连接已建立")
+	c.metrics.connects.Inc()
+	if attempt > 0 {
+		c.metrics.reconnects.Inc()
+	}
+	
+	tunnelLog.Debug("隧道连接已建立", "server", c.config.Tunnel.URL)
 	
 	// 启动消息处理
 	go c.handleMessages()
@@ -180,8 +310,17 @@ func (c *TunnelClient) handleMessages() {
 			c.conn = nil
 		}
 		c.connected = false
+		c.clientID = ""
+		c.publicURL = ""
+		noReconnect := c.noReconnect
 		c.mu.Unlock()
 		
+		if noReconnect {
+			tunnelLog.Warn("服务器要求停止，不再重连")
+			c.Stop()
+			return
+		}
+		
 		// 尝试重连
 		go c.reconnect()
 	}()
@@ -197,7 +336,10 @@ func (c *TunnelClient) handleMessages() {
 		
 		var msg map[string]interface{}
 		if err := conn.ReadJSON(&msg); err != nil {
-			log.Printf("读取消息失败: %v", err)
+			tunnelLog.Warn("读取消息失败，连接已断开", "error", err)
+			c.mu.Lock()
+			c.lastError = err.Error()
+			c.mu.Unlock()
 			break
 		}
 		
@@ -210,8 +352,10 @@ func (c *TunnelClient) handleMessages() {
 			c.handleHTTPRequest(msg)
 		case "ping":
 			c.handlePing(msg)
+		case "disconnect":
+			c.handleDisconnect(msg)
 		default:
-			log.Printf("收到未知消息类型: %s", msgType)
+			tunnelLog.Debug("收到未知消息类型", "type", msgType)
 		}
 	}
 }
@@ -222,12 +366,29 @@ func (c *TunnelClient) handleConnected(msg map[string]interface{}) {
 	publicURL, _ := data["publicUrl"].(string)
 	clientID, _ := data["clientId"].(string)
 	
-	log.Printf("✓ 隧道已建立")
-	log.Printf("  客户端ID: %s", clientID)
-	if publicURL != "" {
-		log.Printf("  公网地址: %s", publicURL)
+	c.mu.Lock()
+	c.clientID = clientID
+	c.publicURL = publicURL
+	c.mu.Unlock()
+	
+	tunnelLog.Info("✓ 隧道已建立", "client_id", clientID, "public_url", publicURL,
+		"origin", fmt.Sprintf("%s:%d", c.config.Local.Host, c.config.Local.Port))
+	if warning, _ := data["policyWarning"].(string); warning != "" {
+		tunnelLog.Warn(warning)
 	}
-	log.Printf("  本地服务: %s:%d", c.config.Local.Host, c.config.Local.Port)
+}
+
+// handleDisconnect 服务器即将关闭连接，reconnect 为 false 时不再重连
+func (c *TunnelClient) handleDisconnect(msg map[string]interface{}) {
+	data, _ := msg["data"].(map[string]interface{})
+	reason, _ := data["reason"].(string)
+	reconnect, _ := data["reconnect"].(bool)
+	
+	tunnelLog.Warn("服务器断开了隧道连接", "reason", reason, "reconnect", reconnect)
+	c.mu.Lock()
+	c.lastError = reason
+	c.noReconnect = !reconnect
+	c.mu.Unlock()
 }
 
 // handleHTTPRequest 处理HTTP请求
@@ -239,14 +400,34 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 	query, _ := data["query"].(string)
 	headers, _ := data["headers"].(map[string]interface{})
 	body, _ := data["body"].(string)
+	remoteAddr, _ := data["remoteAddr"].(string)
+	localAddr, _ := data["localAddr"].(string)
+	traceparent, _ := data["traceparent"].(string)
 	
-	log.Printf("处理请求: %s %s", method, url)
+	c.metrics.inFlight.Inc()
+	defer c.metrics.inFlight.Dec()
+	
+	// 服务器的 tunnel send 跨度是父上下文
+	parent, _ := tracing.ParseTraceparent(traceparent)
+	span := c.tracer.Start("client receive", tracing.SpanKindServer, parent)
+	span.SetAttributes("tunnel.request_id", requestID, "http.request.method", method, "url.path", url)
+	defer span.End()
 	
 	// 构建完整的本地URL
-	localURL := fmt.Sprintf("http://%s:%d%s", c.config.Local.Host, c.config.Local.Port, url)
-	if query != "" {
-		localURL += "?" + query
-	}
+	localURL := c.originURL(url, query)
+	
+	// 记录到请求检查器和 HAR 文件
+	recorded := headerFromMap(headers)
+	c.inspector.begin(&capturedRequest{
+		ID:         requestID,
+		Method:     method,
+		Path:       url,
+		Query:      query,
+		RemoteAddr: remoteAddr,
+		localAddr:  localAddr,
+	}, recorded, []byte(body))
+	c.capture.begin(requestID, c.publicRequestURL(recorded, url, query), method, recorded, []byte(body),
+		map[string]string{"requestId": requestID, "clientId": c.clientID, "remoteAddr": remoteAddr})
 	
 	// 创建HTTP请求
 	var reqBody io.Reader
@@ -256,6 +437,8 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 	
 	req, err := http.NewRequest(method, localURL, reqBody)
 	if err != nil {
+		span.SetError(err.Error())
+		c.metrics.originErrors.With(originErrorRequest).Inc()
 		c.sendErrorResponse(requestID, fmt.Sprintf("创建请求失败: %v", err))
 		return
 	}
@@ -269,14 +452,20 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 		}
 	}
 	
-	// 执行HTTP请求
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	// 执行HTTP请求，traceparent 指向 origin call 跨度
+	originSpan := c.tracer.Start("origin call", tracing.SpanKindClient, span.Context())
+	originSpan.SetAttributes("http.request.method", method, "url.full", logging.Redact(localURL))
+	defer originSpan.End()
+	if tp := originSpan.Traceparent(); tp != "" {
+		req.Header.Set(tracing.TraceparentHeader, tp)
 	}
-	
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := c.originClient.Do(withProxyAddrs(req, remoteAddr, localAddr))
 	if err != nil {
-		log.Printf("请求本地服务失败: %v", err)
+		originSpan.SetError(err.Error())
+		span.SetError(err.Error())
+		c.metrics.originErrors.With(originErrorConnect).Inc()
+		originLog.Warn("请求本地服务失败", "request_id", requestID, "method", method, "path", url, "error", err)
 		c.sendErrorResponse(requestID, fmt.Sprintf("请求本地服务失败: %v", err))
 		return
 	}
@@ -285,10 +474,20 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 	// 读取响应体
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("读取响应体失败: %v", err)
+		originSpan.SetError(err.Error())
+		span.SetError(err.Error())
+		c.metrics.originErrors.With(originErrorRead).Inc()
+		originLog.Warn("读取响应体失败", "request_id", requestID, "path", url, "error", err)
 		c.sendErrorResponse(requestID, fmt.Sprintf("读取响应体失败: %v", err))
 		return
 	}
+	originTime := time.Since(start)
+	c.metrics.observeOrigin(resp.StatusCode, originTime)
+	originSpan.SetAttributes("http.response.status_code", resp.StatusCode, "http.response.body.size", len(respBody))
+	originSpan.End()
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
+	c.inspector.complete(requestID, resp.StatusCode, resp.Header, respBody)
+	c.capture.complete(requestID, resp.StatusCode, resp.Header, respBody, originTime)
 	
 	// 构造响应头映射
 	responseHeaders := make(map[string]string)
@@ -306,6 +505,8 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 			"statusCode": resp.StatusCode,
 			"headers":    responseHeaders,
 			"body":       string(respBody),
+			// 请求本地服务的耗时，服务器据此区分隧道和源站的耗时
+			"originMs":   float64(originTime.Microseconds()) / 1000,
 		},
 	}
 	
@@ -313,9 +514,10 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 	if c.conn != nil {
 		err = c.conn.WriteJSON(response)
 		if err != nil {
-			log.Printf("发送响应失败: %v", err)
+			tunnelLog.Warn("发送响应失败", "request_id", requestID, "error", err)
 		} else {
-			log.Printf("响应已发送: %d %s (ID: %s)", resp.StatusCode, localURL, requestID)
+			originLog.Debug("请求完成", "client_id", c.clientID, "request_id", requestID, "method", method,
+				"path", url, "status", resp.StatusCode, "duration", time.Since(start))
 		}
 	}
 	c.mu.RUnlock()
@@ -323,6 +525,8 @@ func (c *TunnelClient) handleHTTPRequest(msg map[string]interface{}) {
 
 // sendErrorResponse 发送错误响应
 func (c *TunnelClient) sendErrorResponse(requestID, errorMsg string) {
+	c.inspector.fail(requestID, errorMsg)
+	c.capture.fail(requestID, errorMsg)
 	response := map[string]interface{}{
 		"type": "http_response",
 		"id":   requestID,
@@ -339,7 +543,7 @@ func (c *TunnelClient) sendErrorResponse(requestID, errorMsg string) {
 	c.mu.RLock()
 	if c.conn != nil {
 		c.conn.WriteJSON(response)
-		log.Printf("错误响应已发送: %s (ID: %s)", errorMsg, requestID)
+		originLog.Debug("错误响应已发送", "request_id", requestID, "error", errorMsg)
 	}
 	c.mu.RUnlock()
 }
@@ -403,7 +607,7 @@ func (c *TunnelClient) reconnect() {
 
 	// 检查是否达到最大重连次数（-1 表示无限重连）
 	if c.config.Tunnel.ReconnectAttempts > 0 && count > c.config.Tunnel.ReconnectAttempts {
-		log.Printf("达到最大重连次数 (%d)，停止重连", c.config.Tunnel.ReconnectAttempts)
+		tunnelLog.Error("达到最大重连次数，停止重连", "attempts", c.config.Tunnel.ReconnectAttempts)
 		return
 	}
 
@@ -422,22 +626,25 @@ func (c *TunnelClient) reconnect() {
 	}
 
 	if c.config.Tunnel.ReconnectAttempts > 0 {
-		log.Printf("尝试重连 (%d/%d)，等待 %v...", count, c.config.Tunnel.ReconnectAttempts, delay)
+		tunnelLog.Info("尝试重连", "attempt", count, "max", c.config.Tunnel.ReconnectAttempts, "delay", delay)
 	} else {
-		log.Printf("尝试重连 (第%d次)，等待 %v...", count, delay)
+		tunnelLog.Info("尝试重连", "attempt", count, "delay", delay)
 	}
 
 	time.Sleep(delay)
 
 	if err := c.connect(); err != nil {
-		log.Printf("重连失败: %v", err)
+		tunnelLog.Warn("重连失败", "attempt", count, "error", err)
+		c.mu.Lock()
+		c.lastError = err.Error()
+		c.mu.Unlock()
 		go c.reconnect() // 继续尝试
 	}
 }
 
 // Stop 停止客户端
 func (c *TunnelClient) Stop() {
-	log.Printf("停止隧道客户端...")
+	tunnelLog.Info("停止隧道客户端...")
 	
 	close(c.stopChan)
 	
@@ -448,7 +655,9 @@ func (c *TunnelClient) Stop() {
 	c.connected = false
 	c.mu.Unlock()
 	
-	log.Printf("隧道客户端已停止")
+	c.tracer.Shutdown()
+	c.capture.close()
+	tunnelLog.Info("隧道客户端已停止")
 }
 
 // waitForStop 等待停止信号
@@ -458,7 +667,7 @@ func (c *TunnelClient) waitForStop() {
 	
 	select {
 	case sig := <-sigChan:
-		log.Printf("收到信号 %v，正在停止...", sig)
+		tunnelLog.Info("收到信号，正在停止...", "signal", sig.String())
 		c.Stop()
 	case <-c.stopChan:
 		// 正常停止
@@ -480,6 +689,19 @@ var runCmd = &cobra.Command{
 		authToken, _ := cmd.Flags().GetString("auth-token")
 		localHost, _ := cmd.Flags().GetString("local-host")
 		localPort, _ := cmd.Flags().GetInt("local-port")
+		hostname, _ := cmd.Flags().GetString("hostname")
+		certFile, _ := cmd.Flags().GetString("cert-file")
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		proxyProtocol, _ := cmd.Flags().GetString("proxy-protocol")
+		basicAuth, _ := cmd.Flags().GetStringArray("basic-auth")
+		allowCIDRs, _ := cmd.Flags().GetStringSlice("allow-cidr")
+		oauthEmails, _ := cmd.Flags().GetStringSlice("oauth-allowed-emails")
+		metricsListen, _ := cmd.Flags().GetString("metrics")
+		logLevel, _ := cmd.Flags().GetString("log-level")
+		logFormat, _ := cmd.Flags().GetString("log-format")
+		otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint")
+		inspectListen, _ := cmd.Flags().GetString("inspect")
+		captureHAR, _ := cmd.Flags().GetString("capture-har")
 		
 		// 加载配置
 		config, err := LoadConfig(configPath)
@@ -500,11 +722,55 @@ var runCmd = &cobra.Command{
 		if localPort != 0 {
 			config.Local.Port = localPort
 		}
+		if hostname != "" {
+			config.Tunnel.Hostname = hostname
+		}
+		if certFile != "" {
+			config.Tunnel.CertFile = certFile
+		}
+		if keyFile != "" {
+			config.Tunnel.KeyFile = keyFile
+		}
+		if proxyProtocol != "" {
+			config.Local.ProxyProtocol = proxyProtocol
+		}
+		if len(basicAuth) > 0 {
+			config.Tunnel.Policy.BasicAuth = basicAuth
+		}
+		if len(allowCIDRs) > 0 {
+			config.Tunnel.Policy.AllowCIDRs = allowCIDRs
+		}
+		if len(oauthEmails) > 0 {
+			config.Tunnel.Policy.OAuthAllowedEmails = oauthEmails
+		}
+		if metricsListen != "" {
+			config.Metrics.Listen = metricsListen
+		}
+		if err := config.Log.ParseLevelSpec(logLevel); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if logFormat != "" {
+			config.Log.Format = logFormat
+		}
+		if err := logging.Setup(config.Log); err != nil {
+			log.Fatalf("日志配置错误: %v", err)
+		}
+		if otlpEndpoint != "" {
+			config.Tracing.Enabled = true
+			config.Tracing.Endpoint = otlpEndpoint
+		}
+		if inspectListen != "" {
+			config.Inspect.Listen = inspectListen
+		}
+		if captureHAR != "" {
+			config.Capture.HAR = captureHAR
+		}
 		
 		// 创建并启动客户端
 		client := NewTunnelClient(config)
 		if err := client.Start(); err != nil {
-			log.Fatalf("启动客户端失败: %v", err)
+			tunnelLog.Error("启动客户端失败", "error", err)
+			os.Exit(1)
 		}
 	},
 }
@@ -525,15 +791,51 @@ var initConfigCmd = &cobra.Command{
 				"reconnectAttempts":  -1,     // -1 表示无限重连，设为正数则限制重连次数
 				"reconnectDelay":     1000,   // 基础重连延迟1秒
 				"maxReconnectDelay":  60000,  // 最大重连延迟60秒
+				"hostname":           "",     // 可选：公网主机名，如 myapp.windy.run
 				// WSS/TLS 配置
-				"insecureSkipVerify": true,   // 自签名证书时设为true
+				"insecureSkipVerify": false,  // 不安全：跳过证书校验，自签名证书请改用 caCertFile
 				"serverName":         "",     // 可选：指定服务器名称
-				"caCertFile":         "",     // 可选：CA证书文件路径
+				"caCertFile":         "",     // 可选：CA证书文件路径 (自签名部署)
+				"pinnedSpki":         []string{}, // 可选：服务器公钥指纹，使用 config pin 生成
+				"certFile":           "",     // 可选：客户端证书 (mTLS)
+				"keyFile":            "",     // 可选：客户端私钥 (mTLS)
+				// 可选：要求服务器为本隧道执行的访问策略
+				"policy": map[string]interface{}{
+					"basicAuth":          []string{}, // "user:password"
+					"allowCidrs":         []string{},
+					"oauthAllowedEmails": []string{},
+				},
 			},
 			"local": map[string]interface{}{
 				"host": "localhost",
 				"port": 3000,
 			},
+			// 日志级别 (debug/info/warn/error) 和格式 (text/json)
+			"log": map[string]interface{}{
+				"level":  "info",
+				"format": "text",
+			},
+			// 可选：本地指标 (/metrics) 和就绪检查 (/ready)
+			"metrics": map[string]interface{}{
+				"listen": "",     // 如 127.0.0.1:20241
+			},
+			// 可选：链路追踪，导出到 OTLP/HTTP 收集器
+			"tracing": map[string]interface{}{
+				"enabled":  false,
+				"endpoint": "http://localhost:4318",
+			},
+			// 可选：请求检查器，在本地网页中查看和重放最近的请求
+			"inspect": map[string]interface{}{
+				"listen":      "",     // 如 127.0.0.1:4040
+				"maxRequests": 100,
+				"maxBodySize": 65536,
+			},
+			// 可选：把请求写入 HAR 文件 (Authorization、Cookie 等头部总是隐去)
+			"capture": map[string]interface{}{
+				"har":           "",     // 如 tunnel.har
+				"maxBodySize":   65536,
+				"redactHeaders": []string{},
+			},
 		}
 		
 		data, _ := json.MarshalIndent(config, "", "  ")
@@ -563,6 +865,22 @@ var showConfigCmd = &cobra.Command{
 	},
 }
 
+var pinConfigCmd = &cobra.Command{
+	Use:   "pin [证书文件]",
+	Short: "计算证书的公钥指纹 (用于 pinnedSpki)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		certs, err := readCertificateFile(args[0])
+		if err != nil {
+			log.Fatalf("读取证书失败: %v", err)
+		}
+		
+		for _, cert := range certs {
+			fmt.Printf("%s\n  sha256/%s\n", cert.Subject.CommonName, spkiPin(cert))
+		}
+	},
+}
+
 func init() {
 	// run 命令标志
 	runCmd.Flags().StringP("config", "c", "", "配置文件路径")
@@ -570,12 +888,25 @@ func init() {
 	runCmd.Flags().String("auth-token", "", "认证令牌")
 	runCmd.Flags().String("local-host", "", "本地服务主机")
 	runCmd.Flags().Int("local-port", 0, "本地服务端口")
+	runCmd.Flags().String("proxy-protocol", "", "向本地服务发送 PROXY protocol 头部 (v1/v2)")
+	runCmd.Flags().String("hostname", "", "公网主机名")
+	runCmd.Flags().String("cert-file", "", "客户端证书文件 (mTLS)")
+	runCmd.Flags().String("key-file", "", "客户端私钥文件 (mTLS)")
+	runCmd.Flags().StringArray("basic-auth", nil, "要求访客通过基本认证 (user:password，可重复)")
+	runCmd.Flags().StringSlice("allow-cidr", nil, "只允许这些地址访问 (CIDR 或 IP，可重复)")
+	runCmd.Flags().StringSlice("oauth-allowed-emails", nil, "要求访客通过服务器的身份提供者登录，只允许这些邮箱 (@example.com 表示整个域名)")
+	runCmd.Flags().String("metrics", "", "本地指标和状态接口监听地址 (如 127.0.0.1:20241)")
+	runCmd.Flags().String("log-level", "", "日志级别，可按组件覆盖 (如 info,origin=debug)")
+	runCmd.Flags().String("log-format", "", "日志格式 (text/json)")
+	runCmd.Flags().String("otlp-endpoint", "", "启用链路追踪，导出到此 OTLP/HTTP 地址 (如 http://localhost:4318)")
+	runCmd.Flags().String("inspect", "", "启用请求检查器，在此地址提供网页 (如 127.0.0.1:4040)")
+	runCmd.Flags().String("capture-har", "", "把经过隧道的请求写入此 HAR 文件")
 	
 	// config 命令标志
 	configCmd.PersistentFlags().StringP("config", "c", "", "配置文件路径")
 	
 	// 添加子命令
-	configCmd.AddCommand(initConfigCmd, showConfigCmd)
+	configCmd.AddCommand(initConfigCmd, showConfigCmd, pinConfigCmd)
 	rootCmd.AddCommand(runCmd, configCmd)
 }
 
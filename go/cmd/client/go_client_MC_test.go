@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"tunnel/internal/testutil"
+	"tunnel/internal/tracing"
+)
+
+// newOriginTestClient 创建请求 origin 的客户端 (没有隧道连接，响应不回传)
+func newOriginTestClient(t *testing.T, origin *httptest.Server, cfg tracing.Config) *TunnelClient {
+	t.Helper()
+	config := DefaultConfig()
+	host, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+	config.Local.Host = host
+	config.Local.Port, _ = strconv.Atoi(port)
+	config.Tracing = cfg
+
+	c := NewTunnelClient(config)
+	c.originClient = origin.Client()
+	var err error
+	if c.tracer, err = tracing.New(cfg, "tunnel-client"); err != nil {
+		t.Fatalf("创建 Tracer 失败: %v", err)
+	}
+	return c
+}
+
+func httpRequestMsg(traceparent string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "http_request",
+		"id":   "req_1",
+		"data": map[string]interface{}{
+			"method":      http.MethodGet,
+			"url":         "/path",
+			"headers":     map[string]interface{}{"Accept": "*/*"},
+			"traceparent": traceparent,
+		},
+	}
+}
+
+func TestHandleHTTPRequestPropagatesTraceparent(t *testing.T) {
+	const server = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var received string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(tracing.TraceparentHeader)
+	}))
+	defer origin.Close()
+	collector := testutil.NewMockCollector(t)
+	c := newOriginTestClient(t, origin, tracing.Config{Enabled: true, Endpoint: collector.URL})
+
+	c.handleHTTPRequest(httpRequestMsg(server))
+	c.tracer.Shutdown()
+
+	receive, call := collector.Span("client receive"), collector.Span("origin call")
+	if receive == nil || call == nil {
+		t.Fatalf("缺少跨度: %+v", collector.Spans())
+	}
+	if receive.Service != "tunnel-client" || receive.TraceID != server[3:35] || receive.ParentID != server[36:52] {
+		t.Errorf("client receive 应以服务器的 tunnel send 为父跨度: %+v", receive)
+	}
+	if call.TraceID != receive.TraceID || call.ParentID != receive.SpanID {
+		t.Errorf("origin call 应为 client receive 的子跨度: %+v", call)
+	}
+	if call.Attrs["http.response.status_code"] != "200" {
+		t.Errorf("origin call 应记录源站的状态码: %v", call.Attrs)
+	}
+	if want := "00-" + call.TraceID + "-" + call.SpanID + "-01"; received != want {
+		t.Errorf("源站收到的 traceparent = %q, 期望指向 origin call 的 %q", received, want)
+	}
+}
+
+func TestHandleHTTPRequestOriginErrorSpan(t *testing.T) {
+	origin := httptest.NewServer(http.NotFoundHandler())
+	origin.Close() // 连接失败
+	collector := testutil.NewMockCollector(t)
+	c := newOriginTestClient(t, origin, tracing.Config{Enabled: true, Endpoint: collector.URL})
+
+	c.handleHTTPRequest(httpRequestMsg(""))
+	c.tracer.Shutdown()
+
+	receive, call := collector.Span("client receive"), collector.Span("origin call")
+	if receive == nil || call == nil {
+		t.Fatalf("缺少跨度: %+v", collector.Spans())
+	}
+	if receive.ParentID != "" {
+		t.Errorf("没有服务器的上下文时 client receive 应为根跨度: %+v", receive)
+	}
+	if receive.Error == "" || call.Error == "" {
+		t.Errorf("连接源站失败时跨度应标记错误: %+v %+v", receive, call)
+	}
+}
+
+func TestHandleHTTPRequestWithoutTracing(t *testing.T) {
+	const server = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	var received string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(tracing.TraceparentHeader)
+	}))
+	defer origin.Close()
+	c := newOriginTestClient(t, origin, tracing.Config{})
+
+	c.handleHTTPRequest(httpRequestMsg(server))
+	if received != server {
+		t.Errorf("未启用追踪时应把服务器的 traceparent 原样传给源站，得到 %q", received)
+	}
+
+	received = "unset"
+	c.handleHTTPRequest(httpRequestMsg(""))
+	if received != "" {
+		t.Errorf("没有追踪上下文时不应发送 traceparent，得到 %q", received)
+	}
+}
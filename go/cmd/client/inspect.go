@@ -0,0 +1,529 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed inspector
+var inspectorFiles embed.FS
+
+// 请求状态
+const (
+	capturePending   = "pending"
+	captureCompleted = "completed"
+	captureFailed    = "failed"
+)
+
+// capturedMessage 记录的请求或响应，正文超过上限时只保留开头
+type capturedMessage struct {
+	Headers   http.Header `json:"headers"`
+	Body      string      `json:"body,omitempty"`
+	Encoding  string      `json:"encoding,omitempty"` // 正文不是 UTF-8 时为 base64
+	Size      int         `json:"size"`               // 原始正文大小
+	Truncated bool        `json:"truncated,omitempty"`
+
+	raw []byte // 保留的正文，重放时使用
+}
+
+// capturedRequest 检查器中的一个请求。ID 为隧道的请求 ID，重放的请求为 replay_*
+type capturedRequest struct {
+	ID         string           `json:"id"`
+	ReplayOf   string           `json:"replayOf,omitempty"`
+	StartedAt  time.Time        `json:"startedAt"`
+	DurationMs float64          `json:"durationMs"`
+	State      string           `json:"state"`
+	Method     string           `json:"method"`
+	Path       string           `json:"path"`
+	Query      string           `json:"query,omitempty"`
+	RemoteAddr string           `json:"remoteAddr,omitempty"`
+	Status     int              `json:"status,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Request    capturedMessage  `json:"request"`
+	Response   *capturedMessage `json:"response,omitempty"`
+
+	localAddr string
+}
+
+// summary 不含正文的副本，用于列表和事件
+func (r *capturedRequest) summary() capturedRequest {
+	s := *r
+	s.Request.Body, s.Request.raw = "", nil
+	if r.Response != nil {
+		resp := *r.Response
+		resp.Body, resp.raw = "", nil
+		s.Response = &resp
+	}
+	return s
+}
+
+// inspector 最近请求的环形缓冲区，未启用时为 nil，所有方法都可以安全调用
+type inspector struct {
+	maxRequests int
+	maxBodySize int
+
+	mu          sync.Mutex
+	requests    []*capturedRequest // 从旧到新
+	subscribers map[chan capturedRequest]struct{}
+}
+
+func newInspector(maxRequests, maxBodySize int) *inspector {
+	return &inspector{
+		maxRequests: maxRequests,
+		maxBodySize: maxBodySize,
+		subscribers: make(map[chan capturedRequest]struct{}),
+	}
+}
+
+// capture 按上限截取正文
+func (in *inspector) capture(headers http.Header, body []byte) capturedMessage {
+	m := capturedMessage{Headers: headers, Size: len(body)}
+	if len(body) > in.maxBodySize {
+		body, m.Truncated = body[:in.maxBodySize], true
+	}
+	m.raw = body
+	if utf8.Valid(body) {
+		m.Body = string(body)
+	} else {
+		m.Body, m.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return m
+}
+
+// begin 记录开始的请求，超出数量时丢弃最旧的请求
+func (in *inspector) begin(r *capturedRequest, headers http.Header, body []byte) {
+	if in == nil {
+		return
+	}
+	r.StartedAt, r.State = time.Now(), capturePending
+	r.Request = in.capture(headers, body)
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.requests = append(in.requests, r)
+	if len(in.requests) > in.maxRequests {
+		in.requests = in.requests[len(in.requests)-in.maxRequests:]
+	}
+	in.publish(r)
+}
+
+// complete 记录本地服务的响应
+func (in *inspector) complete(id string, status int, headers http.Header, body []byte) {
+	if in == nil {
+		return
+	}
+	resp := in.capture(headers, body)
+	in.update(id, func(r *capturedRequest) {
+		r.State, r.Status, r.Response = captureCompleted, status, &resp
+	})
+}
+
+// fail 记录请求失败 (无法创建请求、连接或读取本地服务失败)
+func (in *inspector) fail(id, message string) {
+	if in == nil {
+		return
+	}
+	in.update(id, func(r *capturedRequest) {
+		r.State, r.Error = captureFailed, message
+	})
+}
+
+func (in *inspector) update(id string, fn func(r *capturedRequest)) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for i := len(in.requests) - 1; i >= 0; i-- {
+		if r := in.requests[i]; r.ID == id {
+			fn(r)
+			r.DurationMs = float64(time.Since(r.StartedAt).Microseconds()) / 1000
+			in.publish(r)
+			return
+		}
+	}
+}
+
+// publish 通知订阅者，调用方需持有 mu
+func (in *inspector) publish(r *capturedRequest) {
+	s := r.summary()
+	for ch := range in.subscribers {
+		select {
+		case ch <- s:
+		default: // 页面处理不过来时丢弃，刷新后可以重新获取列表
+		}
+	}
+}
+
+// get 返回请求的副本
+func (in *inspector) get(id string) (capturedRequest, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, r := range in.requests {
+		if r.ID == id {
+			c := *r
+			if r.Response != nil {
+				resp := *r.Response
+				c.Response = &resp
+			}
+			return c, true
+		}
+	}
+	return capturedRequest{}, false
+}
+
+// requestFilter 列表过滤条件，空字段表示不过滤
+type requestFilter struct {
+	method string
+	status string // 状态码 (如 404)、状态类 (如 5xx) 或 failed / pending
+	path   string
+	text   string // 在路径、查询、请求头和正文中搜索
+}
+
+func (f requestFilter) matches(r *capturedRequest) bool {
+	if f.method != "" && !strings.EqualFold(f.method, r.Method) {
+		return false
+	}
+	switch status := strings.ToLower(f.status); {
+	case status == "":
+	case status == captureFailed || status == capturePending:
+		if r.State != status {
+			return false
+		}
+	case len(status) == 3 && strings.HasSuffix(status, "xx"):
+		if r.Status/100 != int(status[0]-'0') {
+			return false
+		}
+	default:
+		if strconv.Itoa(r.Status) != status {
+			return false
+		}
+	}
+	if f.path != "" && !strings.Contains(r.Path, f.path) {
+		return false
+	}
+	if f.text != "" {
+		haystack := []string{r.Path, r.Query, r.Request.Body, r.Error}
+		for k, v := range r.Request.Headers {
+			haystack = append(haystack, k+": "+strings.Join(v, ", "))
+		}
+		if r.Response != nil {
+			haystack = append(haystack, r.Response.Body)
+			for k, v := range r.Response.Headers {
+				haystack = append(haystack, k+": "+strings.Join(v, ", "))
+			}
+		}
+		text := strings.ToLower(f.text)
+		for _, s := range haystack {
+			if strings.Contains(strings.ToLower(s), text) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// list 符合条件的请求摘要，从新到旧
+func (in *inspector) list(f requestFilter) []capturedRequest {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	result := make([]capturedRequest, 0, len(in.requests))
+	for i := len(in.requests) - 1; i >= 0; i-- {
+		if f.matches(in.requests[i]) {
+			result = append(result, in.requests[i].summary())
+		}
+	}
+	return result
+}
+
+func (in *inspector) clear() {
+	in.mu.Lock()
+	in.requests = nil
+	in.mu.Unlock()
+}
+
+func (in *inspector) subscribe() chan capturedRequest {
+	ch := make(chan capturedRequest, 64)
+	in.mu.Lock()
+	in.subscribers[ch] = struct{}{}
+	in.mu.Unlock()
+	return ch
+}
+
+func (in *inspector) unsubscribe(ch chan capturedRequest) {
+	in.mu.Lock()
+	delete(in.subscribers, ch)
+	in.mu.Unlock()
+}
+
+// headerFromMap 把隧道消息中的请求头转换为 http.Header
+func headerFromMap(headers map[string]interface{}) http.Header {
+	h := make(http.Header, len(headers))
+	for k, v := range headers {
+		if str, ok := v.(string); ok {
+			h.Set(k, str)
+		}
+	}
+	return h
+}
+
+// replayRequest 重放时的修改，未设置的字段沿用原请求
+type replayRequest struct {
+	Method  *string           `json:"method"`
+	Path    *string           `json:"path"`
+	Query   *string           `json:"query"`
+	Headers map[string]string `json:"headers"` // 设置时替换全部请求头
+	Body    *string           `json:"body"`
+}
+
+// replay 把记录的请求 (可修改) 重新发送到本地服务，结果作为新的请求记录
+func (c *TunnelClient) replay(orig capturedRequest, edits replayRequest) (capturedRequest, error) {
+	r := &capturedRequest{
+		ID:         fmt.Sprintf("replay_%d", time.Now().UnixNano()),
+		ReplayOf:   orig.ID,
+		Method:     orig.Method,
+		Path:       orig.Path,
+		Query:      orig.Query,
+		RemoteAddr: orig.RemoteAddr,
+		localAddr:  orig.localAddr,
+	}
+	headers := orig.Request.Headers.Clone()
+	body := orig.Request.raw
+	if edits.Method != nil {
+		r.Method = strings.ToUpper(strings.TrimSpace(*edits.Method))
+	}
+	if edits.Path != nil {
+		r.Path = *edits.Path
+		if !strings.HasPrefix(r.Path, "/") {
+			r.Path = "/" + r.Path
+		}
+	}
+	if edits.Query != nil {
+		r.Query = strings.TrimPrefix(*edits.Query, "?")
+	}
+	if edits.Headers != nil {
+		headers = make(http.Header, len(edits.Headers))
+		for k, v := range edits.Headers {
+			headers.Set(k, v)
+		}
+	}
+	if edits.Body != nil {
+		body = []byte(*edits.Body)
+	} else if orig.Request.Truncated {
+		return capturedRequest{}, fmt.Errorf("原请求的正文已被截断 (%d 字节)，请提供 body", orig.Request.Size)
+	}
+
+	req, err := http.NewRequest(r.Method, c.originURL(r.Path, r.Query), strings.NewReader(string(body)))
+	if err != nil {
+		return capturedRequest{}, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header = headers.Clone()
+	// 客户端会根据正文重新计算长度
+	req.Header.Del("Content-Length")
+
+	c.inspector.begin(r, headers, body)
+	originLog.Info("重放请求", "request_id", r.ID, "replay_of", orig.ID, "method", r.Method, "path", r.Path)
+	resp, err := c.originClient.Do(withProxyAddrs(req, r.RemoteAddr, r.localAddr))
+	if err != nil {
+		c.inspector.fail(r.ID, fmt.Sprintf("请求本地服务失败: %v", err))
+	} else {
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			c.inspector.fail(r.ID, fmt.Sprintf("读取响应体失败: %v", err))
+		} else {
+			c.inspector.complete(r.ID, resp.StatusCode, resp.Header, respBody)
+		}
+	}
+	result, _ := c.inspector.get(r.ID)
+	return result, nil
+}
+
+// registerInspector 注册检查器的页面和接口
+//
+//	GET    /api/requests               请求列表 (?method= &status= &path= &q=)
+//	DELETE /api/requests               清空
+//	GET    /api/requests/{id}          请求详情 (含正文)
+//	POST   /api/requests/{id}/replay   重放，请求体为可选的修改
+//	GET    /api/events                 新请求和请求完成的通知 (SSE)
+func (c *TunnelClient) registerInspector(mux *http.ServeMux) {
+	files, _ := fs.Sub(inspectorFiles, "inspector")
+	mux.Handle("/", c.inspectGuard(http.FileServer(http.FS(files)).ServeHTTP))
+	mux.HandleFunc("/api/requests", c.inspectGuard(c.handleInspectList))
+	mux.HandleFunc("/api/requests/", c.inspectGuard(c.handleInspectRequest))
+	mux.HandleFunc("/api/events", c.inspectGuard(c.handleInspectEvents))
+}
+
+// inspectGuard 检查器没有认证，拒绝 DNS 重绑定的页面 (Host 不是本机或监听地址) 和跨站请求
+// (Origin 与 Host 不一致，或 POST 的请求体不是 JSON，后者可以由跨站表单发送)
+func (c *TunnelClient) inspectGuard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !c.inspectHostAllowed(r.Host) {
+			inspectError(w, http.StatusForbidden, "不允许的 Host: "+r.Host)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+				inspectError(w, http.StatusForbidden, "不允许跨站请求: "+origin)
+				return
+			}
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+				inspectError(w, http.StatusUnsupportedMediaType, "请求体必须是 application/json")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// inspectHostAllowed Host 为 IP 地址、localhost 或监听地址的主机名时允许；
+// DNS 重绑定的页面使用攻击者的域名，会被拒绝
+func (c *TunnelClient) inspectHostAllowed(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if host == "" {
+		return false
+	}
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") {
+		return true
+	}
+	listenHost, _, _ := net.SplitHostPort(c.config.Inspect.Listen)
+	return strings.EqualFold(host, listenHost)
+}
+
+// startInspectServer 启动检查器，监听失败时返回错误
+func (c *TunnelClient) startInspectServer() error {
+	mux := http.NewServeMux()
+	c.registerInspector(mux)
+
+	listener, err := net.Listen("tcp", c.config.Inspect.Listen)
+	if err != nil {
+		return fmt.Errorf("请求检查器监听失败: %v", err)
+	}
+	c.logInspectorStarted(listener.Addr().String())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			tunnelLog.Error("请求检查器已停止", "error", err)
+		}
+	}()
+	return nil
+}
+
+// logInspectorStarted 检查器会显示完整的请求头和正文，监听非本机地址时提醒
+func (c *TunnelClient) logInspectorStarted(addr string) {
+	tunnelLog.Info("请求检查器已启动", "url", fmt.Sprintf("http://%s/", addr),
+		"max_requests", c.inspector.maxRequests, "max_body_size", c.inspector.maxBodySize)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			tunnelLog.Warn("请求检查器没有认证，并会显示请求中的凭据，建议只监听 127.0.0.1", "listen", addr)
+		}
+	}
+}
+
+func inspectJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func inspectError(w http.ResponseWriter, status int, message string) {
+	inspectJSON(w, status, map[string]string{"error": message})
+}
+
+func (c *TunnelClient) handleInspectList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		inspectJSON(w, http.StatusOK, c.inspector.list(requestFilter{
+			method: q.Get("method"),
+			status: q.Get("status"),
+			path:   q.Get("path"),
+			text:   q.Get("q"),
+		}))
+	case http.MethodDelete:
+		c.inspector.clear()
+		inspectJSON(w, http.StatusOK, map[string]bool{"cleared": true})
+	default:
+		inspectError(w, http.StatusMethodNotAllowed, "不支持的方法")
+	}
+}
+
+func (c *TunnelClient) handleInspectRequest(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/requests/"), "/")
+	orig, ok := c.inspector.get(id)
+	if !ok {
+		inspectError(w, http.StatusNotFound, "请求不存在: "+id)
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		inspectJSON(w, http.StatusOK, orig)
+	case action == "replay" && r.Method == http.MethodPost:
+		var edits replayRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&edits); err != nil && err != io.EOF {
+				inspectError(w, http.StatusBadRequest, "请求格式错误: "+err.Error())
+				return
+			}
+		}
+		result, err := c.replay(orig, edits)
+		if err != nil {
+			inspectError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		inspectJSON(w, http.StatusOK, result)
+	default:
+		inspectError(w, http.StatusNotFound, "未知的接口")
+	}
+}
+
+// handleInspectEvents 以 SSE 推送请求摘要，请求开始和完成时各发送一次 request 事件
+func (c *TunnelClient) handleInspectEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		inspectError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := c.inspector.subscribe()
+	defer c.inspector.unsubscribe(ch)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case req := <-ch:
+			data, _ := json.Marshal(req)
+			if _, err := fmt.Fprintf(w, "event: request\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
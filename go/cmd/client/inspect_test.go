@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInspectorRejectsCrossSiteRequests(t *testing.T) {
+	var replayed int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replayed++
+	}))
+	defer origin.Close()
+	c := newOriginTestClient(t, origin, DefaultConfig().Tracing)
+	c.config.Inspect.Listen = "devbox.lan:4040"
+	c.inspector = newInspector(10, 1024)
+	c.inspector.begin(&capturedRequest{ID: "req_1", Method: http.MethodGet, Path: "/hook"}, http.Header{}, nil)
+	mux := http.NewServeMux()
+	c.registerInspector(mux)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{"本机地址", http.MethodGet, "/api/requests", "127.0.0.1:4040", "", "", http.StatusOK},
+		{"localhost", http.MethodGet, "/api/requests/req_1", "localhost:4040", "", "", http.StatusOK},
+		{"IPv6", http.MethodGet, "/api/requests", "[::1]:4040", "", "", http.StatusOK},
+		{"监听主机名", http.MethodGet, "/api/requests", "devbox.lan:4040", "", "", http.StatusOK},
+		{"DNS 重绑定", http.MethodGet, "/api/requests", "attacker.example:4040", "", "", http.StatusForbidden},
+		{"DNS 重绑定的页面", http.MethodGet, "/", "attacker.example:4040", "", "", http.StatusForbidden},
+		{"跨站 Origin", http.MethodGet, "/api/requests", "127.0.0.1:4040", "http://attacker.example", "", http.StatusForbidden},
+		{"跨站表单", http.MethodPost, "/api/requests/req_1/replay", "127.0.0.1:4040", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"没有 Content-Type", http.MethodPost, "/api/requests/req_1/replay", "127.0.0.1:4040", "", "", http.StatusUnsupportedMediaType},
+		{"同源重放", http.MethodPost, "/api/requests/req_1/replay", "127.0.0.1:4040", "http://127.0.0.1:4040", "application/json; charset=utf-8", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}"))
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("状态码 = %d, 期望 %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if replayed != 1 {
+		t.Errorf("只有同源的 JSON 请求应触发重放，源站收到 %d 次", replayed)
+	}
+}
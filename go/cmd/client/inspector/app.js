@@ -0,0 +1,341 @@
+// 请求检查器：列表通过 /api/events (SSE) 实时更新，详情、重放和比较调用 /api/requests。
+(function () {
+  "use strict";
+
+  var requests = [];     // 当前过滤条件下的请求摘要，从新到旧
+  var selected = null;   // 当前显示的完整请求
+  var checked = [];      // 勾选用于比较的请求 ID (最多两个)
+  var reloadTimer = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === "onclick") node.onclick = attrs[k];
+      else if (k === "checked") node.checked = !!attrs[k];
+      else node.setAttribute(k, attrs[k]);
+    });
+    [].concat(children === undefined ? [] : children).forEach(function (c) {
+      if (c === null || c === undefined) return;
+      node.appendChild(typeof c === "object" ? c : document.createTextNode(String(c)));
+    });
+    return node;
+  }
+
+  function api(method, path, body) {
+    var init = { method: method, headers: {} };
+    if (body !== undefined) {
+      init.body = JSON.stringify(body);
+      init.headers["Content-Type"] = "application/json";
+    }
+    return fetch(path, init).then(function (resp) {
+      return resp.json().catch(function () { return {}; }).then(function (data) {
+        if (!resp.ok) throw new Error(data.error || resp.statusText);
+        return data;
+      });
+    });
+  }
+
+  function fail(err) { window.alert(err.message); }
+
+  function time(value) {
+    var t = new Date(value);
+    return isNaN(t) ? "-" : t.toLocaleTimeString();
+  }
+
+  function bytes(n) {
+    if (n < 1024) return n + " B";
+    if (n < 1024 * 1024) return (n / 1024).toFixed(1) + " KB";
+    return (n / 1024 / 1024).toFixed(1) + " MB";
+  }
+
+  function statusBadge(r) {
+    if (r.state === "pending") return el("span", { class: "code pending" }, "…");
+    if (r.state === "failed") return el("span", { class: "code failed" }, "失败");
+    return el("span", { class: "code c" + Math.floor(r.status / 100) }, r.status);
+  }
+
+  function fullPath(r) {
+    return r.path + (r.query ? "?" + r.query : "");
+  }
+
+  // ---- 列表 ----
+
+  function filterQuery() {
+    var q = new URLSearchParams();
+    [["method", "f-method"], ["status", "f-status"], ["path", "f-path"], ["q", "f-text"]].forEach(function (p) {
+      var v = $(p[1]).value.trim();
+      if (v) q.set(p[0], v);
+    });
+    return q.toString();
+  }
+
+  function loadList() {
+    return api("GET", "/api/requests?" + filterQuery()).then(function (list) {
+      requests = list || [];
+      renderList();
+    }).catch(function () {});
+  }
+
+  function scheduleReload() {
+    clearTimeout(reloadTimer);
+    reloadTimer = setTimeout(loadList, 150);
+  }
+
+  function renderList() {
+    var list = $("list");
+    list.textContent = "";
+    $("empty").hidden = requests.length > 0;
+    checked = checked.filter(function (id) { return requests.some(function (r) { return r.id === id; }); });
+    $("compare").disabled = checked.length !== 2;
+    requests.forEach(function (r) {
+      var box = el("input", { type: "checkbox", title: "选择用于比较", checked: checked.indexOf(r.id) >= 0 });
+      box.onclick = function (e) {
+        e.stopPropagation();
+        if (box.checked) {
+          checked.push(r.id);
+          if (checked.length > 2) checked.shift();
+        } else {
+          checked = checked.filter(function (id) { return id !== r.id; });
+        }
+        renderList();
+      };
+      var li = el("li", { class: selected && selected.id === r.id ? "selected" : "" }, [
+        box,
+        el("span", { class: "method" }, r.method),
+        el("span", { class: "path", title: fullPath(r) }, fullPath(r)),
+        r.replayOf ? el("span", { class: "tag" }, "重放") : null,
+        statusBadge(r),
+        el("span", { class: "time" }, time(r.startedAt))
+      ]);
+      li.onclick = function () { show(r.id); };
+      list.appendChild(li);
+    });
+  }
+
+  // ---- 详情 ----
+
+  function show(id) {
+    return api("GET", "/api/requests/" + encodeURIComponent(id)).then(function (r) {
+      selected = r;
+      renderDetail();
+      renderList();
+    }).catch(fail);
+  }
+
+  function renderHeaders(table, headers) {
+    table.textContent = "";
+    Object.keys(headers || {}).sort().forEach(function (k) {
+      headers[k].forEach(function (v) {
+        table.appendChild(el("tr", {}, [el("td", {}, k), el("td", {}, v)]));
+      });
+    });
+  }
+
+  // bodyText 正文的显示文本，JSON 会格式化
+  function bodyText(msg) {
+    if (!msg || msg.size === 0) return "";
+    var text = msg.body || "";
+    if (msg.encoding === "base64") {
+      text = "(二进制正文，以 base64 显示)\n" + text;
+    } else {
+      var type = ((msg.headers || {})["Content-Type"] || [""])[0];
+      if (/json/i.test(type) && !msg.truncated) {
+        try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (e) { /* 保持原样 */ }
+      }
+    }
+    if (msg.truncated) text += "\n\n… 正文共 " + bytes(msg.size) + "，只保留了开头";
+    return text;
+  }
+
+  function renderDetail() {
+    var r = selected;
+    $("placeholder").hidden = true;
+    $("diff").hidden = true;
+    $("detail").hidden = false;
+    $("editor").hidden = true;
+    $("d-title").textContent = r.method + " " + fullPath(r);
+    var meta = [time(r.startedAt), r.state === "pending" ? "进行中" : r.durationMs.toFixed(1) + " ms", r.id];
+    if (r.remoteAddr) meta.push("访客 " + r.remoteAddr);
+    if (r.replayOf) meta.push("重放自 " + r.replayOf);
+    if (r.response) meta.unshift("状态 " + r.status);
+    $("d-meta").textContent = meta.join(" · ");
+    $("d-error").textContent = r.error || "";
+    $("diff-original").hidden = !r.replayOf;
+
+    renderHeaders($("d-req-headers"), r.request.headers);
+    $("d-req-body").textContent = bodyText(r.request);
+    renderHeaders($("d-resp-headers"), r.response ? r.response.headers : {});
+    $("d-resp-body").textContent = bodyText(r.response);
+  }
+
+  function replay(edits) {
+    return api("POST", "/api/requests/" + encodeURIComponent(selected.id) + "/replay", edits).then(function (r) {
+      selected = r;
+      renderDetail();
+      return loadList();
+    }).catch(fail);
+  }
+
+  function openEditor() {
+    var r = selected;
+    $("e-method").value = r.method;
+    $("e-path").value = r.path;
+    $("e-query").value = r.query || "";
+    var lines = [];
+    Object.keys(r.request.headers || {}).sort().forEach(function (k) {
+      r.request.headers[k].forEach(function (v) { lines.push(k + ": " + v); });
+    });
+    $("e-headers").value = lines.join("\n");
+    var binary = r.request.encoding === "base64";
+    $("e-body").value = binary ? "" : r.request.body || "";
+    $("e-body").disabled = binary;
+    $("e-note").textContent = binary ? "原请求的正文不是文本，重放时保持不变。"
+      : r.request.truncated ? "原请求的正文已被截断，发送的是编辑框中的内容。" : "";
+    $("editor").hidden = false;
+    $("e-path").focus();
+  }
+
+  function submitEditor(e) {
+    e.preventDefault();
+    var headers = {};
+    $("e-headers").value.split("\n").forEach(function (line) {
+      var i = line.indexOf(":");
+      if (i > 0) headers[line.slice(0, i).trim()] = line.slice(i + 1).trim();
+    });
+    var edits = {
+      method: $("e-method").value,
+      path: $("e-path").value,
+      query: $("e-query").value,
+      headers: headers
+    };
+    if (!$("e-body").disabled) edits.body = $("e-body").value;
+    replay(edits);
+  }
+
+  // ---- 比较 ----
+
+  function messageText(r, which) {
+    var msg = which === "request" ? r.request : r.response;
+    var lines = [];
+    if (which === "request") lines.push(r.method + " " + fullPath(r));
+    else if (r.response) lines.push("HTTP " + r.status);
+    else lines.push(r.error ? "错误: " + r.error : "(没有响应)");
+    if (msg) {
+      Object.keys(msg.headers || {}).sort().forEach(function (k) {
+        msg.headers[k].forEach(function (v) { lines.push(k + ": " + v); });
+      });
+      var body = bodyText(msg);
+      if (body) lines.push("", body);
+    }
+    return lines.join("\n").split("\n");
+  }
+
+  // diffLines 按最长公共子序列比较，过长时逐行对比
+  function diffLines(a, b) {
+    var out = [];
+    if (a.length * b.length > 4e6) {
+      for (var k = 0; k < Math.max(a.length, b.length); k++) {
+        if (a[k] === b[k]) out.push([" ", a[k]]);
+        else {
+          if (k < a.length) out.push(["-", a[k]]);
+          if (k < b.length) out.push(["+", b[k]]);
+        }
+      }
+      return out;
+    }
+    var n = a.length, m = b.length, i, j;
+    var lcs = [];
+    for (i = 0; i <= n; i++) lcs.push(new Uint32Array(m + 1));
+    for (i = n - 1; i >= 0; i--) {
+      for (j = m - 1; j >= 0; j--) {
+        lcs[i][j] = a[i] === b[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+      }
+    }
+    i = 0; j = 0;
+    while (i < n && j < m) {
+      if (a[i] === b[j]) { out.push([" ", a[i]]); i++; j++; }
+      else if (lcs[i + 1][j] >= lcs[i][j + 1]) { out.push(["-", a[i]]); i++; }
+      else { out.push(["+", b[j]]); j++; }
+    }
+    for (; i < n; i++) out.push(["-", a[i]]);
+    for (; j < m; j++) out.push(["+", b[j]]);
+    return out;
+  }
+
+  function renderDiff(pre, a, b) {
+    pre.textContent = "";
+    diffLines(a, b).forEach(function (d) {
+      var cls = d[0] === "+" ? "add" : d[0] === "-" ? "del" : "";
+      pre.appendChild(el("span", { class: cls }, d[0] + " " + d[1]));
+    });
+  }
+
+  function compare(idA, idB) {
+    Promise.all([idA, idB].map(function (id) {
+      return api("GET", "/api/requests/" + encodeURIComponent(id));
+    })).then(function (rs) {
+      // 旧的请求在前
+      if (new Date(rs[0].startedAt) > new Date(rs[1].startedAt)) rs.reverse();
+      $("placeholder").hidden = true;
+      $("detail").hidden = true;
+      $("diff").hidden = false;
+      $("diff-title").textContent = "- " + rs[0].id + " (" + time(rs[0].startedAt) + ")    + " +
+        rs[1].id + " (" + time(rs[1].startedAt) + ")";
+      renderDiff($("diff-req"), messageText(rs[0], "request"), messageText(rs[1], "request"));
+      renderDiff($("diff-resp"), messageText(rs[0], "response"), messageText(rs[1], "response"));
+    }).catch(fail);
+  }
+
+  // ---- 实时更新 ----
+
+  function connect() {
+    var source = new EventSource("/api/events");
+    source.onopen = function () {
+      $("status").textContent = "实时";
+      $("status").className = "status online";
+      loadList();
+    };
+    source.onerror = function () {
+      $("status").textContent = "已断开，正在重连";
+      $("status").className = "status offline";
+    };
+    source.addEventListener("request", function (e) {
+      var r = JSON.parse(e.data);
+      scheduleReload();
+      if (selected && selected.id === r.id && selected.state !== r.state) show(r.id);
+    });
+  }
+
+  // ---- 事件绑定 ----
+
+  ["f-method", "f-status"].forEach(function (id) { $(id).onchange = loadList; });
+  ["f-path", "f-text"].forEach(function (id) { $(id).oninput = scheduleReload; });
+  $("filters").onsubmit = function (e) { e.preventDefault(); loadList(); };
+
+  $("clear").onclick = function () {
+    if (!window.confirm("清空所有记录的请求？")) return;
+    api("DELETE", "/api/requests").then(function () {
+      selected = null;
+      checked = [];
+      $("detail").hidden = true;
+      $("diff").hidden = true;
+      $("placeholder").hidden = false;
+      return loadList();
+    }).catch(fail);
+  };
+  $("compare").onclick = function () { compare(checked[0], checked[1]); };
+  $("replay").onclick = function () { replay({}); };
+  $("edit").onclick = openEditor;
+  $("editor").onsubmit = submitEditor;
+  $("e-cancel").onclick = function () { $("editor").hidden = true; };
+  $("diff-original").onclick = function () { compare(selected.replayOf, selected.id); };
+  $("diff-close").onclick = function () {
+    $("diff").hidden = true;
+    if (selected) $("detail").hidden = false; else $("placeholder").hidden = false;
+  };
+
+  loadList();
+  connect();
+})();
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"tunnel/internal/logging"
+)
+
+// 各组件的日志记录器，级别可在 log.components 中单独设置
+var (
+	tunnelLog = logging.Component("tunnel") // 与服务器的连接
+	originLog = logging.Component("origin") // 请求本地服务
+	tlsLog    = logging.Component("tls")    // 证书校验
+)
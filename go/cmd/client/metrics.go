@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tunnel/internal/metrics"
+)
+
+// 访问本地服务失败的原因
+const (
+	originErrorRequest = "request" // 无法创建请求
+	originErrorConnect = "connect" // 连接或请求失败 (包括超时)
+	originErrorRead    = "read"    // 读取响应体失败
+)
+
+// clientMetrics 客户端的 Prometheus 指标
+type clientMetrics struct {
+	registry      *metrics.Registry
+	connects      *metrics.Counter
+	reconnects    *metrics.Counter
+	inFlight      *metrics.Gauge
+	responses     *metrics.CounterVec
+	originErrors  *metrics.CounterVec
+	originLatency *metrics.HistogramVec
+}
+
+// newClientMetrics 注册客户端指标，连接状态在输出时读取
+func newClientMetrics(c *TunnelClient) *clientMetrics {
+	r := metrics.NewRegistry()
+	m := &clientMetrics{
+		registry: r,
+		connects: r.NewCounter("tunnel_client_connects_total",
+			"成功建立的隧道连接数"),
+		reconnects: r.NewCounter("tunnel_client_reconnects_total",
+			"断线后成功重连的次数"),
+		inFlight: r.NewGauge("tunnel_client_origin_requests_in_flight",
+			"正在请求本地服务的请求数"),
+		responses: r.NewCounterVec("tunnel_client_origin_responses_total",
+			"按状态码统计的本地服务响应数", "status"),
+		originErrors: r.NewCounterVec("tunnel_client_origin_errors_total",
+			"按原因统计的访问本地服务失败次数", "reason"),
+		originLatency: r.NewHistogramVec("tunnel_client_origin_request_duration_seconds",
+			"请求本地服务到读完响应的耗时", nil, "status"),
+	}
+	r.NewGaugeFunc("tunnel_client_connected", "是否已连接到隧道服务器 (1 或 0)", func() float64 {
+		if c.status().Connected {
+			return 1
+		}
+		return 0
+	})
+	r.NewGaugeFunc("tunnel_client_reconnect_attempt", "当前连续重连的尝试次数，已连接时为 0", func() float64 {
+		return float64(c.status().ReconnectAttempt)
+	})
+	return m
+}
+
+// observeOrigin 记录一次本地服务响应
+func (m *clientMetrics) observeOrigin(status int, elapsed time.Duration) {
+	code := strconv.Itoa(status)
+	m.responses.With(code).Inc()
+	m.originLatency.With(code).Observe(elapsed.Seconds())
+}
+
+// clientStatus 客户端当前状态，用于 /ready
+type clientStatus struct {
+	Status           string `json:"status"`
+	Connected        bool   `json:"connected"`
+	Server           string `json:"server"`
+	ClientID         string `json:"clientId,omitempty"`
+	PublicURL        string `json:"publicUrl,omitempty"`
+	ConnectedSince   string `json:"connectedSince,omitempty"`
+	ReconnectAttempt int    `json:"reconnectAttempt"`
+	Reconnects       int64  `json:"reconnects"`
+	LastError        string `json:"lastError,omitempty"`
+	InFlightRequests int64  `json:"inFlightRequests"`
+	Origin           string `json:"origin"`
+}
+
+// status 读取当前状态
+func (c *TunnelClient) status() clientStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	st := clientStatus{
+		Status:           "not ready",
+		Connected:        c.connected,
+		Server:           c.config.Tunnel.URL,
+		ClientID:         c.clientID,
+		PublicURL:        c.publicURL,
+		ReconnectAttempt: c.reconnectCount,
+		LastError:        c.lastError,
+		Origin:           fmt.Sprintf("%s:%d", c.config.Local.Host, c.config.Local.Port),
+	}
+	if c.connected {
+		st.Status = "ready"
+		st.ConnectedSince = c.connectedAt.Format(time.RFC3339)
+	}
+	st.Reconnects = int64(c.metrics.reconnects.Value())
+	st.InFlightRequests = int64(c.metrics.inFlight.Value())
+	return st
+}
+
+// handleReady 已连接时返回 200，否则返回 503，供编排系统探测
+func (c *TunnelClient) handleReady(w http.ResponseWriter, r *http.Request) {
+	st := c.status()
+	w.Header().Set("Content-Type", "application/json")
+	if !st.Connected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(st)
+}
+
+// metricsPath 指标路径，默认 /metrics
+func (c *TunnelClient) metricsPath() string {
+	if c.config.Metrics.Path != "" {
+		return c.config.Metrics.Path
+	}
+	return "/metrics"
+}
+
+// startMetricsServer 在本地地址上提供指标和就绪检查，监听失败时返回错误
+func (c *TunnelClient) startMetricsServer() error {
+	mux := http.NewServeMux()
+	mux.Handle(c.metricsPath(), c.metrics.registry)
+	mux.HandleFunc("/ready", c.handleReady)
+	if c.inspector != nil && c.config.Inspect.Listen == c.config.Metrics.Listen {
+		c.registerInspector(mux)
+	}
+
+	listener, err := net.Listen("tcp", c.config.Metrics.Listen)
+	if err != nil {
+		return fmt.Errorf("指标监听失败: %v", err)
+	}
+	tunnelLog.Info("指标和状态接口已启动", "metrics", fmt.Sprintf("http://%s%s", listener.Addr(), c.metricsPath()),
+		"ready", fmt.Sprintf("http://%s/ready", listener.Addr()))
+	if c.inspector != nil && c.config.Inspect.Listen == c.config.Metrics.Listen {
+		c.logInspectorStarted(listener.Addr().String())
+	}
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			tunnelLog.Error("指标服务器已停止", "error", err)
+		}
+	}()
+	return nil
+}
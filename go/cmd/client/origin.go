@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"tunnel/internal/proxyproto"
+)
+
+type proxyAddrsKey struct{}
+
+// proxyAddrs 访客地址和公网入口地址，来自服务器的请求消息
+type proxyAddrs struct {
+	remote string
+	local  string
+}
+
+// proxyProtocolVersion 解析配置的 PROXY protocol 版本，留空表示不发送
+func proxyProtocolVersion(value string) (int, error) {
+	switch value {
+	case "":
+		return 0, nil
+	case "v1", "1":
+		return 1, nil
+	case "v2", "2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("未知的 PROXY protocol 版本: %s (可选 v1/v2)", value)
+}
+
+// newOriginTransport 创建访问本地服务的 Transport；
+// 启用 PROXY protocol 时每个请求使用新连接，并在连接开头写入访客地址
+func newOriginTransport(version int) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if version == 0 {
+		return transport
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DisableKeepAlives = true
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		header := &proxyproto.Header{Version: version}
+		if addrs, ok := ctx.Value(proxyAddrsKey{}).(proxyAddrs); ok {
+			header.Source = parseTCPAddr(addrs.remote)
+			header.Destination = parseTCPAddr(addrs.local)
+		}
+		data, err := header.Format()
+		if err == nil {
+			_, err = conn.Write(data)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("写入 PROXY protocol 头部失败: %v", err)
+		}
+		return conn, nil
+	}
+	return transport
+}
+
+// originURL 本地服务的地址
+func (c *TunnelClient) originURL(path, query string) string {
+	url := fmt.Sprintf("http://%s:%d%s", c.config.Local.Host, c.config.Local.Port, path)
+	if query != "" {
+		url += "?" + query
+	}
+	return url
+}
+
+// withProxyAddrs 将访客地址附加到请求上下文
+func withProxyAddrs(req *http.Request, remote, local string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), proxyAddrsKey{}, proxyAddrs{remote: remote, local: local}))
+}
+
+// parseTCPAddr 解析 "ip:port"，无效时返回 nil (发送 UNKNOWN/LOCAL 头部)
+func parseTCPAddr(addr string) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil
+	}
+	return tcpAddr
+}
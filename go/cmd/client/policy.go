@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// declaredPolicy 握手时通过 X-Tunnel-Policy 头发送给服务器的访问策略
+type declaredPolicy struct {
+	BasicAuth          []string `json:"basicAuth,omitempty"` // "user:hash"，密码只在本地哈希后发送
+	AllowCIDRs         []string `json:"allowCidrs,omitempty"`
+	OAuthAllowedEmails []string `json:"oauthAllowedEmails,omitempty"`
+}
+
+// buildPolicyHeader 根据配置生成访问策略头，未声明任何策略时返回空字符串
+func (c *TunnelClient) buildPolicyHeader() (string, error) {
+	cfg := c.config.Tunnel.Policy
+	policy := declaredPolicy{OAuthAllowedEmails: cfg.OAuthAllowedEmails}
+
+	for _, entry := range cfg.BasicAuth {
+		user, password, ok := strings.Cut(entry, ":")
+		if !ok || user == "" || password == "" {
+			return "", fmt.Errorf("basicAuth 格式应为 user:password: %q", entry)
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return "", err
+		}
+		policy.BasicAuth = append(policy.BasicAuth, user+":"+hash)
+	}
+
+	for _, cidr := range cfg.AllowCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			if _, err := netip.ParseAddr(cidr); err != nil {
+				return "", fmt.Errorf("无效的 CIDR: %q", cidr)
+			}
+		}
+		policy.AllowCIDRs = append(policy.AllowCIDRs, cidr)
+	}
+
+	if len(policy.BasicAuth) == 0 && len(policy.AllowCIDRs) == 0 && len(policy.OAuthAllowedEmails) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// hashPassword 使用 bcrypt 哈希密码，已经是 bcrypt 哈希的值原样发送 (服务器只接受 bcrypt)
+func hashPassword(password string) (string, error) {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(password, prefix) {
+			return password, nil
+		}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("哈希密码失败: %v", err)
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// spkiPin 计算证书公钥 (SubjectPublicKeyInfo) 的 SHA-256 指纹，base64 编码
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// normalizePin 去掉可选的 "sha256/" 前缀
+func normalizePin(pin string) string {
+	return strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+}
+
+// loadCertPool 读取 PEM 格式的 CA 证书文件
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取CA证书文件失败: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA证书文件中没有有效的证书: %s", path)
+	}
+	return pool, nil
+}
+
+// buildTLSConfig 根据配置构造 WSS 连接的 TLS 配置
+func (c *TunnelClient) buildTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.config.Tunnel.InsecureSkipVerify,
+	}
+
+	// 设置服务器名称
+	if c.config.Tunnel.ServerName != "" {
+		tlsConfig.ServerName = c.config.Tunnel.ServerName
+	}
+
+	// 信任自定义CA (自签名部署)
+	if c.config.Tunnel.CACertFile != "" {
+		pool, err := loadCertPool(c.config.Tunnel.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	// 加载客户端证书
+	if c.config.Tunnel.CertFile != "" || c.config.Tunnel.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.config.Tunnel.CertFile, c.config.Tunnel.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// 证书固定：已验证的证书链中任一证书的公钥指纹匹配即可 (可以固定 CA)；
+	// 跳过校验时只检查服务器证书本身，链中的其余证书未经验证，中间人可以附上真实的公开证书
+	if len(c.config.Tunnel.PinnedSPKI) > 0 {
+		pins := make(map[string]bool, len(c.config.Tunnel.PinnedSPKI))
+		for _, pin := range c.config.Tunnel.PinnedSPKI {
+			pins[normalizePin(pin)] = true
+		}
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			// 握手已证明对端持有服务器证书的私钥，其余证书只有在验证过证书链后才可信
+			candidates := cs.PeerCertificates
+			if len(candidates) > 1 {
+				candidates = candidates[:1]
+			}
+			if len(cs.VerifiedChains) > 0 {
+				candidates = nil
+				for _, chain := range cs.VerifiedChains {
+					candidates = append(candidates, chain...)
+				}
+			}
+			for _, cert := range candidates {
+				if pins[spkiPin(cert)] {
+					return nil
+				}
+			}
+			if len(cs.PeerCertificates) > 0 {
+				return fmt.Errorf("服务器证书公钥指纹不匹配 (sha256/%s)", spkiPin(cs.PeerCertificates[0]))
+			}
+			return fmt.Errorf("服务器未提供证书")
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// warnInsecureTLS 跳过证书校验时输出醒目警告
+func (c *TunnelClient) warnInsecureTLS() {
+	if !c.config.Tunnel.InsecureSkipVerify || !strings.HasPrefix(c.config.Tunnel.URL, "wss://") {
+		return
+	}
+
+	risk := "连接可能被中间人劫持，认证令牌和所有流量都可能泄露"
+	if len(c.config.Tunnel.PinnedSPKI) > 0 {
+		risk = "仅依靠证书固定 (pinnedSpki) 识别服务器"
+	}
+	tlsLog.Warn("⚠️ insecureSkipVerify 已启用，不会校验服务器证书！", "risk", risk,
+		"hint", "自签名部署请改用 caCertFile 指定CA证书，或配置 pinnedSpki")
+}
+
+// readCertificateFile 读取 PEM 文件中的所有证书
+func readCertificateFile(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("文件中没有PEM证书: %s", path)
+	}
+	return certs, nil
+}
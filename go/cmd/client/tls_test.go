@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 测试用的证书和私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert 签发测试证书，parent 为 nil 时生成自签名 CA
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	issuer, signer := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func TestBuildTLSConfigPinnedSPKI(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil)
+	leaf := newTestCert(t, "tunnel.test", ca)
+	other := newTestCert(t, "other-ca", nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务器发送 [服务器证书, CA]
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.der, ca.der}, PrivateKey: leaf.key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}(conn)
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		caFile   string
+		insecure bool
+		pins     []string
+		wantOK   bool
+	}{
+		{"校验证书链时固定服务器证书", caFile, false, []string{"sha256/" + spkiPin(leaf.cert)}, true},
+		{"校验证书链时固定 CA", caFile, false, []string{spkiPin(ca.cert)}, true},
+		{"校验证书链时指纹不匹配", caFile, false, []string{spkiPin(other.cert)}, false},
+		{"跳过校验时固定服务器证书", "", true, []string{spkiPin(leaf.cert)}, true},
+		// 未经验证的链中的其余证书可以由中间人附上，不能用于匹配
+		{"跳过校验时固定 CA", "", true, []string{spkiPin(ca.cert)}, false},
+		{"跳过校验时指纹不匹配", "", true, []string{spkiPin(other.cert)}, false},
+		{"未信任的CA", "", false, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Tunnel.ServerName = "tunnel.test"
+			config.Tunnel.CACertFile = tt.caFile
+			config.Tunnel.InsecureSkipVerify = tt.insecure
+			config.Tunnel.PinnedSPKI = tt.pins
+			tlsConfig, err := NewTunnelClient(config).buildTLSConfig()
+			if err != nil {
+				t.Fatalf("构造TLS配置失败: %v", err)
+			}
+			conn, err := tls.Dial("tcp", ln.Addr().String(), tlsConfig)
+			if err == nil {
+				conn.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("握手结果 %v，期望成功 %v", err, tt.wantOK)
+			}
+		})
+	}
+}
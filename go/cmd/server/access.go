@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// 默认的拒绝访问提示
+const defaultDenyMessage = "禁止访问"
+
+// AccessPolicyConfig 按主机名配置的访客访问策略
+type AccessPolicyConfig struct {
+	Hostnames   []string `yaml:"hostnames" json:"hostnames"`     // 为空表示所有主机名，支持 * 通配符
+	Allow       []string `yaml:"allow" json:"allow"`             // 允许的 CIDR/IP，为空表示不限制
+	Deny        []string `yaml:"deny" json:"deny"`               // 拒绝的 CIDR/IP，优先于 allow
+	DenyMessage string   `yaml:"denyMessage" json:"denyMessage"` // 403 响应内容
+	DenyPage    string   `yaml:"denyPage" json:"denyPage"`       // 403 响应的 HTML 文件，优先于 denyMessage
+	// 边缘认证：访客通过认证后才会转发 (两者只能选一)
+	BasicAuth      *BasicAuthConfig `yaml:"basicAuth" json:"basicAuth"`
+	OIDC           *OIDCConfig      `yaml:"oidc" json:"oidc"`
+	IdentityHeader string           `yaml:"identityHeader" json:"identityHeader"` // 转发给源站的身份头，默认 X-Tunnel-Auth-User
+}
+
+// accessPolicy 解析后的访问策略
+type accessPolicy struct {
+	name      string // 客户端声明的策略使用客户端名称
+	hostnames []string
+	allow     []netip.Prefix
+	deny      []netip.Prefix
+	denyBody  string
+	denyHTML  bool
+
+	basic          *edgeBasicAuth
+	oidc           *edgeOIDC
+	identityHeader string
+}
+
+// accessDecision 访问策略的判定结果
+type accessDecision struct {
+	policies []*accessPolicy
+	auths    []edgeAuth    // 每条配置了认证的策略的认证结果，按执行顺序
+	identity *edgeIdentity // 第一条策略认证的身份，记录在日志中
+}
+
+// edgeAuth 一条策略的身份认证结果
+type edgeAuth struct {
+	policy   *accessPolicy
+	identity *edgeIdentity
+}
+
+// parseAccessPolicy 解析访问策略配置
+func parseAccessPolicy(cfg AccessPolicyConfig, defaultMessage string) (*accessPolicy, error) {
+	policy := &accessPolicy{denyBody: cfg.DenyMessage}
+	for _, host := range cfg.Hostnames {
+		policy.hostnames = append(policy.hostnames, normalizeHostname(host))
+	}
+
+	var err error
+	if policy.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %v", err)
+	}
+	if policy.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %v", err)
+	}
+
+	if cfg.DenyPage != "" {
+		data, err := os.ReadFile(cfg.DenyPage)
+		if err != nil {
+			return nil, fmt.Errorf("读取拒绝页面失败: %v", err)
+		}
+		policy.denyBody, policy.denyHTML = string(data), true
+	}
+	if policy.denyBody == "" {
+		policy.denyBody = defaultMessage
+	}
+
+	if cfg.BasicAuth != nil && cfg.OIDC != nil {
+		return nil, fmt.Errorf("basicAuth 和 oidc 不能同时配置")
+	}
+	if cfg.BasicAuth != nil {
+		if policy.basic, err = parseBasicAuth(cfg.BasicAuth); err != nil {
+			return nil, fmt.Errorf("basicAuth: %v", err)
+		}
+	}
+	if cfg.OIDC != nil {
+		if policy.oidc, err = parseOIDC(cfg.OIDC); err != nil {
+			return nil, err
+		}
+	}
+	policy.identityHeader = cfg.IdentityHeader
+	if policy.identityHeader == "" {
+		policy.identityHeader = defaultAuthHeader
+	}
+	return policy, nil
+}
+
+// matchesHost 判断策略是否作用于该主机名
+func (p *accessPolicy) matchesHost(hostname string) bool {
+	if len(p.hostnames) == 0 {
+		return true
+	}
+	for _, pattern := range p.hostnames {
+		if matchHostname(pattern, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// label 用于日志的策略名称
+func (p *accessPolicy) label() string {
+	if p.name != "" {
+		return p.name
+	}
+	if len(p.hostnames) == 0 {
+		return "*"
+	}
+	return strings.Join(p.hostnames, ",")
+}
+
+// checkIP 按 deny > allow 的顺序检查访客地址，返回是否允许及原因
+func (p *accessPolicy) checkIP(ip string) (bool, string) {
+	if containsAddr(p.deny, ip) {
+		return false, "命中 deny 列表"
+	}
+	if len(p.allow) > 0 && !containsAddr(p.allow, ip) {
+		return false, "不在 allow 列表中"
+	}
+	return true, "通过地址检查"
+}
+
+// loadAccessPolicies 解析配置中的访问策略
+func (s *TunnelServer) loadAccessPolicies() error {
+	defaultMessage := s.config.Access.DenyMessage
+	if defaultMessage == "" {
+		defaultMessage = defaultDenyMessage
+	}
+
+	s.accessPolicies = nil
+	for i, cfg := range s.config.Access.Policies {
+		policy, err := parseAccessPolicy(cfg, defaultMessage)
+		if err != nil {
+			return fmt.Errorf("访问策略 #%d 配置错误: %v", i+1, err)
+		}
+		s.accessPolicies = append(s.accessPolicies, policy)
+	}
+	if len(s.accessPolicies) > 0 {
+		authLog.Info("已加载访问策略", "count", len(s.accessPolicies))
+	}
+	return nil
+}
+
+// accessPolicyFor 返回主机名对应的第一条访问策略
+func (s *TunnelServer) accessPolicyFor(hostname string) *accessPolicy {
+	for _, policy := range s.accessPolicies {
+		if policy.matchesHost(hostname) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// checkAccess 在转发前依次执行服务器策略和客户端声明的策略，拒绝时写入响应并返回 false
+func (s *TunnelServer) checkAccess(w http.ResponseWriter, r *http.Request, client *Client) (accessDecision, bool) {
+	hostname := normalizeHostname(r.Host)
+	decision := accessDecision{policies: s.policiesFor(hostname, client)}
+	if len(decision.policies) == 0 {
+		return decision, true
+	}
+
+	ip := s.clientIP(r)
+	var reasons []string
+	for _, policy := range decision.policies {
+		allowed, reason := policy.checkIP(ip)
+		if !allowed {
+			authLog.Info("访问控制: 拒绝访问", "remote", ip, "route", hostname, "path", r.URL.Path, "policy", policy.label(), "reason", reason)
+			policy.writeForbidden(w)
+			return decision, false
+		}
+
+		// 每条策略都执行自己的认证：服务器和客户端都要求基本认证时凭据需要同时有效，
+		// OIDC 的会话对每条策略分别检查授权规则
+		var identity *edgeIdentity
+		var ok bool
+		switch {
+		case policy.basic != nil:
+			if identity, ok = policy.checkBasicAuth(w, r); !ok {
+				// 未携带凭据的请求只是质询，不计为认证失败
+				if _, _, sent := r.BasicAuth(); sent {
+					s.metrics.authFailures.With(authFailureEdgeBasic).Inc()
+				}
+				authLog.Info("访问控制: 拒绝访问", "remote", ip, "route", hostname, "path", r.URL.Path, "policy", policy.label(), "reason", "基本认证失败")
+				return decision, false
+			}
+			reason = "基本认证用户 " + identity.User
+		case policy.oidc != nil:
+			if identity, ok = s.checkOIDC(w, r, policy); !ok {
+				authLog.Debug("访问控制: 需要OIDC登录", "remote", ip, "route", hostname, "path", r.URL.Path, "policy", policy.label())
+				return decision, false
+			}
+			reason = "OIDC用户 " + identity.User
+		}
+		if identity != nil {
+			decision.auths = append(decision.auths, edgeAuth{policy: policy, identity: identity})
+			if decision.identity == nil {
+				decision.identity = identity
+			}
+		}
+		reasons = append(reasons, fmt.Sprintf("策略 %s: %s", policy.label(), reason))
+	}
+
+	// 允许和拒绝都以 info 级别记录，审计时可以看到谁通过了哪条策略
+	authLog.Info("访问控制: 允许访问", "remote", ip, "route", hostname, "path", r.URL.Path, "reason", strings.Join(reasons, "; "))
+	return decision, true
+}
+
+// writeForbidden 写入拒绝访问响应
+func (p *accessPolicy) writeForbidden(w http.ResponseWriter) {
+	if p.denyHTML {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(p.denyBody))
+		return
+	}
+	http.Error(w, p.denyBody, http.StatusForbidden)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"tunnel/internal/logging"
+)
+
+// 访问日志格式
+const (
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+	AccessLogTemplate = "template"
+)
+
+// AccessLogConfig 公网请求的访问日志
+type AccessLogConfig struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled"`
+	File       string `yaml:"file" json:"file"`             // 为空时输出到标准输出
+	Format     string `yaml:"format" json:"format"`         // combined / json / template，默认 combined
+	Template   string `yaml:"template" json:"template"`     // format 为 template 时使用 (Go text/template)
+	MaxSize    string `yaml:"maxSize" json:"maxSize"`       // 按大小轮转，如 "100MB"
+	Rotate     string `yaml:"rotate" json:"rotate"`         // 按时间轮转: daily / hourly
+	MaxBackups int    `yaml:"maxBackups" json:"maxBackups"` // 保留的旧文件数，0 表示全部保留
+}
+
+// accessLogEntry 一条访问日志，字段也是自定义模板可用的变量
+type accessLogEntry struct {
+	Time       time.Time
+	RemoteIP   string
+	Host       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Bytes      int64
+	Referer    string
+	UserAgent  string
+	User       string
+	Duration   time.Duration // 服务器收到请求到写完响应
+	TunnelTime time.Duration // 往返时间中除去源站处理的部分
+	OriginTime time.Duration // 客户端请求本地服务的耗时
+	ClientID   string
+	RequestID  string
+	Route      string
+}
+
+// accessLogger 格式化并写入访问日志
+type accessLogger struct {
+	format string
+	tmpl   *template.Template
+	out    io.Writer
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// loadAccessLog 按配置打开访问日志
+func (s *TunnelServer) loadAccessLog() error {
+	cfg := s.config.AccessLog
+	if !cfg.Enabled {
+		return nil
+	}
+	l := &accessLogger{format: strings.ToLower(cfg.Format), out: os.Stdout}
+	switch l.format {
+	case "":
+		l.format = AccessLogCombined
+	case AccessLogCombined, AccessLogJSON:
+	case AccessLogTemplate:
+		if cfg.Template == "" {
+			return fmt.Errorf("访问日志格式为 template 时需要设置 template")
+		}
+		tmpl, err := template.New("accessLog").Parse(cfg.Template)
+		if err != nil {
+			return fmt.Errorf("访问日志模板错误: %v", err)
+		}
+		l.tmpl = tmpl
+	default:
+		return fmt.Errorf("未知的访问日志格式: %s (可用 combined、json、template)", cfg.Format)
+	}
+
+	if cfg.File != "" {
+		var maxSize int64
+		if cfg.MaxSize != "" {
+			var err error
+			if maxSize, err = parseByteSize(cfg.MaxSize); err != nil {
+				return fmt.Errorf("访问日志 maxSize 错误: %v", err)
+			}
+		}
+		file, err := logging.OpenRotatingFile(cfg.File, maxSize, cfg.Rotate, cfg.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("打开访问日志失败: %v", err)
+		}
+		l.out = file
+	}
+	s.accessLog = l
+
+	target := cfg.File
+	if target == "" {
+		target = "stdout"
+	}
+	serverLog.Info("访问日志已启用", "file", target, "format", l.format, "max_size", cfg.MaxSize, "rotate", cfg.Rotate)
+	return nil
+}
+
+// write 写入一行访问日志，写入失败时只记录到服务器日志
+func (l *accessLogger) write(e *accessLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Reset()
+	switch l.format {
+	case AccessLogJSON:
+		json.NewEncoder(&l.buf).Encode(e.jsonFields())
+	case AccessLogTemplate:
+		if err := l.tmpl.Execute(&l.buf, e); err != nil {
+			serverLog.Warn("访问日志模板执行失败", "error", err)
+			return
+		}
+		if b := l.buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+			l.buf.WriteByte('\n')
+		}
+	default:
+		e.writeCombined(&l.buf)
+	}
+	if _, err := l.out.Write(l.buf.Bytes()); err != nil {
+		serverLog.Warn("写入访问日志失败", "error", err)
+	}
+}
+
+// writeCombined Apache combined 格式，末尾追加主机名、耗时和客户端
+func (e *accessLogEntry) writeCombined(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"",
+		e.RemoteIP, dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, escapeQuotes(e.URI), e.Proto, e.Status, bytesField(e.Bytes),
+		escapeQuotes(dash(e.Referer)), escapeQuotes(dash(e.UserAgent)))
+	fmt.Fprintf(buf, " host=%s request_time=%.3f tunnel_time=%.3f origin_time=%.3f client_id=%s request_id=%s\n",
+		dash(e.Host), e.Duration.Seconds(), e.TunnelTime.Seconds(), e.OriginTime.Seconds(), dash(e.ClientID), dash(e.RequestID))
+}
+
+// jsonFields JSON 格式的字段，耗时以秒为单位
+func (e *accessLogEntry) jsonFields() map[string]interface{} {
+	return map[string]interface{}{
+		"time":        e.Time.Format(time.RFC3339Nano),
+		"remote_ip":   e.RemoteIP,
+		"host":        e.Host,
+		"method":      e.Method,
+		"uri":         e.URI,
+		"proto":       e.Proto,
+		"status":      e.Status,
+		"bytes":       e.Bytes,
+		"referer":     e.Referer,
+		"user_agent":  e.UserAgent,
+		"user":        e.User,
+		"duration":    e.Duration.Seconds(),
+		"tunnel_time": e.TunnelTime.Seconds(),
+		"origin_time": e.OriginTime.Seconds(),
+		"client_id":   e.ClientID,
+		"request_id":  e.RequestID,
+		"route":       e.Route,
+	}
+}
+
+// newAccessLogEntry 从请求填充公共字段，URL 中的令牌等凭据会被隐去
+func (s *TunnelServer) newAccessLogEntry(r *http.Request, start time.Time) *accessLogEntry {
+	return &accessLogEntry{
+		Time:      start,
+		RemoteIP:  s.clientIP(r),
+		Host:      r.Host,
+		Method:    r.Method,
+		URI:       logging.Redact(r.URL.RequestURI()),
+		Proto:     r.Proto,
+		Referer:   logging.Redact(r.Referer()),
+		UserAgent: r.UserAgent(),
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func escapeQuotes(s string) string {
+	return strings.ReplaceAll(s, `"`, `\"`)
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ACME 验证方式
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+// ACME 缓存文件名
+const (
+	acmeAccountKeyName = "account.key"
+	acmeCertName       = "cert.pem"
+	acmeKeyName        = "cert.key"
+)
+
+// acmeManager 自动申请和续期证书
+type acmeManager struct {
+	config    *Config
+	domains   []string
+	challenge string
+	client    *acme.Client
+	dns       DNSProvider
+
+	mu         sync.RWMutex
+	cert       *tls.Certificate
+	registered bool
+	httpTokens map[string]string           // HTTP-01 token -> key authorization
+	alpnCerts  map[string]*tls.Certificate // TLS-ALPN-01 域名 -> 验证证书
+}
+
+// newACMEManager 根据配置创建 ACME 管理器
+func newACMEManager(config *Config) (*acmeManager, error) {
+	cfg := config.ACME
+
+	domains := make([]string, 0, len(cfg.Domains)+2)
+	for _, d := range cfg.Domains {
+		domains = append(domains, normalizeHostname(d))
+	}
+	if len(domains) == 0 && config.Server.PublicDomain != "" {
+		domains = append(domains, normalizeHostname(config.Server.PublicDomain))
+	}
+	if cfg.Wildcard {
+		if config.Server.PublicDomain == "" {
+			return nil, fmt.Errorf("申请通配符证书需要配置 publicDomain")
+		}
+		domains = append(domains, "*."+normalizeHostname(config.Server.PublicDomain))
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("没有需要申请证书的域名 (请配置 acme.domains 或 publicDomain)")
+	}
+	sort.Strings(domains)
+
+	challenge := cfg.Challenge
+	if challenge == "" {
+		challenge = ChallengeHTTP01
+	}
+	switch challenge {
+	case ChallengeHTTP01, ChallengeTLSALPN01, ChallengeDNS01:
+	default:
+		return nil, fmt.Errorf("未知的ACME验证方式: %s", challenge)
+	}
+
+	m := &acmeManager{
+		config:     config,
+		domains:    domains,
+		challenge:  challenge,
+		httpTokens: make(map[string]string),
+		alpnCerts:  make(map[string]*tls.Certificate),
+	}
+
+	// 通配符证书只能通过 DNS-01 验证
+	if cfg.Wildcard || challenge == ChallengeDNS01 {
+		if cfg.DNS.Provider == "" {
+			return nil, fmt.Errorf("DNS-01 验证需要配置 acme.dns.provider")
+		}
+		dns, err := newDNSProvider(cfg.DNS.Provider, cfg.DNS.Options)
+		if err != nil {
+			return nil, err
+		}
+		m.dns = dns
+	}
+
+	if err := os.MkdirAll(cfg.CacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("创建ACME缓存目录失败: %v", err)
+	}
+
+	accountKey, err := m.loadAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := http.DefaultClient
+	if cfg.CARootFile != "" {
+		// 信任自定义的 ACME 服务器证书 (如本地 Pebble)
+		data, err := os.ReadFile(cfg.CARootFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取ACME服务器CA失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ACME服务器CA文件中没有有效证书: %s", cfg.CARootFile)
+		}
+		httpClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+
+	m.client = &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: cfg.DirectoryURL,
+		HTTPClient:   httpClient,
+		UserAgent:    "go-tunnel",
+	}
+
+	if err := m.loadCachedCert(); err != nil {
+		tlsLog.Info("ACME缓存证书不可用", "error", err)
+	}
+	return m, nil
+}
+
+// loadAccountKey 读取或生成 ACME 账户私钥
+func (m *acmeManager) loadAccountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.config.ACME.CacheDir, acmeAccountKeyName)
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("无效的ACME账户私钥: %s", path)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析ACME账户私钥失败: %v", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("不支持的ACME账户私钥类型")
+		}
+		return signer, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成ACME账户私钥失败: %v", err)
+	}
+	if err := writePrivateKey(path, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadCachedCert 读取磁盘缓存的证书，域名不一致时忽略
+func (m *acmeManager) loadCachedCert() error {
+	certPEM, err := os.ReadFile(filepath.Join(m.config.ACME.CacheDir, acmeCertName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(m.config.ACME.CacheDir, acmeKeyName))
+	if err != nil {
+		return err
+	}
+
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	if !sameDomains(cert.Leaf.DNSNames, m.domains) {
+		return fmt.Errorf("缓存证书的域名 %v 与配置 %v 不一致", cert.Leaf.DNSNames, m.domains)
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	tlsLog.Info("已加载ACME缓存证书", "domains", m.domains, "not_after", cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// parseKeyPair 解析 PEM 证书链和私钥，并填充 Leaf
+func parseKeyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// sameDomains 比较两个域名集合
+func sameDomains(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// needsRenewal 检查证书是否需要申请或续期
+func (m *acmeManager) needsRenewal() bool {
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+	if cert == nil {
+		return true
+	}
+	renewBefore := time.Duration(m.config.ACME.RenewBefore) * 24 * time.Hour
+	return time.Until(cert.Leaf.NotAfter) < renewBefore
+}
+
+// run 申请证书并定期检查续期
+func (m *acmeManager) run() {
+	for {
+		wait := 12 * time.Hour
+		if m.needsRenewal() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			if err := m.obtain(ctx); err != nil {
+				tlsLog.Error("ACME申请证书失败", "error", err)
+				wait = time.Hour
+			}
+			cancel()
+		}
+		time.Sleep(wait)
+	}
+}
+
+// register 注册 ACME 账户 (已注册时忽略)
+func (m *acmeManager) register(ctx context.Context) error {
+	if m.registered {
+		return nil
+	}
+
+	account := &acme.Account{}
+	if m.config.ACME.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.ACME.Email}
+	}
+	if _, err := m.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("注册ACME账户失败: %v", err)
+	}
+	m.registered = true
+	return nil
+}
+
+// obtain 完成一次订单流程并保存证书
+func (m *acmeManager) obtain(ctx context.Context) error {
+	tlsLog.Info("ACME开始申请证书", "domains", m.domains)
+	if err := m.register(ctx); err != nil {
+		return err
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return fmt.Errorf("创建订单失败: %v", err)
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, authzURL); err != nil {
+			return err
+		}
+	}
+
+	orderURL := order.URI
+	if order, err = m.client.WaitOrder(ctx, orderURL); err != nil {
+		return fmt.Errorf("等待订单失败: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("生成证书私钥失败: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("创建CSR失败: %v", err)
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		// 异步签发的服务器 (如 Pebble) 可能不返回订单地址，改为轮询原订单
+		done, waitErr := m.client.WaitOrder(ctx, orderURL)
+		if waitErr != nil || done.Status != acme.StatusValid {
+			return fmt.Errorf("签发证书失败: %v", err)
+		}
+		if chain, err = m.client.FetchCert(ctx, done.CertURL, true); err != nil {
+			return fmt.Errorf("下载证书失败: %v", err)
+		}
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	// 先写私钥再写证书，缓存目录中始终保持可用的一对
+	if err := os.WriteFile(filepath.Join(m.config.ACME.CacheDir, acmeKeyName), keyPEM, 0600); err != nil {
+		return fmt.Errorf("缓存证书私钥失败: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.config.ACME.CacheDir, acmeCertName), certPEM, 0644); err != nil {
+		return fmt.Errorf("缓存证书失败: %v", err)
+	}
+
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+
+	tlsLog.Info("✓ ACME证书已签发", "domains", m.domains, "not_after", cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// authorize 完成单个域名的验证
+func (m *acmeManager) authorize(ctx context.Context, authzURL string) error {
+	authz, err := m.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return fmt.Errorf("获取授权失败: %v", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+	label := domain
+	challengeType := m.challenge
+	if authz.Wildcard {
+		label = "*." + domain
+		challengeType = ChallengeDNS01
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == challengeType {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("ACME服务器不支持 %s 验证: %s", challengeType, label)
+	}
+
+	cleanup, err := m.prepareChallenge(ctx, domain, challenge)
+	if err != nil {
+		return fmt.Errorf("准备 %s 验证失败 (%s): %v", challengeType, label, err)
+	}
+	defer cleanup()
+
+	if _, err := m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("提交验证失败 (%s): %v", label, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("域名验证失败 (%s): %v", label, err)
+	}
+
+	tlsLog.Info("ACME域名验证通过", "domain", label, "challenge", challengeType)
+	return nil
+}
+
+// prepareChallenge 部署验证响应，返回清理函数
+func (m *acmeManager) prepareChallenge(ctx context.Context, domain string, challenge *acme.Challenge) (func(), error) {
+	switch challenge.Type {
+	case ChallengeHTTP01:
+		response, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.httpTokens[challenge.Token] = response
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.httpTokens, challenge.Token)
+			m.mu.Unlock()
+		}, nil
+
+	case ChallengeTLSALPN01:
+		cert, err := m.client.TLSALPN01ChallengeCert(challenge.Token, domain)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.alpnCerts[domain] = &cert
+		m.mu.Unlock()
+		return func() {
+			m.mu.Lock()
+			delete(m.alpnCerts, domain)
+			m.mu.Unlock()
+		}, nil
+
+	case ChallengeDNS01:
+		value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		if err := m.dns.Present(ctx, fqdn, value); err != nil {
+			return nil, err
+		}
+		if delay := m.config.ACME.DNS.PropagationSeconds; delay > 0 {
+			tlsLog.Info("等待DNS记录生效", "seconds", delay, "record", fqdn)
+			select {
+			case <-time.After(time.Duration(delay) * time.Second):
+			case <-ctx.Done():
+			}
+		}
+		return func() {
+			if err := m.dns.CleanUp(context.Background(), fqdn, value); err != nil {
+				tlsLog.Warn("清理DNS记录失败", "error", err)
+			}
+		}, nil
+	}
+
+	return nil, fmt.Errorf("不支持的验证方式: %s", challenge.Type)
+}
+
+// GetCertificate 提供 TLS 握手使用的证书，包括 TLS-ALPN-01 验证证书
+func (m *acmeManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		if cert, ok := m.alpnCerts[normalizeHostname(hello.ServerName)]; ok {
+			return cert, nil
+		}
+		return nil, fmt.Errorf("没有 %s 的TLS-ALPN验证证书", hello.ServerName)
+	}
+
+	if m.cert == nil {
+		return nil, fmt.Errorf("ACME证书尚未就绪")
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler 在普通 HTTP 端口上响应 HTTP-01 验证
+func (m *acmeManager) HTTPHandler(next http.Handler) http.Handler {
+	const prefix = "/.well-known/acme-challenge/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		m.mu.RLock()
+		response, ok := m.httpTokens[strings.TrimPrefix(r.URL.Path, prefix)]
+		m.mu.RUnlock()
+		if !ok {
+			// 不是本服务器的验证请求，交给隧道处理
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(response))
+	})
+}
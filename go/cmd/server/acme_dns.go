@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// DNSProvider DNS-01 验证的 TXT 记录提供者
+type DNSProvider interface {
+	// Present 创建 TXT 记录，fqdn 形如 "_acme-challenge.example.com."
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp 删除 Present 创建的 TXT 记录
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNSProviderFactory 根据配置选项创建 DNS 提供者
+type DNSProviderFactory func(options map[string]string) (DNSProvider, error)
+
+var (
+	dnsProvidersMux sync.RWMutex
+	dnsProviders    = map[string]DNSProviderFactory{
+		"exec":         newExecDNSProvider,
+		"challtestsrv": newChallTestSrvDNSProvider,
+	}
+)
+
+// RegisterDNSProvider 注册 DNS 提供者，用于接入各家 DNS 服务商
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	dnsProvidersMux.Lock()
+	defer dnsProvidersMux.Unlock()
+	dnsProviders[name] = factory
+}
+
+// newDNSProvider 按名称创建 DNS 提供者
+func newDNSProvider(name string, options map[string]string) (DNSProvider, error) {
+	dnsProvidersMux.RLock()
+	factory, ok := dnsProviders[name]
+	dnsProvidersMux.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的DNS提供者: %s", name)
+	}
+	return factory(options)
+}
+
+// execDNSProvider 调用外部脚本管理 TXT 记录：
+// <command> present|cleanup <fqdn> <value>
+type execDNSProvider struct {
+	command string
+}
+
+func newExecDNSProvider(options map[string]string) (DNSProvider, error) {
+	command := options["command"]
+	if command == "" {
+		return nil, fmt.Errorf("exec DNS提供者需要 command 选项")
+	}
+	return &execDNSProvider{command: command}, nil
+}
+
+func (p *execDNSProvider) run(ctx context.Context, action, fqdn, value string) error {
+	out, err := exec.CommandContext(ctx, p.command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s 失败: %v: %s", p.command, action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (p *execDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// challTestSrvDNSProvider 使用 Pebble challtestsrv 的管理接口设置 TXT 记录 (本地测试用)
+type challTestSrvDNSProvider struct {
+	url string
+}
+
+func newChallTestSrvDNSProvider(options map[string]string) (DNSProvider, error) {
+	url := options["url"]
+	if url == "" {
+		url = "http://localhost:8055"
+	}
+	return &challTestSrvDNSProvider{url: strings.TrimSuffix(url, "/")}, nil
+}
+
+func (p *challTestSrvDNSProvider) post(ctx context.Context, path string, body map[string]string) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("challtestsrv %s 返回 %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (p *challTestSrvDNSProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, "/set-txt", map[string]string{"host": fqdn, "value": value})
+}
+
+func (p *challTestSrvDNSProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, "/clear-txt", map[string]string{"host": fqdn})
+}
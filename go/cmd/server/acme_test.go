@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 集成测试需要本地运行的 Pebble (https://github.com/letsencrypt/pebble)，未设置 PEBBLE_DIRECTORY 时跳过：
+//
+//	PEBBLE_VA_NOSLEEP=1 PEBBLE_WFE_NONCEREJECT=0 pebble -config test/config/pebble-config.json
+//	PEBBLE_DIRECTORY=https://localhost:14000/dir PEBBLE_CA_ROOT=<pebble>/test/certs/pebble.minica.pem \
+//	  go test -run Pebble ./cmd/server
+//
+// Pebble 到 PEBBLE_DOMAIN (默认 localhost) 的 httpPort/tlsPort (默认 5002/5001) 验证，测试在这两个端口上监听。
+type pebbleEnv struct {
+	directory string
+	caRoot    string
+	domain    string
+	httpPort  int
+	tlsPort   int
+}
+
+func pebbleFromEnv(t *testing.T) pebbleEnv {
+	t.Helper()
+	env := pebbleEnv{
+		directory: os.Getenv("PEBBLE_DIRECTORY"),
+		caRoot:    os.Getenv("PEBBLE_CA_ROOT"),
+		domain:    os.Getenv("PEBBLE_DOMAIN"),
+		httpPort:  5002,
+		tlsPort:   5001,
+	}
+	if env.directory == "" {
+		t.Skip("未设置 PEBBLE_DIRECTORY，跳过 ACME 集成测试")
+	}
+	if env.domain == "" {
+		env.domain = "localhost"
+	}
+	for name, port := range map[string]*int{"PEBBLE_HTTP_PORT": &env.httpPort, "PEBBLE_TLS_PORT": &env.tlsPort} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				t.Fatalf("%s 无效: %v", name, err)
+			}
+			*port = n
+		}
+	}
+	return env
+}
+
+// newPebbleServer 按服务器启动时的方式创建 ACME 管理器，并在验证端口上提供 HTTP-01 和 TLS-ALPN-01 响应
+func newPebbleServer(t *testing.T, env pebbleEnv, challenge string) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	config.ACME.Enabled = true
+	config.ACME.DirectoryURL = env.directory
+	config.ACME.CARootFile = env.caRoot
+	config.ACME.CacheDir = filepath.Join(t.TempDir(), "acme")
+	config.ACME.Domains = []string{env.domain}
+	config.ACME.Challenge = challenge
+	config.ACME.RenewBefore = 1 // Pebble 签发的证书有效期只有几天
+
+	s := NewTunnelServer(config)
+	manager, err := newACMEManager(config)
+	if err != nil {
+		t.Fatalf("创建ACME管理器失败: %v", err)
+	}
+	s.acme = manager
+
+	httpListener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(env.httpPort)))
+	if err != nil {
+		t.Fatalf("监听HTTP-01端口失败: %v", err)
+	}
+	httpServer := &http.Server{Handler: s.acme.HTTPHandler(http.NotFoundHandler())}
+	go httpServer.Serve(httpListener)
+	t.Cleanup(func() { httpServer.Close() })
+
+	tlsListener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(env.tlsPort)))
+	if err != nil {
+		t.Fatalf("监听TLS-ALPN-01端口失败: %v", err)
+	}
+	tlsServer := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: s.serverTLSConfig(nil, "http/1.1")}
+	go tlsServer.ServeTLS(tlsListener, "", "")
+	t.Cleanup(func() { tlsServer.Close() })
+
+	return s
+}
+
+func TestPebbleObtainCertificate(t *testing.T) {
+	env := pebbleFromEnv(t)
+
+	for _, challenge := range []string{ChallengeHTTP01, ChallengeTLSALPN01} {
+		t.Run(challenge, func(t *testing.T) {
+			s := newPebbleServer(t, env, challenge)
+			if !s.acme.needsRenewal() {
+				t.Fatal("没有证书时应需要申请")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			if err := s.acme.obtain(ctx); err != nil {
+				t.Fatalf("申请证书失败: %v", err)
+			}
+			if s.acme.needsRenewal() {
+				t.Errorf("刚签发的证书不应需要续期 (有效期至 %s)", s.acme.cert.Leaf.NotAfter)
+			}
+
+			// 普通握手使用签发的证书
+			cert, err := s.getCertificate(&tls.ClientHelloInfo{ServerName: env.domain})
+			if err != nil {
+				t.Fatalf("获取证书失败: %v", err)
+			}
+			if !sameDomains(cert.Leaf.DNSNames, []string{env.domain}) {
+				t.Errorf("证书域名 = %v, 期望 %v", cert.Leaf.DNSNames, []string{env.domain})
+			}
+			if cert.Leaf.Issuer.String() == cert.Leaf.Subject.String() {
+				t.Errorf("证书应由 Pebble 签发，得到自签名证书 %s", cert.Leaf.Subject)
+			}
+			if len(cert.Certificate) < 2 {
+				t.Errorf("证书链应包含中间证书，得到 %d 个证书", len(cert.Certificate))
+			}
+
+			// 验证证书只在验证期间提供
+			_, err = s.getCertificate(&tls.ClientHelloInfo{ServerName: env.domain, SupportedProtos: []string{"acme-tls/1"}})
+			if err == nil {
+				t.Error("验证结束后不应再提供 TLS-ALPN 验证证书")
+			}
+			if len(s.acme.httpTokens) != 0 || len(s.acme.alpnCerts) != 0 {
+				t.Errorf("验证结束后应清理验证响应: http=%d alpn=%d", len(s.acme.httpTokens), len(s.acme.alpnCerts))
+			}
+
+			// 重启后从缓存加载，不需要重新申请
+			reloaded, err := newACMEManager(s.config)
+			if err != nil {
+				t.Fatalf("重新创建ACME管理器失败: %v", err)
+			}
+			if reloaded.needsRenewal() {
+				t.Error("应从缓存加载证书")
+			}
+			if got, _ := reloaded.GetCertificate(&tls.ClientHelloInfo{ServerName: env.domain}); got == nil ||
+				!got.Leaf.Equal(cert.Leaf) {
+				t.Error("缓存的证书与签发的证书不一致")
+			}
+		})
+	}
+}
+
+func TestPebbleRejectsUnreachableDomain(t *testing.T) {
+	env := pebbleFromEnv(t)
+	// 使用验证端口之外的端口，Pebble 无法完成 HTTP-01 验证
+	env.httpPort = 0
+	s := newPebbleServer(t, env, ChallengeHTTP01)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	err := s.acme.obtain(ctx)
+	if err == nil {
+		t.Fatal("验证失败时应返回错误")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("应由 Pebble 拒绝验证，而不是超时: %v", err)
+	}
+	if !s.acme.needsRenewal() {
+		t.Error("申请失败后不应有证书")
+	}
+}
@@ -0,0 +1,772 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Listen    string        `yaml:"listen" json:"listen"`       // 如 127.0.0.1:6002，为空表示不启用
+	Tokens    []TokenConfig `yaml:"tokens" json:"tokens"`       // 管理令牌 (哈希或明文)，以 Authorization: Bearer 访问
+	StateFile string        `yaml:"stateFile" json:"stateFile"` // 保存通过接口创建的令牌和主机名保留，为空时重启后丢失
+	Capture   CaptureConfig `yaml:"capture" json:"capture"`     // GET /api/capture 抓取 HAR 的限制
+}
+
+// reservation 主机名保留：只有使用指定令牌的客户端可以注册该主机名
+type reservation struct {
+	Hostname  string    `json:"hostname"`
+	Token     string    `json:"token"` // 令牌名称，令牌轮换后保留仍然有效
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// adminStore 通过管理接口创建的令牌 (只保存哈希) 和主机名保留
+type adminStore struct {
+	path string
+
+	mu           sync.Mutex
+	tokens       []TokenConfig
+	reservations map[string]*reservation
+}
+
+func newAdminStore() *adminStore {
+	return &adminStore{reservations: make(map[string]*reservation)}
+}
+
+// load 读取状态文件，文件不存在时为空
+func (a *adminStore) load() error {
+	data, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state struct {
+		Tokens       []TokenConfig  `json:"tokens"`
+		Reservations []*reservation `json:"reservations"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	a.tokens = state.Tokens
+	for _, r := range state.Reservations {
+		a.reservations[r.Hostname] = r
+	}
+	return nil
+}
+
+// save 写入状态文件 (先写临时文件再替换)，调用方需持有 mu
+func (a *adminStore) save() error {
+	if a.path == "" {
+		return nil
+	}
+	reservations := make([]*reservation, 0, len(a.reservations))
+	for _, r := range a.reservations {
+		reservations = append(reservations, r)
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Hostname < reservations[j].Hostname })
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"tokens":       a.tokens,
+		"reservations": reservations,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(a.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	// 文件中有令牌哈希，只允许所有者读写
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
+
+// checkReservation 主机名被保留时，只允许使用对应令牌的客户端注册
+func (a *adminStore) checkReservation(hostname string, token *authToken) error {
+	if hostname == "" {
+		return nil
+	}
+	a.mu.Lock()
+	r := a.reservations[hostname]
+	a.mu.Unlock()
+	if r == nil || (token != nil && token.name == r.Token) {
+		return nil
+	}
+	return fmt.Errorf("主机名 %s 已被保留", hostname)
+}
+
+// loadAdmin 解析管理令牌，并加载之前通过接口创建的令牌和主机名保留
+func (s *TunnelServer) loadAdmin() error {
+	cfg := s.config.Admin
+	for i, tc := range cfg.Tokens {
+		token, err := parseAuthToken(tc)
+		if err != nil {
+			return fmt.Errorf("第 %d 个管理令牌无效: %v", i+1, err)
+		}
+		if token.hash == nil {
+			authLog.Warn("管理令牌为明文，请使用 'tunnel-server token hash' 转换为哈希", "name", token.label())
+		}
+		s.adminTokens = append(s.adminTokens, token)
+	}
+	if cfg.Listen != "" && len(s.adminTokens) == 0 {
+		return fmt.Errorf("启用管理接口需要配置 admin.tokens")
+	}
+
+	if cfg.StateFile == "" {
+		return nil
+	}
+	s.admin.path = cfg.StateFile
+	if err := s.admin.load(); err != nil {
+		return fmt.Errorf("读取管理状态文件失败: %v", err)
+	}
+	for _, tc := range s.admin.tokens {
+		token, err := parseAuthToken(tc)
+		if err != nil {
+			return fmt.Errorf("管理状态文件中的令牌 %s 无效: %v", tc.Name, err)
+		}
+		// 按名称吊销令牌，与配置文件中的令牌重名时会找错令牌
+		for _, t := range s.tokens {
+			if !t.managed && t.name == tc.Name {
+				return fmt.Errorf("管理状态文件中的令牌 %s 与配置文件中的令牌重名", tc.Name)
+			}
+		}
+		token.managed = true
+		s.tokens = append(s.tokens, token)
+	}
+	adminLog.Info("已加载管理状态", "file", cfg.StateFile, "tokens", len(s.admin.tokens), "reservations", len(s.admin.reservations))
+	return nil
+}
+
+// startAdminServer 启动管理接口，/health 不需要认证
+func (s *TunnelServer) startAdminServer() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	if s.config.Metrics.Enabled && s.metricsListen() == s.config.Admin.Listen {
+		mux.Handle(s.metricsPath(), s.metrics.registry)
+	}
+	mux.Handle("/api/", s.requireAdmin(http.HandlerFunc(s.handleAdminAPI)))
+	mux.Handle("/dashboard/", dashboardHandler())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/dashboard/", http.StatusFound)
+	})
+
+	listener, err := net.Listen("tcp", s.config.Admin.Listen)
+	if err != nil {
+		return fmt.Errorf("管理接口监听失败: %v", err)
+	}
+	adminLog.Info("管理接口启动", "url", fmt.Sprintf("http://%s/api/", listener.Addr()),
+		"dashboard", fmt.Sprintf("http://%s/dashboard/", listener.Addr()))
+	server := &http.Server{Handler: mux}
+	return serve("管理接口", server, []net.Listener{listener}, false)
+}
+
+// requireAdmin 校验管理令牌
+func (s *TunnelServer) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowAuthAttempt(w, r) {
+			return
+		}
+		value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			ok = findToken(s.adminTokens, value) != nil
+		}
+		if !ok {
+			s.recordAuthFailure(r)
+			s.metrics.authFailures.With(authFailureAdmin).Inc()
+			authLog.Info("拒绝管理接口请求: 令牌无效", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tunnel-admin"`)
+			adminError(w, http.StatusUnauthorized, "认证失败")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminAPI 按路径分发管理接口请求
+//
+//	GET    /api/clients                  客户端列表
+//	GET    /api/clients/{id}             客户端详情
+//	DELETE /api/clients/{id}             断开客户端 (?reconnect=true 允许客户端重连)
+//	POST   /api/clients/{id}/drain       排空客户端 (?disconnect=true 处理完后断开)
+//	DELETE /api/clients/{id}/drain       恢复分配请求
+//	GET    /api/requests                 等待响应的请求 (?client= 过滤)
+//	DELETE /api/requests/{id}            取消请求
+//	GET    /api/routes                   主机名路由
+//	GET    /api/tokens                   令牌列表
+//	POST   /api/tokens                   创建令牌
+//	DELETE /api/tokens/{name}            删除令牌并断开使用它的客户端
+//	GET    /api/reservations             主机名保留
+//	POST   /api/reservations             保留主机名
+//	DELETE /api/reservations/{hostname}  取消保留
+//	GET    /api/ratelimits               限流规则和令牌桶状态
+//	GET    /api/events                   控制台的实时数据 (SSE)
+//	GET    /api/capture                  以 HAR 格式抓取请求 (?duration= &limit= &client= &hostname=)
+func (s *TunnelServer) handleAdminAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+	route := r.Method + " " + parts[0]
+	if len(parts) > 1 {
+		route += "/*"
+	}
+	if len(parts) > 2 {
+		route += "/" + parts[2]
+	}
+	if len(parts) > 3 {
+		adminError(w, http.StatusNotFound, "未知的管理接口")
+		return
+	}
+
+	switch route {
+	case "GET clients":
+		s.adminListClients(w, r)
+	case "GET clients/*":
+		s.adminGetClient(w, parts[1])
+	case "DELETE clients/*":
+		s.adminDisconnectClient(w, r, parts[1])
+	case "POST clients/*/drain":
+		s.adminDrainClient(w, r, parts[1], true)
+	case "DELETE clients/*/drain":
+		s.adminDrainClient(w, r, parts[1], false)
+	case "GET requests":
+		s.adminListRequests(w, r)
+	case "DELETE requests/*":
+		s.adminCancelRequest(w, parts[1])
+	case "GET routes":
+		s.adminListRoutes(w)
+	case "GET tokens":
+		s.adminListTokens(w)
+	case "POST tokens":
+		s.adminCreateToken(w, r)
+	case "DELETE tokens/*":
+		s.adminDeleteToken(w, parts[1])
+	case "GET reservations":
+		s.adminListReservations(w)
+	case "POST reservations":
+		s.adminCreateReservation(w, r)
+	case "DELETE reservations/*":
+		s.adminDeleteReservation(w, parts[1])
+	case "GET ratelimits":
+		s.adminListRateLimits(w)
+	case "GET events":
+		s.handleDashboardEvents(w, r)
+	case "GET capture":
+		s.adminCapture(w, r)
+	default:
+		adminError(w, http.StatusNotFound, "未知的管理接口")
+	}
+}
+
+func adminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, status int, message string) {
+	adminJSON(w, status, map[string]string{"error": message})
+}
+
+// pendingCounts 每个客户端等待响应的请求数
+func (s *TunnelServer) pendingCounts() map[string]int {
+	s.requestMux.RLock()
+	defer s.requestMux.RUnlock()
+	counts := make(map[string]int)
+	for _, p := range s.pendingRequests {
+		counts[p.ClientID]++
+	}
+	return counts
+}
+
+// clientInfo 客户端详情，调用方需持有 clientsMux
+func (s *TunnelServer) clientInfo(client *Client, pending int) map[string]interface{} {
+	inRate, inTotal := client.inMeter.rate()
+	outRate, outTotal := client.outMeter.rate()
+	requestRate, requestTotal := client.requestMeter.rate()
+	errorRate, errorTotal := client.errorMeter.rate()
+	token := ""
+	if client.Token != nil {
+		token = client.Token.label()
+	}
+	return map[string]interface{}{
+		"id":              client.ID,
+		"host":            client.Host,
+		"port":            client.Port,
+		"hostname":        client.Route.Hostname,
+		"identity":        client.Identity,
+		"token":           token,
+		"policy":          client.PolicySummary,
+		"type":            client.Route.Type,
+		"remoteAddr":      client.RemoteAddr,
+		"connectedAt":     client.ConnectedAt,
+		"lastPing":        client.LastPing,
+		"connected":       true,
+		"draining":        client.Draining,
+		"pendingRequests": pending,
+		"writeQueue":      len(client.sendQueue),
+		"throughput": map[string]interface{}{
+			"inBytesPerSecond":  inRate,
+			"outBytesPerSecond": outRate,
+			"in":                formatBitRate(inRate),
+			"out":               formatBitRate(outRate),
+			"inTotal":           inTotal,
+			"outTotal":          outTotal,
+			"limits":            s.bandwidthLimitsFor(client),
+		},
+		"requests": map[string]interface{}{
+			"perSecond":       requestRate,
+			"total":           requestTotal,
+			"errorsPerSecond": errorRate,
+			"errors":          errorTotal,
+		},
+	}
+}
+
+func (s *TunnelServer) adminListClients(w http.ResponseWriter, r *http.Request) {
+	pending := s.pendingCounts()
+	s.clientsMux.RLock()
+	clients := make([]map[string]interface{}, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, s.clientInfo(client, pending[client.ID]))
+	}
+	s.clientsMux.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i]["id"].(string) < clients[j]["id"].(string) })
+	adminJSON(w, http.StatusOK, clients)
+}
+
+func (s *TunnelServer) adminGetClient(w http.ResponseWriter, id string) {
+	pending := s.pendingCounts()
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	client := s.clients[id]
+	if client == nil {
+		adminError(w, http.StatusNotFound, "客户端不存在: "+id)
+		return
+	}
+	adminJSON(w, http.StatusOK, s.clientInfo(client, pending[client.ID]))
+}
+
+// disconnectClient 通知客户端后关闭连接，reconnect 为 false 时客户端不再重连
+func (s *TunnelServer) disconnectClient(client *Client, reason string, reconnect bool) {
+	client.send(map[string]interface{}{
+		"type": "disconnect",
+		"data": map[string]interface{}{
+			"reason":    reason,
+			"reconnect": reconnect,
+		},
+	})
+	client.send(closeConn{})
+}
+
+func (s *TunnelServer) adminDisconnectClient(w http.ResponseWriter, r *http.Request, id string) {
+	s.clientsMux.RLock()
+	client := s.clients[id]
+	s.clientsMux.RUnlock()
+	if client == nil {
+		adminError(w, http.StatusNotFound, "客户端不存在: "+id)
+		return
+	}
+	reconnect := r.URL.Query().Get("reconnect") == "true"
+	s.disconnectClient(client, "被管理员断开", reconnect)
+	adminLog.Info("断开客户端", "client_id", id, "reconnect", reconnect, "remote", r.RemoteAddr)
+	adminJSON(w, http.StatusOK, map[string]interface{}{"disconnected": id, "reconnect": reconnect})
+}
+
+// adminDrainClient 排空客户端：不再分配新请求，等待中的请求继续完成
+func (s *TunnelServer) adminDrainClient(w http.ResponseWriter, r *http.Request, id string, drain bool) {
+	s.clientsMux.Lock()
+	client := s.clients[id]
+	if client != nil {
+		client.Draining = drain
+	}
+	s.clientsMux.Unlock()
+	if client == nil {
+		adminError(w, http.StatusNotFound, "客户端不存在: "+id)
+		return
+	}
+	pending := s.pendingCounts()[id]
+	if !drain {
+		adminLog.Info("恢复客户端", "client_id", id, "remote", r.RemoteAddr)
+		adminJSON(w, http.StatusOK, map[string]interface{}{"id": id, "draining": false})
+		return
+	}
+
+	disconnect := r.URL.Query().Get("disconnect") == "true"
+	adminLog.Info("排空客户端", "client_id", id, "pending", pending, "disconnect", disconnect, "remote", r.RemoteAddr)
+	if disconnect {
+		go s.disconnectWhenIdle(client)
+	}
+	adminJSON(w, http.StatusAccepted, map[string]interface{}{
+		"id":              id,
+		"draining":        true,
+		"pendingRequests": pending,
+		"disconnect":      disconnect,
+	})
+}
+
+// disconnectWhenIdle 等待客户端的请求完成 (最多一个请求超时时间) 后断开
+func (s *TunnelServer) disconnectWhenIdle(client *Client) {
+	deadline := time.Now().Add(time.Duration(s.config.Server.RequestTimeout) * time.Millisecond)
+	for time.Now().Before(deadline) && s.pendingCounts()[client.ID] > 0 {
+		select {
+		case <-client.done:
+			return
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	s.clientsMux.RLock()
+	draining := client.Draining
+	s.clientsMux.RUnlock()
+	if draining {
+		adminLog.Info("客户端已排空，断开连接", "client_id", client.ID)
+		s.disconnectClient(client, "已被管理员排空", false)
+	}
+}
+
+func (s *TunnelServer) adminListRequests(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client")
+	now := time.Now()
+	s.requestMux.RLock()
+	requests := make([]map[string]interface{}, 0, len(s.pendingRequests))
+	for _, p := range s.pendingRequests {
+		if clientID != "" && p.ClientID != clientID {
+			continue
+		}
+		requests = append(requests, map[string]interface{}{
+			"id":        p.ID,
+			"clientId":  p.ClientID,
+			"method":    p.Method,
+			"path":      p.Path,
+			"hostname":  p.Hostname,
+			"remote":    p.Remote,
+			"startedAt": p.StartedAt,
+			"age":       now.Sub(p.StartedAt).Seconds(),
+		})
+	}
+	s.requestMux.RUnlock()
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i]["startedAt"].(time.Time).Before(requests[j]["startedAt"].(time.Time))
+	})
+	adminJSON(w, http.StatusOK, requests)
+}
+
+// adminCancelRequest 取消等待中的请求，访客收到 503，客户端稍后返回的响应被丢弃
+func (s *TunnelServer) adminCancelRequest(w http.ResponseWriter, id string) {
+	s.requestMux.Lock()
+	pending := s.pendingRequests[id]
+	delete(s.pendingRequests, id)
+	s.requestMux.Unlock()
+	if pending == nil {
+		adminError(w, http.StatusNotFound, "请求不存在或已完成: "+id)
+		return
+	}
+	select {
+	case pending.response <- HTTPResponse{Canceled: true}:
+	default:
+	}
+	adminLog.Info("取消请求", "request_id", id, "client_id", pending.ClientID, "path", pending.Path)
+	adminJSON(w, http.StatusOK, map[string]interface{}{"canceled": id})
+}
+
+// adminListRoutes 按主机名列出客户端、保留和访问策略，"*" 表示未指定主机名的客户端
+func (s *TunnelServer) adminListRoutes(w http.ResponseWriter) {
+	type routeInfo struct {
+		Hostname     string       `json:"hostname"`
+		Clients      []string     `json:"clients"`
+		Available    int          `json:"available"` // 可分配请求的客户端数 (未排空、令牌未过期)
+		Reservation  *reservation `json:"reservation,omitempty"`
+		AccessPolicy string       `json:"accessPolicy,omitempty"`
+	}
+	routes := make(map[string]*routeInfo)
+	get := func(hostname string) *routeInfo {
+		if routes[hostname] == nil {
+			routes[hostname] = &routeInfo{Hostname: hostname, Clients: []string{}}
+		}
+		return routes[hostname]
+	}
+
+	now := time.Now()
+	s.clientsMux.RLock()
+	for _, client := range s.clients {
+		route := get(usageRouteKey(client))
+		route.Clients = append(route.Clients, client.ID)
+		if !client.Draining && (client.Token == nil || !client.Token.scope.expired(now)) {
+			route.Available++
+		}
+	}
+	s.clientsMux.RUnlock()
+
+	s.admin.mu.Lock()
+	for hostname, r := range s.admin.reservations {
+		get(hostname).Reservation = r
+	}
+	s.admin.mu.Unlock()
+
+	result := make([]*routeInfo, 0, len(routes))
+	for hostname, route := range routes {
+		if policy := s.accessPolicyFor(hostname); policy != nil && hostname != "*" {
+			route.AccessPolicy = policy.name
+		}
+		sort.Strings(route.Clients)
+		result = append(result, route)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Hostname < result[j].Hostname })
+	adminJSON(w, http.StatusOK, result)
+}
+
+func (s *TunnelServer) adminListTokens(w http.ResponseWriter) {
+	adminJSON(w, http.StatusOK, s.tokenInfos())
+}
+
+// tokenInfos 令牌列表 (不含令牌值)
+func (s *TunnelServer) tokenInfos() []map[string]interface{} {
+	now := time.Now()
+	s.clientsMux.RLock()
+	conns := make(map[*authToken]int, len(s.tokenConns))
+	for token, n := range s.tokenConns {
+		conns[token] = n
+	}
+	s.clientsMux.RUnlock()
+
+	s.tokensMux.RLock()
+	tokens := make([]map[string]interface{}, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		source := "config"
+		if token.managed {
+			source = "api"
+		}
+		info := map[string]interface{}{
+			"name":        token.name,
+			"source":      source,
+			"hashed":      token.hash != nil,
+			"scope":       token.scope.describe(),
+			"expired":     token.scope.expired(now),
+			"connections": conns[token],
+		}
+		if !token.scope.expiresAt.IsZero() {
+			info["expiresAt"] = token.scope.expiresAt
+		}
+		tokens = append(tokens, info)
+	}
+	s.tokensMux.RUnlock()
+	return tokens
+}
+
+// adminCreateToken 生成令牌并保存其哈希，明文令牌只在响应中返回一次
+func (s *TunnelServer) adminCreateToken(w http.ResponseWriter, r *http.Request) {
+	var cfg TokenConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		adminError(w, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+	cfg.Name = strings.TrimSpace(cfg.Name)
+	if cfg.Name == "" {
+		adminError(w, http.StatusBadRequest, "需要令牌名称 (name)")
+		return
+	}
+	plain, err := GenerateToken()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cfg.Token, err = HashToken(plain); err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	token, err := parseAuthToken(cfg)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	token.managed = true
+
+	s.tokensMux.Lock()
+	for _, t := range s.tokens {
+		if t.name == cfg.Name {
+			s.tokensMux.Unlock()
+			adminError(w, http.StatusConflict, "令牌名称已存在: "+cfg.Name)
+			return
+		}
+	}
+	s.admin.mu.Lock()
+	s.admin.tokens = append(s.admin.tokens, cfg)
+	err = s.admin.save()
+	if err != nil {
+		s.admin.tokens = s.admin.tokens[:len(s.admin.tokens)-1]
+	} else {
+		s.tokens = append(s.tokens, token)
+	}
+	s.admin.mu.Unlock()
+	s.tokensMux.Unlock()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "保存管理状态失败: "+err.Error())
+		return
+	}
+
+	adminLog.Info("创建令牌", "name", cfg.Name, "scope", token.scope.describe(), "remote", r.RemoteAddr)
+	adminJSON(w, http.StatusCreated, map[string]interface{}{
+		"name":  cfg.Name,
+		"token": plain,
+		"scope": token.scope.describe(),
+	})
+}
+
+// adminDeleteToken 删除通过接口创建的令牌，并断开使用它的客户端 (配置文件中的令牌不能删除)
+func (s *TunnelServer) adminDeleteToken(w http.ResponseWriter, name string) {
+	s.tokensMux.Lock()
+	index := -1
+	for i, t := range s.tokens {
+		if t.name == name {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		s.tokensMux.Unlock()
+		adminError(w, http.StatusNotFound, "令牌不存在: "+name)
+		return
+	}
+	token := s.tokens[index]
+	if !token.managed {
+		s.tokensMux.Unlock()
+		adminError(w, http.StatusConflict, "配置文件中的令牌不能通过管理接口删除: "+name)
+		return
+	}
+	s.admin.mu.Lock()
+	previous := s.admin.tokens
+	remaining := make([]TokenConfig, 0, len(previous))
+	for _, tc := range previous {
+		if tc.Name != name {
+			remaining = append(remaining, tc)
+		}
+	}
+	s.admin.tokens = remaining
+	err := s.admin.save()
+	if err != nil {
+		s.admin.tokens = previous
+	} else {
+		s.tokens = append(s.tokens[:index:index], s.tokens[index+1:]...)
+	}
+	s.admin.mu.Unlock()
+	s.tokensMux.Unlock()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "保存管理状态失败: "+err.Error())
+		return
+	}
+
+	// 断开使用该令牌的客户端
+	s.clientsMux.RLock()
+	var clients []*Client
+	for _, client := range s.clients {
+		if client.Token == token {
+			clients = append(clients, client)
+		}
+	}
+	s.clientsMux.RUnlock()
+	for _, client := range clients {
+		s.disconnectClient(client, "令牌已被删除", false)
+	}
+
+	adminLog.Info("删除令牌", "name", name, "disconnected", len(clients))
+	adminJSON(w, http.StatusOK, map[string]interface{}{"deleted": name, "disconnected": len(clients)})
+}
+
+func (s *TunnelServer) adminListReservations(w http.ResponseWriter) {
+	s.admin.mu.Lock()
+	result := make([]*reservation, 0, len(s.admin.reservations))
+	for _, r := range s.admin.reservations {
+		result = append(result, r)
+	}
+	s.admin.mu.Unlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Hostname < result[j].Hostname })
+	adminJSON(w, http.StatusOK, result)
+}
+
+// adminCreateReservation 为令牌保留主机名，已连接的客户端不受影响
+func (s *TunnelServer) adminCreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req reservation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		adminError(w, http.StatusBadRequest, "请求格式错误: "+err.Error())
+		return
+	}
+	req.Hostname = normalizeHostname(req.Hostname)
+	if req.Hostname == "" || strings.ContainsAny(req.Hostname, "*?[") {
+		adminError(w, http.StatusBadRequest, "需要完整的主机名 (hostname)，不支持通配符")
+		return
+	}
+	s.tokensMux.RLock()
+	found := false
+	for _, t := range s.tokens {
+		if t.name != "" && t.name == req.Token {
+			found = true
+			break
+		}
+	}
+	s.tokensMux.RUnlock()
+	if !found {
+		adminError(w, http.StatusBadRequest, "令牌不存在: "+req.Token)
+		return
+	}
+	req.CreatedAt = time.Now()
+
+	s.admin.mu.Lock()
+	if existing := s.admin.reservations[req.Hostname]; existing != nil {
+		s.admin.mu.Unlock()
+		adminError(w, http.StatusConflict, fmt.Sprintf("主机名 %s 已保留给令牌 %s", req.Hostname, existing.Token))
+		return
+	}
+	s.admin.reservations[req.Hostname] = &req
+	err := s.admin.save()
+	if err != nil {
+		delete(s.admin.reservations, req.Hostname)
+	}
+	s.admin.mu.Unlock()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "保存管理状态失败: "+err.Error())
+		return
+	}
+
+	adminLog.Info("保留主机名", "hostname", req.Hostname, "token", req.Token, "remote", r.RemoteAddr)
+	adminJSON(w, http.StatusCreated, &req)
+}
+
+func (s *TunnelServer) adminDeleteReservation(w http.ResponseWriter, hostname string) {
+	hostname = normalizeHostname(hostname)
+	s.admin.mu.Lock()
+	existing := s.admin.reservations[hostname]
+	var err error
+	if existing != nil {
+		delete(s.admin.reservations, hostname)
+		if err = s.admin.save(); err != nil {
+			s.admin.reservations[hostname] = existing
+		}
+	}
+	s.admin.mu.Unlock()
+	if existing == nil {
+		adminError(w, http.StatusNotFound, "主机名未被保留: "+hostname)
+		return
+	}
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, "保存管理状态失败: "+err.Error())
+		return
+	}
+	adminLog.Info("取消主机名保留", "hostname", hostname)
+	adminJSON(w, http.StatusOK, map[string]interface{}{"deleted": hostname})
+}
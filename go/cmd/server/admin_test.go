@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newAdminTestServer 创建带配置文件令牌和管理状态文件的服务器
+func newAdminTestServer(t *testing.T, stateFile string) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	config.Auth.Tokens = []TokenConfig{{Name: "ci", Token: "ci-token"}}
+	config.Admin.StateFile = stateFile
+	s := NewTunnelServer(config)
+	if err := s.loadTokens(); err != nil {
+		t.Fatalf("加载令牌失败: %v", err)
+	}
+	if err := s.loadAdmin(); err != nil {
+		t.Fatalf("加载管理状态失败: %v", err)
+	}
+	return s
+}
+
+func TestAdminDeleteToken(t *testing.T) {
+	s := newAdminTestServer(t, filepath.Join(t.TempDir(), "admin.json"))
+	rec := httptest.NewRecorder()
+	s.adminCreateToken(rec, httptest.NewRequest(http.MethodPost, "/api/tokens", strings.NewReader(`{"name":"dev"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("创建令牌失败: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.adminDeleteToken(rec, "ci")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("配置文件中的令牌不能删除，得到 %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	s.adminDeleteToken(rec, "dev")
+	if rec.Code != http.StatusOK {
+		t.Errorf("删除通过接口创建的令牌失败: %d %s", rec.Code, rec.Body)
+	}
+
+	// 控制台只对通过接口创建的令牌提供吊销
+	for _, info := range s.tokenInfos() {
+		if info["name"] == "ci" && info["source"] != "config" {
+			t.Errorf("配置文件中的令牌来源应为 config: %v", info)
+		}
+	}
+}
+
+func TestLoadAdminRejectsDuplicateTokenName(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "admin.json")
+	s := newAdminTestServer(t, stateFile)
+	s.admin.tokens = []TokenConfig{{Name: "ci", Token: "other-token"}}
+	if err := s.admin.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Auth.Tokens = []TokenConfig{{Name: "ci", Token: "ci-token"}}
+	config.Admin.StateFile = stateFile
+	s = NewTunnelServer(config)
+	if err := s.loadTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.loadAdmin(); err == nil {
+		t.Error("管理状态文件中的令牌与配置文件中的令牌重名时应报错")
+	}
+}
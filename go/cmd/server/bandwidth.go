@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimitConfig 按令牌或客户端注册的主机名限制带宽，上下行分别计算
+type BandwidthLimitConfig struct {
+	Name      string   `yaml:"name" json:"name"`
+	Per       string   `yaml:"per" json:"per"`             // token 或 route，默认 token
+	Tokens    []string `yaml:"tokens" json:"tokens"`       // 令牌名称或证书身份，为空表示所有客户端
+	Hostnames []string `yaml:"hostnames" json:"hostnames"` // 客户端注册的主机名，为空表示所有，支持 * 通配符
+	Rate      string   `yaml:"rate" json:"rate"`           // 如 "5Mbit"、"512KB/s"
+}
+
+// bandwidthLimit 解析后的带宽限制规则
+type bandwidthLimit struct {
+	name      string
+	per       string
+	tokens    []string
+	hostnames []string
+	rate      float64
+
+	mu       sync.Mutex
+	limiters map[string]*[2]*byteLimiter // 令牌或路由 -> [上行, 下行]
+}
+
+// newBandwidthLimit 解析带宽限制配置
+func newBandwidthLimit(cfg BandwidthLimitConfig) (*bandwidthLimit, error) {
+	b := &bandwidthLimit{
+		name:     cfg.Name,
+		per:      strings.ToLower(strings.TrimSpace(cfg.Per)),
+		tokens:   cfg.Tokens,
+		limiters: make(map[string]*[2]*byteLimiter),
+	}
+	if b.per == "" {
+		b.per = UsageByToken
+	}
+	if b.per != UsageByToken && b.per != UsageByRoute {
+		return nil, fmt.Errorf("未知的限速维度: %s (可用 token、route)", cfg.Per)
+	}
+	for _, host := range cfg.Hostnames {
+		b.hostnames = append(b.hostnames, normalizeHostname(host))
+	}
+	var err error
+	if b.rate, err = parseByteRate(cfg.Rate); err != nil {
+		return nil, err
+	}
+	if b.name == "" {
+		b.name = fmt.Sprintf("%s %s", b.per, cfg.Rate)
+	}
+	return b, nil
+}
+
+// matches 判断规则是否作用于该路由和客户端
+func (b *bandwidthLimit) matches(route, identity string) bool {
+	if len(b.tokens) > 0 && !containsString(b.tokens, identity) {
+		return false
+	}
+	if len(b.hostnames) == 0 {
+		return true
+	}
+	for _, pattern := range b.hostnames {
+		if matchHostname(pattern, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// limiter 对象共享的上行和下行限速器
+func (b *bandwidthLimit) limiter(key string) *[2]*byteLimiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.limiters[key]
+	if !ok {
+		l = &[2]*byteLimiter{newByteLimiter(b.rate), newByteLimiter(b.rate)}
+		b.limiters[key] = l
+	}
+	return l
+}
+
+// gc 回收上下行都空闲的限速器
+func (b *bandwidthLimit) gc(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, l := range b.limiters {
+		if l[0].idle(now) && l[1].idle(now) {
+			delete(b.limiters, key)
+		}
+	}
+}
+
+// loadBandwidthLimits 解析带宽限制规则
+func (s *TunnelServer) loadBandwidthLimits() error {
+	s.bandwidthLimits = nil
+	for i, cfg := range s.config.BandwidthLimits {
+		limit, err := newBandwidthLimit(cfg)
+		if err != nil {
+			return fmt.Errorf("带宽限制 #%d 配置错误: %v", i+1, err)
+		}
+		s.bandwidthLimits = append(s.bandwidthLimits, limit)
+	}
+	if len(s.bandwidthLimits) > 0 {
+		usageLog.Info("已加载带宽限制", "count", len(s.bandwidthLimits))
+		go s.bandwidthLimitGC()
+	}
+	return nil
+}
+
+// bandwidthLimitGC 定期回收空闲的限速器
+func (s *TunnelServer) bandwidthLimitGC() {
+	ticker := time.NewTicker(rateLimitGCInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, b := range s.bandwidthLimits {
+			b.gc(now)
+		}
+	}
+}
+
+// shapers 返回请求上行 (访客到源站) 和下行方向的限速器，quota 为超出配额时的限速器。
+// 与用量统计一样按客户端的路由计算，访客修改 Host 头既不能绕过限制，也不会产生新的限速器
+func (s *TunnelServer) shapers(client *Client, quota []*byteLimiter) (trafficShaper, trafficShaper) {
+	up := trafficShaper{limiters: quota, meter: client.inMeter}
+	down := trafficShaper{limiters: quota, meter: client.outMeter}
+	route := usageRouteKey(client)
+	for _, b := range s.bandwidthLimits {
+		if !b.matches(route, client.Identity) {
+			continue
+		}
+		key := route
+		if b.per == UsageByToken {
+			key = usageTokenKey(client)
+		}
+		l := b.limiter(key)
+		up.limiters = append(up.limiters, l[0])
+		down.limiters = append(down.limiters, l[1])
+	}
+	return up, down
+}
+
+// bandwidthLimitsFor 客户端注册的路由命中的带宽限制，用于客户端列表
+func (s *TunnelServer) bandwidthLimitsFor(client *Client) []string {
+	var names []string
+	for _, b := range s.bandwidthLimits {
+		if b.matches(usageRouteKey(client), client.Identity) {
+			names = append(names, fmt.Sprintf("%s (%s)", b.name, formatBitRate(b.rate)))
+		}
+	}
+	return names
+}
+
+// formatBitRate 以比特每秒格式化带宽
+func formatBitRate(bytesPerSecond float64) string {
+	bits := bytesPerSecond * 8
+	switch {
+	case bits >= 1e9:
+		return fmt.Sprintf("%.1f Gbit/s", bits/1e9)
+	case bits >= 1e6:
+		return fmt.Sprintf("%.1f Mbit/s", bits/1e6)
+	case bits >= 1e3:
+		return fmt.Sprintf("%.1f kbit/s", bits/1e3)
+	}
+	return fmt.Sprintf("%.0f bit/s", bits)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newBandwidthTestServer(t *testing.T, limits ...BandwidthLimitConfig) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	config.BandwidthLimits = limits
+	s := NewTunnelServer(config)
+	for _, cfg := range limits {
+		b, err := newBandwidthLimit(cfg)
+		if err != nil {
+			t.Fatalf("解析带宽限制失败: %v", err)
+		}
+		s.bandwidthLimits = append(s.bandwidthLimits, b)
+	}
+	return s
+}
+
+func TestShapersKeyedByRoute(t *testing.T) {
+	s := newBandwidthTestServer(t,
+		BandwidthLimitConfig{Per: UsageByRoute, Hostnames: []string{"*"}, Rate: "1Mbit"},
+		BandwidthLimitConfig{Per: UsageByRoute, Hostnames: []string{"*.example.com"}, Rate: "5Mbit"},
+	)
+	fallback := &Client{}
+	routed := &Client{Route: TunnelRoute{Hostname: "app.example.com"}}
+
+	// 未注册主机名的客户端的所有请求共享 * 的限速器
+	up, down := s.shapers(fallback, nil)
+	again, _ := s.shapers(fallback, nil)
+	if len(up.limiters) != 1 || len(down.limiters) != 1 || up.limiters[0] == down.limiters[0] {
+		t.Fatalf("应有一个上行和一个下行限速器，得到 %d/%d", len(up.limiters), len(down.limiters))
+	}
+	if again.limiters[0] != up.limiters[0] {
+		t.Error("同一路由的请求应共享限速器")
+	}
+	if n := len(s.bandwidthLimits[0].limiters); n != 1 {
+		t.Errorf("限速器应按路由保存，得到 %d 个", n)
+	}
+
+	// hostnames 按注册的主机名匹配
+	if up, _ := s.shapers(routed, nil); len(up.limiters) != 1 || up.limiters[0].rate != 5e6/8 {
+		t.Errorf("app.example.com 应只匹配 *.example.com 的限制，得到 %d 个限速器", len(up.limiters))
+	}
+	if names := s.bandwidthLimitsFor(fallback); len(names) != 1 {
+		t.Errorf("客户端列表中 * 路由应显示一条限制，得到 %v", names)
+	}
+}
+
+func TestBandwidthLimitGC(t *testing.T) {
+	s := newBandwidthTestServer(t, BandwidthLimitConfig{Per: UsageByToken, Rate: "1KB/s"})
+	b := s.bandwidthLimits[0]
+	up, _ := s.shapers(&Client{Identity: "busy"}, nil)
+	s.shapers(&Client{Identity: "idle"}, nil)
+
+	// 刚用完额度的限速器不能回收，否则新的限速器会重新给出一秒的额度
+	up.limiters[0].tokens = 0
+	now := time.Now()
+	b.gc(now)
+	if _, ok := b.limiters["busy"]; !ok {
+		t.Error("额度未恢复的限速器不应回收")
+	}
+	if _, ok := b.limiters["idle"]; ok {
+		t.Error("空闲的限速器应回收")
+	}
+
+	b.gc(now.Add(2 * time.Second))
+	if len(b.limiters) != 0 {
+		t.Errorf("额度恢复后应回收，剩余 %d 个", len(b.limiters))
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 本地CA目录中的文件名
+const (
+	caCertName  = "ca.crt"
+	caKeyName   = "ca.key"
+	caCRLName   = "ca.crl"
+	caIssuedDir = "issued"
+)
+
+// localCA 本地证书颁发机构
+type localCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// issuedCert 已签发的客户端证书
+type issuedCert struct {
+	Name    string
+	Cert    *x509.Certificate
+	Revoked bool
+}
+
+// parseCertificatePEM 解析 PEM 格式的证书
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("无效的PEM证书")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parseCRL 解析 PEM 或 DER 格式的 CRL
+func parseCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析CRL失败: %v", err)
+	}
+	return crl, nil
+}
+
+// randomSerial 生成随机证书序列号
+func randomSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+// writePEM 写入 PEM 文件
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return os.WriteFile(path, data, perm)
+}
+
+// writePrivateKey 以 PKCS#8 格式写入私钥
+func writePrivateKey(path string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("编码私钥失败: %v", err)
+	}
+	return writePEM(path, "PRIVATE KEY", der, 0600)
+}
+
+// InitLocalCA 在目录中创建新的CA证书、私钥和空的吊销列表
+func InitLocalCA(dir, commonName string, validity time.Duration) (*localCA, error) {
+	if _, err := os.Stat(filepath.Join(dir, caKeyName)); err == nil {
+		return nil, fmt.Errorf("CA已存在: %s", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, caIssuedDir), 0700); err != nil {
+		return nil, fmt.Errorf("创建CA目录失败: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成CA私钥失败: %v", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("创建CA证书失败: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writePrivateKey(filepath.Join(dir, caKeyName), key); err != nil {
+		return nil, err
+	}
+	if err := writePEM(filepath.Join(dir, caCertName), "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+
+	ca := &localCA{dir: dir, cert: cert, key: key}
+	if err := ca.writeCRL(nil, big.NewInt(1)); err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// OpenLocalCA 打开已有的本地CA
+func OpenLocalCA(dir string) (*localCA, error) {
+	cert, err := loadCACertificate(filepath.Join(dir, caCertName))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, caKeyName))
+	if err != nil {
+		return nil, fmt.Errorf("读取CA私钥失败: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("无效的CA私钥")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析CA私钥失败: %v", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的CA私钥类型")
+	}
+
+	return &localCA{dir: dir, cert: cert, key: key}, nil
+}
+
+// Issue 签发客户端证书，CN 为连接器身份
+func (ca *localCA) Issue(name string, validity time.Duration) (*x509.Certificate, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("无效的证书名称: %q", name)
+	}
+	certPath := filepath.Join(ca.dir, caIssuedDir, name+".crt")
+	if _, err := os.Stat(certPath); err == nil {
+		return nil, fmt.Errorf("证书已存在: %s", name)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成私钥失败: %v", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("签发证书失败: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(ca.dir, caIssuedDir), 0700); err != nil {
+		return nil, err
+	}
+	if err := writePrivateKey(filepath.Join(ca.dir, caIssuedDir, name+".key"), key); err != nil {
+		return nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// loadCRL 读取当前吊销列表
+func (ca *localCA) loadCRL() (*x509.RevocationList, error) {
+	data, err := os.ReadFile(filepath.Join(ca.dir, caCRLName))
+	if err != nil {
+		return nil, fmt.Errorf("读取CRL失败: %v", err)
+	}
+	return parseCRL(data)
+}
+
+// writeCRL 签发并写入吊销列表
+func (ca *localCA) writeCRL(entries []x509.RevocationListEntry, number *big.Int) error {
+	now := time.Now()
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.AddDate(1, 0, 0),
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		return fmt.Errorf("签发CRL失败: %v", err)
+	}
+
+	// 先写临时文件再重命名，避免服务器读到不完整的CRL
+	path := filepath.Join(ca.dir, caCRLName)
+	tmp := path + ".tmp"
+	if err := writePEM(tmp, "X509 CRL", der, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// findSerial 根据证书名称或十六进制序列号查找序列号
+func (ca *localCA) findSerial(nameOrSerial string) (*big.Int, error) {
+	certPath := filepath.Join(ca.dir, caIssuedDir, nameOrSerial+".crt")
+	if data, err := os.ReadFile(certPath); err == nil {
+		cert, err := parseCertificatePEM(data)
+		if err != nil {
+			return nil, err
+		}
+		return cert.SerialNumber, nil
+	}
+
+	serial, ok := new(big.Int).SetString(strings.TrimPrefix(nameOrSerial, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("未找到证书: %s", nameOrSerial)
+	}
+	return serial, nil
+}
+
+// Revoke 吊销证书并重新签发CRL
+func (ca *localCA) Revoke(nameOrSerial string) (*big.Int, error) {
+	serial, err := ca.findSerial(nameOrSerial)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := ca.loadCRL()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(serial) == 0 {
+			return nil, fmt.Errorf("证书已吊销: %s", serial.Text(16))
+		}
+	}
+
+	entries := append(crl.RevokedCertificateEntries, x509.RevocationListEntry{
+		SerialNumber:   serial,
+		RevocationTime: time.Now(),
+	})
+	number := big.NewInt(1)
+	if crl.Number != nil {
+		number.Add(crl.Number, big.NewInt(1))
+	}
+	if err := ca.writeCRL(entries, number); err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// List 列出已签发的证书及吊销状态
+func (ca *localCA) List() ([]issuedCert, error) {
+	crl, err := ca.loadCRL()
+	if err != nil {
+		return nil, err
+	}
+	revoked := make(map[string]bool)
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.String()] = true
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ca.dir, caIssuedDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	certs := make([]issuedCert, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := parseCertificatePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs = append(certs, issuedCert{
+			Name:    strings.TrimSuffix(filepath.Base(path), ".crt"),
+			Cert:    cert,
+			Revoked: revoked[cert.SerialNumber.String()],
+		})
+	}
+	return certs, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tunnel/internal/har"
+)
+
+// 抓包参数
+const (
+	captureDefaultDuration = time.Minute
+	captureMaxDuration     = time.Hour
+	captureQueueSize       = 256
+)
+
+// CaptureConfig 通过管理接口抓取 HAR 时的正文大小限制和隐去的头部
+type CaptureConfig struct {
+	MaxBodySize   int      `yaml:"maxBodySize" json:"maxBodySize"`     // 每个正文保留的字节数，默认 65536，负数表示不记录正文
+	RedactHeaders []string `yaml:"redactHeaders" json:"redactHeaders"` // 额外隐去的头部 (Authorization、Cookie 等总是隐去)
+}
+
+// captureSession 一个正在进行的抓包请求
+type captureSession struct {
+	clientID string // 为空表示不过滤
+	hostname string
+	entries  chan *har.Exchange
+	dropped  atomic.Int64
+}
+
+// captureHub 当前的抓包会话，没有会话时请求处理不做额外工作
+type captureHub struct {
+	active   atomic.Int32
+	mu       sync.RWMutex
+	sessions map[*captureSession]struct{}
+}
+
+func newCaptureHub() *captureHub {
+	return &captureHub{sessions: make(map[*captureSession]struct{})}
+}
+
+// capturing 是否有正在进行的抓包
+func (h *captureHub) capturing() bool {
+	return h.active.Load() > 0
+}
+
+func (h *captureHub) add(session *captureSession) {
+	h.mu.Lock()
+	h.sessions[session] = struct{}{}
+	h.active.Store(int32(len(h.sessions)))
+	h.mu.Unlock()
+}
+
+func (h *captureHub) remove(session *captureSession) {
+	h.mu.Lock()
+	delete(h.sessions, session)
+	h.active.Store(int32(len(h.sessions)))
+	h.mu.Unlock()
+}
+
+// publish 把请求交给匹配的会话，会话处理不过来时丢弃
+func (h *captureHub) publish(x *har.Exchange, clientID, hostname string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for session := range h.sessions {
+		if (session.clientID != "" && session.clientID != clientID) || (session.hostname != "" && session.hostname != hostname) {
+			continue
+		}
+		select {
+		case session.entries <- x:
+		default:
+			session.dropped.Add(1)
+		}
+	}
+}
+
+// captureBodyLimit 抓包时每个响应正文保留的字节数
+func (s *TunnelServer) captureBodyLimit() int {
+	switch n := s.config.Admin.Capture.MaxBodySize; {
+	case n == 0:
+		return har.DefaultMaxBodySize
+	case n < 0:
+		return 0
+	default:
+		return n
+	}
+}
+
+// captureExchange 由公网请求生成抓包记录，请求头为访客发来的原始请求头
+func (s *TunnelServer) captureExchange(r *http.Request, rec *statusRecorder, body []byte, start time.Time,
+	wait time.Duration, clientID, requestID, route string) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	x := &har.Exchange{
+		Started:          start,
+		Method:           r.Method,
+		URL:              fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI()),
+		Proto:            r.Proto,
+		RequestHeaders:   r.Header.Clone(),
+		RequestBody:      body,
+		Status:           rec.status,
+		ResponseHeaders:  rec.Header().Clone(),
+		ResponseBody:     rec.body,
+		ResponseBodySize: int(rec.bytes),
+		Wait:             wait,
+		Total:            time.Since(start),
+		Fields: map[string]string{
+			"clientId":  clientID,
+			"requestId": requestID,
+			"route":     route,
+			"remoteIp":  s.clientIP(r),
+		},
+	}
+	if rec.status >= 500 {
+		x.Error = errorMessage(rec)
+	}
+	s.captures.publish(x, clientID, normalizeHostname(r.Host))
+}
+
+// adminCapture 以 HAR 1.2 格式流式返回经过隧道的请求，持续 duration 或达到 limit 条后结束。
+// 调用方提前断开时文件不完整
+func (s *TunnelServer) adminCapture(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	duration := captureDefaultDuration
+	if v := q.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > captureMaxDuration {
+			adminError(w, http.StatusBadRequest, fmt.Sprintf("duration 应为 0 到 %s 之间的时长，如 30s", captureMaxDuration))
+			return
+		}
+		duration = d
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			adminError(w, http.StatusBadRequest, "limit 应为非负整数")
+			return
+		}
+		limit = n
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		adminError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+
+	session := &captureSession{
+		clientID: q.Get("client"),
+		hostname: normalizeHostname(q.Get("hostname")),
+		entries:  make(chan *har.Exchange, captureQueueSize),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="tunnel-%s.har"`, time.Now().Format("20060102-150405")))
+	w.Header().Set("X-Accel-Buffering", "no")
+	writer, err := har.NewWriter(w, "tunnel-server", har.Options{
+		MaxBodySize:   s.config.Admin.Capture.MaxBodySize,
+		RedactHeaders: s.config.Admin.Capture.RedactHeaders,
+	})
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	s.captures.add(session)
+	defer s.captures.remove(session)
+	adminLog.Info("开始抓包", "client_id", session.clientID, "hostname", session.hostname,
+		"duration", duration, "limit", limit, "remote", r.RemoteAddr)
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+loop:
+	for limit == 0 || writer.Count() < limit {
+		select {
+		case <-r.Context().Done():
+			adminLog.Info("抓包被调用方中断", "entries", writer.Count(), "remote", r.RemoteAddr)
+			return
+		case <-timer.C:
+			break loop
+		case x := <-session.entries:
+			if err := writer.Write(x); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+	writer.Close()
+	adminLog.Info("抓包结束", "entries", writer.Count(), "dropped", session.dropped.Load(), "remote", r.RemoteAddr)
+}
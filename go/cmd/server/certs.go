@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// CertificateConfig 一对证书和私钥文件
+type CertificateConfig struct {
+	CertFile string `yaml:"certFile" json:"certFile"`
+	KeyFile  string `yaml:"keyFile" json:"keyFile"`
+}
+
+// certPair 监视中的证书文件
+type certPair struct {
+	CertificateConfig
+	certMod time.Time
+	keyMod  time.Time
+	cert    *tls.Certificate
+}
+
+// certStore 按 SNI 选择证书，文件变化时原子地重新加载
+type certStore struct {
+	pairs []*certPair
+
+	mu       sync.RWMutex
+	names    map[string]*tls.Certificate // 证书中的域名 (含通配符) -> 证书
+	fallback *tls.Certificate
+}
+
+// newCertStore 加载证书列表，第一对证书作为默认证书
+func newCertStore(configs []CertificateConfig) (*certStore, error) {
+	cs := &certStore{}
+	for _, cfg := range configs {
+		pair := &certPair{CertificateConfig: cfg}
+		if _, err := pair.load(); err != nil {
+			return nil, err
+		}
+		cs.pairs = append(cs.pairs, pair)
+	}
+	cs.rebuild()
+	return cs, nil
+}
+
+// load 文件修改时间变化时重新读取，返回是否有更新
+func (p *certPair) load() (bool, error) {
+	certInfo, err := os.Stat(p.CertFile)
+	if err != nil {
+		return false, fmt.Errorf("读取证书文件失败: %v", err)
+	}
+	keyInfo, err := os.Stat(p.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("读取私钥文件失败: %v", err)
+	}
+	if p.cert != nil && certInfo.ModTime().Equal(p.certMod) && keyInfo.ModTime().Equal(p.keyMod) {
+		return false, nil
+	}
+
+	certPEM, err := os.ReadFile(p.CertFile)
+	if err != nil {
+		return false, fmt.Errorf("读取证书文件失败: %v", err)
+	}
+	keyPEM, err := os.ReadFile(p.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("读取私钥文件失败: %v", err)
+	}
+	cert, err := parseKeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("加载证书 %s 失败: %v", p.CertFile, err)
+	}
+
+	p.cert = cert
+	p.certMod = certInfo.ModTime()
+	p.keyMod = keyInfo.ModTime()
+	return true, nil
+}
+
+// rebuild 重建域名索引，先配置的证书优先
+func (cs *certStore) rebuild() {
+	names := make(map[string]*tls.Certificate)
+	var fallback *tls.Certificate
+	for _, pair := range cs.pairs {
+		if fallback == nil {
+			fallback = pair.cert
+		}
+		certNames := pair.cert.Leaf.DNSNames
+		if len(certNames) == 0 && pair.cert.Leaf.Subject.CommonName != "" {
+			certNames = []string{pair.cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range certNames {
+			name = strings.ToLower(name)
+			if _, exists := names[name]; !exists {
+				names[name] = pair.cert
+			}
+		}
+	}
+
+	cs.mu.Lock()
+	cs.names = names
+	cs.fallback = fallback
+	cs.mu.Unlock()
+}
+
+// reload 检查所有证书文件，加载失败时保留旧证书
+func (cs *certStore) reload() {
+	changed := false
+	for _, pair := range cs.pairs {
+		updated, err := pair.load()
+		if err != nil {
+			tlsLog.Error("重新加载证书失败，继续使用旧证书", "error", err)
+			continue
+		}
+		if updated {
+			tlsLog.Info("证书已重新加载", "file", pair.CertFile, "not_after", pair.cert.Leaf.NotAfter.Format(time.RFC3339))
+			changed = true
+		}
+	}
+	if changed {
+		cs.rebuild()
+	}
+}
+
+// watch 定期检查证书文件，收到 SIGHUP 时立即检查
+func (cs *certStore) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-hup:
+			tlsLog.Info("收到 SIGHUP，重新加载证书")
+		}
+		cs.reload()
+	}
+}
+
+// match 按 SNI 查找证书：先精确匹配，再匹配通配符
+func (cs *certStore) match(serverName string) *tls.Certificate {
+	name := normalizeHostname(serverName)
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if cert, ok := cs.names[name]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := cs.names["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// defaultCert 返回默认证书
+func (cs *certStore) defaultCert() *tls.Certificate {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.fallback
+}
+
+// certificateConfigs 返回需要加载的证书文件列表
+func (s *TunnelServer) certificateConfigs() []CertificateConfig {
+	configs := []CertificateConfig{}
+	// 启用ACME时主证书由ACME提供，只加载额外配置的证书
+	if !s.config.ACME.Enabled && s.config.Server.CertFile != "" {
+		configs = append(configs, CertificateConfig{CertFile: s.config.Server.CertFile, KeyFile: s.config.Server.KeyFile})
+	}
+	return append(configs, s.config.Server.Certificates...)
+}
+
+// getCertificate 为TLS握手选择证书：ACME验证 > SNI匹配的证书文件 > ACME证书 > 默认证书
+func (s *TunnelServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.acme != nil && len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		return s.acme.GetCertificate(hello)
+	}
+
+	if s.certs != nil {
+		if cert := s.certs.match(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+	}
+	if s.acme != nil {
+		if cert, err := s.acme.GetCertificate(hello); err == nil {
+			return cert, nil
+		}
+	}
+	if s.certs != nil {
+		if cert := s.certs.defaultCert(); cert != nil {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("没有可用于 %q 的证书", hello.ServerName)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 客户端声明的访问策略默认上限
+const (
+	defaultMaxPolicyUsers  = 10
+	defaultMaxPolicyCIDRs  = 32
+	defaultMaxPolicyEmails = 50
+	// 客户端声明的密码哈希只接受 bcrypt，并限制计算成本 (客户端默认使用 bcrypt.DefaultCost)，
+	// 避免客户端用高成本的哈希让服务器在每次访客登录时耗费大量 CPU 或内存
+	maxPolicyBcryptCost = 12
+)
+
+// ClientPolicyConfig 限制客户端在握手时可以声明的访问策略
+type ClientPolicyConfig struct {
+	Enabled   bool `yaml:"enabled" json:"enabled"`     // 是否接受客户端声明的策略，默认 true
+	BasicAuth bool `yaml:"basicAuth" json:"basicAuth"` // 允许 --basic-auth，默认 true
+	AllowCIDR bool `yaml:"allowCidr" json:"allowCidr"` // 允许 --allow-cidr，默认 true
+	OAuth     bool `yaml:"oauth" json:"oauth"`         // 允许 --oauth-allowed-emails，默认 true (还需要配置 oidc)
+	MaxUsers  int  `yaml:"maxUsers" json:"maxUsers"`
+	MaxCIDRs  int  `yaml:"maxCidrs" json:"maxCidrs"`
+	MaxEmails int  `yaml:"maxEmails" json:"maxEmails"`
+	// 主机名同时命中服务器策略时，false (默认) 表示两者都执行 (客户端策略只能进一步收紧)；
+	// true 表示只执行服务器策略，客户端在握手时会收到警告
+	Override bool `yaml:"override" json:"override"`
+	// 客户端使用 --oauth-allowed-emails 时登录的身份提供者，其中的 allowed* 规则会被客户端声明的邮箱替换
+	OIDC *OIDCConfig `yaml:"oidc" json:"oidc"`
+}
+
+// declaredPolicy 客户端通过 X-Tunnel-Policy 头发送的策略
+type declaredPolicy struct {
+	BasicAuth          []string `json:"basicAuth,omitempty"` // htpasswd 格式的 "user:hash"
+	AllowCIDRs         []string `json:"allowCidrs,omitempty"`
+	OAuthAllowedEmails []string `json:"oauthAllowedEmails,omitempty"` // "@example.com" 表示整个域名
+}
+
+// summary 用于日志和客户端列表的策略摘要
+func (d declaredPolicy) summary() string {
+	var parts []string
+	if len(d.BasicAuth) > 0 {
+		parts = append(parts, fmt.Sprintf("basicAuth(%d)", len(d.BasicAuth)))
+	}
+	if len(d.AllowCIDRs) > 0 {
+		parts = append(parts, "allowCidr("+strings.Join(d.AllowCIDRs, ",")+")")
+	}
+	if len(d.OAuthAllowedEmails) > 0 {
+		parts = append(parts, "oauth("+strings.Join(d.OAuthAllowedEmails, ",")+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// loadClientPolicyOIDC 解析客户端策略使用的身份提供者，所有客户端共用发现文档和公钥缓存
+func (s *TunnelServer) loadClientPolicyOIDC() error {
+	s.clientOIDC = nil
+	cfg := s.config.Access.ClientPolicies
+	if !cfg.Enabled || !cfg.OAuth || cfg.OIDC == nil {
+		return nil
+	}
+	oidc, err := parseOIDC(cfg.OIDC)
+	if err != nil {
+		return fmt.Errorf("clientPolicies.oidc 配置错误: %v", err)
+	}
+	s.clientOIDC = oidc
+	return nil
+}
+
+// parseClientPolicy 读取并校验客户端声明的访问策略，未声明时返回 nil
+func (s *TunnelServer) parseClientPolicy(r *http.Request, clientID string, route TunnelRoute) (*accessPolicy, string, error) {
+	raw := r.Header.Get("X-Tunnel-Policy")
+	if raw == "" {
+		return nil, "", nil
+	}
+	var declared declaredPolicy
+	if err := json.Unmarshal([]byte(raw), &declared); err != nil {
+		return nil, "", fmt.Errorf("访问策略格式错误: %v", err)
+	}
+	if len(declared.BasicAuth) == 0 && len(declared.AllowCIDRs) == 0 && len(declared.OAuthAllowedEmails) == 0 {
+		return nil, "", nil
+	}
+
+	limits := s.config.Access.ClientPolicies
+	if !limits.Enabled {
+		return nil, "", fmt.Errorf("服务器不接受客户端声明的访问策略")
+	}
+	if err := checkPolicyLimit("basicAuth", len(declared.BasicAuth), limits.BasicAuth, limits.MaxUsers, defaultMaxPolicyUsers); err != nil {
+		return nil, "", err
+	}
+	if err := checkPolicyLimit("allowCidr", len(declared.AllowCIDRs), limits.AllowCIDR, limits.MaxCIDRs, defaultMaxPolicyCIDRs); err != nil {
+		return nil, "", err
+	}
+	if err := checkPolicyLimit("oauth", len(declared.OAuthAllowedEmails), limits.OAuth, limits.MaxEmails, defaultMaxPolicyEmails); err != nil {
+		return nil, "", err
+	}
+	if len(declared.OAuthAllowedEmails) > 0 && s.clientOIDC == nil {
+		return nil, "", fmt.Errorf("服务器未配置身份提供者，不支持 oauth")
+	}
+
+	for _, line := range declared.BasicAuth {
+		if err := checkPolicyPasswordHash(line); err != nil {
+			return nil, "", err
+		}
+	}
+
+	cfg := AccessPolicyConfig{Allow: declared.AllowCIDRs}
+	if len(declared.BasicAuth) > 0 {
+		realm := route.Hostname
+		if realm == "" {
+			realm = "Restricted"
+		}
+		cfg.BasicAuth = &BasicAuthConfig{Realm: realm, Users: declared.BasicAuth}
+	}
+	if len(declared.OAuthAllowedEmails) > 0 {
+		if cfg.BasicAuth != nil {
+			return nil, "", fmt.Errorf("basicAuth 和 oauth 不能同时声明")
+		}
+		oidc := *s.clientOIDC.config
+		oidc.AllowedEmails, oidc.AllowedDomains, oidc.AllowedGroups = nil, nil, nil
+		for _, email := range declared.OAuthAllowedEmails {
+			email = strings.TrimSpace(email)
+			switch {
+			case strings.HasPrefix(email, "@"):
+				oidc.AllowedDomains = append(oidc.AllowedDomains, email[1:])
+			case strings.Contains(email, "@"):
+				oidc.AllowedEmails = append(oidc.AllowedEmails, email)
+			default:
+				return nil, "", fmt.Errorf("无效的邮箱: %q", email)
+			}
+		}
+		cfg.OIDC = &oidc
+	}
+
+	defaultMessage := s.config.Access.DenyMessage
+	if defaultMessage == "" {
+		defaultMessage = defaultDenyMessage
+	}
+	policy, err := parseAccessPolicy(cfg, defaultMessage)
+	if err != nil {
+		return nil, "", fmt.Errorf("访问策略配置错误: %v", err)
+	}
+	if policy.oidc != nil {
+		// 共用服务器的发现文档和公钥缓存
+		policy.oidc.provider, policy.oidc.ttl = s.clientOIDC.provider, s.clientOIDC.ttl
+	}
+	policy.name = "客户端 " + clientID
+	return policy, declared.summary(), nil
+}
+
+// checkPolicyLimit 检查某项声明是否被服务器允许以及数量上限
+func checkPolicyLimit(name string, count int, allowed bool, max, defaultMax int) error {
+	if count == 0 {
+		return nil
+	}
+	if !allowed {
+		return fmt.Errorf("服务器不允许客户端声明 %s", name)
+	}
+	if max <= 0 {
+		max = defaultMax
+	}
+	if count > max {
+		return fmt.Errorf("%s 数量超过上限 (%d > %d)", name, count, max)
+	}
+	return nil
+}
+
+// checkPolicyPasswordHash 检查客户端声明的 "user:hash"，只接受成本不超过上限的 bcrypt
+// (argon2id、apr1 和 {SHA} 只能由服务器配置)
+func checkPolicyPasswordHash(line string) error {
+	user, hash, _ := strings.Cut(line, ":")
+	if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+		return fmt.Errorf("basicAuth 用户 %s 的密码哈希必须是 bcrypt", user)
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return fmt.Errorf("basicAuth 用户 %s 的密码哈希无效: %v", user, err)
+	}
+	if cost > maxPolicyBcryptCost {
+		return fmt.Errorf("basicAuth 用户 %s 的 bcrypt 成本过高 (%d > %d)", user, cost, maxPolicyBcryptCost)
+	}
+	return nil
+}
+
+// policiesFor 返回请求需要执行的访问策略：服务器策略在前，客户端声明的策略在后
+func (s *TunnelServer) policiesFor(hostname string, client *Client) []*accessPolicy {
+	var policies []*accessPolicy
+	if policy := s.accessPolicyFor(hostname); policy != nil {
+		policies = append(policies, policy)
+	}
+	if client != nil && client.Policy != nil && !(len(policies) > 0 && s.config.Access.ClientPolicies.Override) {
+		policies = append(policies, client.Policy)
+	}
+	return policies
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"tunnel/internal/testutil"
+)
+
+// newClientPolicyTestClient 服务器对 *.test 配置只限制地址的兜底策略，客户端声明基本认证
+func newClientPolicyTestClient(t *testing.T, override bool) (*TunnelServer, *Client) {
+	t.Helper()
+	config := DefaultConfig()
+	config.Access.Policies = []AccessPolicyConfig{{Hostnames: []string{"*.test"}, Allow: []string{"0.0.0.0/0"}}}
+	config.Access.ClientPolicies.Override = override
+	s := NewTunnelServer(config)
+	if err := s.loadAccessPolicies(); err != nil {
+		t.Fatalf("加载访问策略失败: %v", err)
+	}
+
+	return s, declareClientPolicy(t, s, declaredPolicy{BasicAuth: []string{bcryptUser("bob", "secret")}})
+}
+
+// declareClientPolicy 模拟客户端在握手时声明访问策略，注册主机名 app.test
+func declareClientPolicy(t *testing.T, s *TunnelServer, declared declaredPolicy) *Client {
+	t.Helper()
+	data, _ := json.Marshal(declared)
+	r := httptest.NewRequest(http.MethodGet, "http://tunnel.test/", nil)
+	r.Header.Set("X-Tunnel-Policy", string(data))
+	route := TunnelRoute{Hostname: "app.test"}
+	policy, _, err := s.parseClientPolicy(r, "client_1", route)
+	if err != nil {
+		t.Fatalf("解析客户端策略失败: %v", err)
+	}
+	return &Client{ID: "client_1", Route: route, Policy: policy}
+}
+
+func bcryptUser(user, password string) string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	return user + ":" + string(hash)
+}
+
+func TestClientPolicyEnforcedWithServerPolicy(t *testing.T) {
+	s, client := newClientPolicyTestClient(t, DefaultConfig().Access.ClientPolicies.Override)
+
+	if policies := s.policiesFor("app.test", client); len(policies) != 2 {
+		t.Fatalf("默认应同时执行服务器策略和客户端策略，得到 %d 条", len(policies))
+	}
+	rec := httptest.NewRecorder()
+	if _, ok := s.checkAccess(rec, httptest.NewRequest(http.MethodGet, "http://app.test/", nil), client); ok ||
+		rec.Code != http.StatusUnauthorized {
+		t.Errorf("服务器的地址策略不应取消客户端声明的基本认证，得到 %d", rec.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+	r.SetBasicAuth("bob", "secret")
+	if decision, ok := s.checkAccess(httptest.NewRecorder(), r, client); !ok || decision.identity.User != "bob" {
+		t.Error("通过客户端声明的基本认证后应允许访问")
+	}
+}
+
+func TestClientPolicyOverride(t *testing.T) {
+	s, client := newClientPolicyTestClient(t, true)
+
+	if policies := s.policiesFor("app.test", client); len(policies) != 1 || policies[0] == client.Policy {
+		t.Fatal("override 时命中服务器策略的主机名只执行服务器策略")
+	}
+	if policies := s.policiesFor("app.example.com", client); len(policies) != 1 || policies[0] != client.Policy {
+		t.Error("没有命中服务器策略时仍应执行客户端策略")
+	}
+}
+
+func TestClientBasicAuthWithServerBasicAuth(t *testing.T) {
+	config := DefaultConfig()
+	config.Access.Policies = []AccessPolicyConfig{{
+		Hostnames:      []string{"*.test"},
+		BasicAuth:      &BasicAuthConfig{Users: []string{bcryptUser("alice", "server-pw"), bcryptUser("bob", "secret")}},
+		IdentityHeader: "X-Server-User",
+	}}
+	s := NewTunnelServer(config)
+	if err := s.loadAccessPolicies(); err != nil {
+		t.Fatalf("加载访问策略失败: %v", err)
+	}
+	client := declareClientPolicy(t, s, declaredPolicy{BasicAuth: []string{bcryptUser("bob", "secret")}})
+
+	tests := []struct {
+		user, password string
+		want           int
+	}{
+		{"alice", "server-pw", http.StatusUnauthorized}, // 只通过服务器的基本认证
+		{"bob", "wrong", http.StatusUnauthorized},
+		{"bob", "secret", http.StatusOK}, // 两条策略都通过
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+		r.SetBasicAuth(tt.user, tt.password)
+		rec := httptest.NewRecorder()
+		decision, ok := s.checkAccess(rec, r, client)
+		if ok {
+			rec.Code = http.StatusOK
+		}
+		if rec.Code != tt.want {
+			t.Errorf("%s:%s 得到 %d, 期望 %d", tt.user, tt.password, rec.Code, tt.want)
+		}
+		if !ok {
+			continue
+		}
+		if len(decision.auths) != 2 {
+			t.Errorf("应记录两条策略的认证结果，得到 %d", len(decision.auths))
+		}
+		headers := map[string]string{"Authorization": "Basic x", defaultAuthHeader: "spoofed"}
+		applyIdentityHeaders(decision, headers)
+		if headers["X-Server-User"] != "bob" || headers[defaultAuthHeader] != "bob" || headers["Authorization"] != "" {
+			t.Errorf("每条策略的身份头都应写入，边缘凭据不应转发: %v", headers)
+		}
+	}
+}
+
+func TestClientOAuthEmailsWithServerOIDC(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	s := newOIDCTestServer(t, idp)
+	s.config.Access.ClientPolicies.OIDC = &OIDCConfig{Issuer: idp.Issuer, ClientID: idp.ClientID, ClientSecret: idp.ClientSecret}
+	if err := s.loadClientPolicyOIDC(); err != nil {
+		t.Fatalf("加载客户端策略的身份提供者失败: %v", err)
+	}
+
+	// alice@example.com 满足服务器的 allowedDomains
+	session := responseCookie(oidcLogin(t, s, "http://app.test/"), edgeSessionCookie)
+	if session == nil {
+		t.Fatal("登录后应设置会话 Cookie")
+	}
+
+	request := func(client *Client) int {
+		r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+		r.AddCookie(session)
+		rec := httptest.NewRecorder()
+		if _, ok := s.checkAccess(rec, r, client); ok {
+			return http.StatusOK
+		}
+		return rec.Code
+	}
+	if code := request(declareClientPolicy(t, s, declaredPolicy{OAuthAllowedEmails: []string{"bob@example.com"}})); code != http.StatusForbidden {
+		t.Errorf("服务器的 OIDC 策略不应取消客户端声明的邮箱限制，得到 %d", code)
+	}
+	if code := request(declareClientPolicy(t, s, declaredPolicy{OAuthAllowedEmails: []string{"alice@example.com"}})); code != http.StatusOK {
+		t.Errorf("同时满足服务器和客户端的授权规则时应允许访问，得到 %d", code)
+	}
+}
+
+func TestClientPolicyPasswordHash(t *testing.T) {
+	s := NewTunnelServer(DefaultConfig())
+	bcryptLine := bcryptUser("bob", "secret") // bcrypt.MinCost
+	argon, _ := HashToken("secret")
+
+	tests := []struct {
+		name string
+		line string
+		ok   bool
+	}{
+		{"bcrypt", bcryptLine, true},
+		{"bcrypt $2y$", strings.Replace(bcryptLine, "$2a$", "$2y$", 1), true},
+		{"bcrypt 成本过高", strings.Replace(bcryptLine, "$04$", "$31$", 1), false},
+		{"argon2id", "bob:" + argon, false},
+		{"argon2id 大内存", "bob:$argon2id$v=19$m=4194304,t=100,p=255$c2FsdA$a2V5", false},
+		{"apr1", "bob:$apr1$salt$VxSBUdxEtt8XlmvYq.S8h/", false},
+		{"SHA", "bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", false},
+		{"无效的 bcrypt", "bob:$2a$xx", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(declaredPolicy{BasicAuth: []string{tt.line}})
+			r := httptest.NewRequest(http.MethodGet, "http://tunnel.test/", nil)
+			r.Header.Set("X-Tunnel-Policy", string(data))
+			_, _, err := s.parseClientPolicy(r, "client_1", TunnelRoute{Hostname: "app.test"})
+			if (err == nil) != tt.ok {
+				t.Errorf("ok = %v, 期望 %v (err=%v)", err == nil, tt.ok, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// 控制台参数
+const (
+	dashboardRecentErrors = 50              // 保留的最近错误数
+	dashboardInterval     = time.Second     // 推送快照的间隔
+	dashboardRetry        = 3 * time.Second // 断开后浏览器重连的间隔
+)
+
+// dashboardError 一条失败的公网请求 (5xx)
+type dashboardError struct {
+	Time      time.Time `json:"time"`
+	ClientID  string    `json:"clientId,omitempty"`
+	RequestID string    `json:"requestId,omitempty"`
+	Route     string    `json:"route"`
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message"`
+}
+
+// dashboard 控制台的全局计数、最近错误和 SSE 订阅者
+type dashboard struct {
+	requestMeter *throughputMeter
+	errorMeter   *throughputMeter
+
+	mu          sync.Mutex
+	errors      []dashboardError // 按时间从旧到新
+	subscribers map[chan dashboardError]struct{}
+}
+
+func newDashboard() *dashboard {
+	return &dashboard{
+		requestMeter: &throughputMeter{},
+		errorMeter:   &throughputMeter{},
+		subscribers:  make(map[chan dashboardError]struct{}),
+	}
+}
+
+// dashboardHandler 提供嵌入的控制台页面。页面本身不含数据，数据接口需要管理令牌
+func dashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
+
+// observe 记录一个已完成的公网请求，5xx 响应加入最近错误并推送给订阅者
+func (d *dashboard) observe(client *Client, r *http.Request, rec *statusRecorder, route, requestID string) {
+	d.requestMeter.add(1)
+	if client != nil {
+		client.requestMeter.add(1)
+	}
+	if rec.status < 500 {
+		return
+	}
+	d.errorMeter.add(1)
+	e := dashboardError{
+		Time:      time.Now(),
+		RequestID: requestID,
+		Route:     route,
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+		Status:    rec.status,
+		Message:   errorMessage(rec),
+	}
+	if client != nil {
+		client.errorMeter.add(1)
+		e.ClientID = client.ID
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errors = append(d.errors, e)
+	if len(d.errors) > dashboardRecentErrors {
+		d.errors = d.errors[len(d.errors)-dashboardRecentErrors:]
+	}
+	for ch := range d.subscribers {
+		select {
+		case ch <- e:
+		default: // 订阅者处理不过来时丢弃，下次连接时会收到最近错误列表
+		}
+	}
+}
+
+// errorMessage 错误响应体的第一行，没有响应体时使用状态码的说明
+func errorMessage(rec *statusRecorder) string {
+	message, _, _ := strings.Cut(strings.TrimSpace(string(rec.errorBody)), "\n")
+	if message == "" {
+		message = http.StatusText(rec.status)
+	}
+	return message
+}
+
+// subscribe 订阅新的错误，同时返回当前的最近错误
+func (d *dashboard) subscribe() (chan dashboardError, []dashboardError) {
+	ch := make(chan dashboardError, 16)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[ch] = struct{}{}
+	recent := make([]dashboardError, len(d.errors))
+	copy(recent, d.errors)
+	return ch, recent
+}
+
+func (d *dashboard) unsubscribe(ch chan dashboardError) {
+	d.mu.Lock()
+	delete(d.subscribers, ch)
+	d.mu.Unlock()
+}
+
+// dashboardSnapshot 客户端、令牌和总计，每秒推送一次
+func (s *TunnelServer) dashboardSnapshot() map[string]interface{} {
+	pending := s.pendingCounts()
+	s.clientsMux.RLock()
+	clients := make([]map[string]interface{}, 0, len(s.clients))
+	var inRate, outRate float64
+	for _, client := range s.clients {
+		clients = append(clients, s.clientInfo(client, pending[client.ID]))
+		in, _ := client.inMeter.rate()
+		out, _ := client.outMeter.rate()
+		inRate, outRate = inRate+in, outRate+out
+	}
+	s.clientsMux.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i]["id"].(string) < clients[j]["id"].(string) })
+
+	pendingTotal := 0
+	for _, n := range pending {
+		pendingTotal += n
+	}
+	requestRate, requestTotal := s.dashboard.requestMeter.rate()
+	errorRate, errorTotal := s.dashboard.errorMeter.rate()
+	return map[string]interface{}{
+		"time":      time.Now(),
+		"startedAt": s.startedAt,
+		"uptime":    int64(time.Since(s.startedAt).Seconds()),
+		"clients":   clients,
+		"tokens":    s.tokenInfos(),
+		"totals": map[string]interface{}{
+			"clients":           len(clients),
+			"pendingRequests":   pendingTotal,
+			"requestsPerSecond": requestRate,
+			"requests":          requestTotal,
+			"errorsPerSecond":   errorRate,
+			"errors":            errorTotal,
+			"inBytesPerSecond":  inRate,
+			"outBytesPerSecond": outRate,
+		},
+	}
+}
+
+// handleDashboardEvents 以 SSE 推送控制台数据：
+// 连接时发送 errors (最近错误列表)，之后每秒发送 snapshot，出现新错误时立即发送 error
+func (s *TunnelServer) handleDashboardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		adminError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	errs, recent := s.dashboard.subscribe()
+	defer s.dashboard.unsubscribe(errs)
+	adminLog.Debug("控制台已连接", "remote", r.RemoteAddr)
+	defer adminLog.Debug("控制台已断开", "remote", r.RemoteAddr)
+
+	fmt.Fprintf(w, "retry: %d\n\n", dashboardRetry.Milliseconds())
+	send := func(event string, v interface{}) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if send("errors", recent) != nil || send("snapshot", s.dashboardSnapshot()) != nil {
+		return
+	}
+
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-errs:
+			err = send("error", e)
+		case <-ticker.C:
+			err = send("snapshot", s.dashboardSnapshot())
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// 隧道控制台：通过 /api/events (SSE) 接收实时数据，操作调用管理接口。
+// EventSource 不能设置请求头，这里用 fetch 读取事件流以携带管理令牌。
+(function () {
+  "use strict";
+
+  var TOKEN_KEY = "tunnel-admin-token";
+  var HISTORY = 60; // 迷你图保留的秒数
+
+  var token = sessionStorage.getItem(TOKEN_KEY) || "";
+  var controller = null;
+  var retryMs = 3000;
+  var retryTimer = null;
+  var recentErrors = [];
+  var history = { rps: [], eps: [], in: [], out: [] };
+
+  function $(id) { return document.getElementById(id); }
+
+  // el 创建元素，children 为字符串时作为文本 (不解析 HTML)
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) {
+      if (k === "onclick") node.onclick = attrs[k];
+      else if (k === "disabled") node.disabled = !!attrs[k];
+      else node.setAttribute(k, attrs[k]);
+    });
+    [].concat(children === undefined ? [] : children).forEach(function (c) {
+      if (c === null || c === undefined) return;
+      node.appendChild(typeof c === "object" ? c : document.createTextNode(String(c)));
+    });
+    return node;
+  }
+
+  function bitRate(bytesPerSecond) {
+    var bits = (bytesPerSecond || 0) * 8;
+    if (bits >= 1e9) return (bits / 1e9).toFixed(1) + " Gbit/s";
+    if (bits >= 1e6) return (bits / 1e6).toFixed(1) + " Mbit/s";
+    if (bits >= 1e3) return (bits / 1e3).toFixed(1) + " kbit/s";
+    return bits.toFixed(0) + " bit/s";
+  }
+
+  function bytes(n) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    n = n || 0;
+    while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+    return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+  }
+
+  function rate(n) {
+    n = n || 0;
+    return n >= 10 ? n.toFixed(0) : n.toFixed(1);
+  }
+
+  function duration(seconds) {
+    seconds = Math.max(0, Math.floor(seconds));
+    var d = Math.floor(seconds / 86400), h = Math.floor(seconds % 86400 / 3600), m = Math.floor(seconds % 3600 / 60);
+    if (d > 0) return d + "天 " + h + "小时";
+    if (h > 0) return h + "小时 " + m + "分";
+    if (m > 0) return m + "分 " + seconds % 60 + "秒";
+    return seconds + "秒";
+  }
+
+  function time(value) {
+    var t = new Date(value);
+    return isNaN(t) ? "-" : t.toLocaleString();
+  }
+
+  function setStatus(online, text) {
+    var s = $("status");
+    s.textContent = text;
+    s.className = "status " + (online ? "online" : "offline");
+  }
+
+  function showLogin(message) {
+    $("main").hidden = true;
+    $("logout").hidden = true;
+    $("login").hidden = false;
+    $("login-error").textContent = message || "";
+    $("token").focus();
+  }
+
+  // api 调用管理接口，返回解析后的 JSON，失败时抛出错误信息
+  function api(method, path) {
+    return fetch(path, { method: method, headers: { Authorization: "Bearer " + token } })
+      .then(function (resp) {
+        return resp.json().catch(function () { return {}; }).then(function (body) {
+          if (resp.status === 401) {
+            disconnect();
+            showLogin("管理令牌无效");
+          }
+          if (!resp.ok) throw new Error(body.error || resp.statusText);
+          return body;
+        });
+      });
+  }
+
+  function action(button, method, path, confirmText) {
+    if (confirmText && !window.confirm(confirmText)) return;
+    button.disabled = true;
+    api(method, path).catch(function (err) {
+      window.alert("操作失败: " + err.message);
+    }).then(function () {
+      button.disabled = false;
+    });
+  }
+
+  // ---- 事件流 ----
+
+  function connect() {
+    disconnect();
+    $("login").hidden = true;
+    $("main").hidden = false;
+    $("logout").hidden = false;
+    setStatus(false, "连接中…");
+
+    controller = new AbortController();
+    var signal = controller.signal;
+    fetch("/api/events", { headers: { Authorization: "Bearer " + token }, signal: signal })
+      .then(function (resp) {
+        if (resp.status === 401) {
+          showLogin("管理令牌无效");
+          return;
+        }
+        if (!resp.ok || !resp.body) throw new Error(resp.statusText);
+        setStatus(true, "实时");
+        return readEvents(resp.body.getReader());
+      })
+      .then(function () { if (!signal.aborted && !$("main").hidden) scheduleRetry(); })
+      .catch(function () { if (!signal.aborted) scheduleRetry(); });
+  }
+
+  function disconnect() {
+    clearTimeout(retryTimer);
+    if (controller) controller.abort();
+    controller = null;
+  }
+
+  function scheduleRetry() {
+    setStatus(false, "已断开，" + Math.round(retryMs / 1000) + " 秒后重连");
+    clearTimeout(retryTimer);
+    retryTimer = setTimeout(connect, retryMs);
+  }
+
+  // readEvents 按 SSE 格式解析事件流
+  function readEvents(reader) {
+    var decoder = new TextDecoder();
+    var buffer = "";
+    function pump() {
+      return reader.read().then(function (result) {
+        if (result.done) return;
+        buffer += decoder.decode(result.value, { stream: true });
+        var blocks = buffer.split("\n\n");
+        buffer = blocks.pop();
+        blocks.forEach(dispatch);
+        return pump();
+      });
+    }
+    return pump();
+  }
+
+  function dispatch(block) {
+    var event = "message", data = [];
+    block.split("\n").forEach(function (line) {
+      var i = line.indexOf(":");
+      var field = i < 0 ? line : line.slice(0, i);
+      var value = i < 0 ? "" : line.slice(i + 1).replace(/^ /, "");
+      if (field === "event") event = value;
+      else if (field === "data") data.push(value);
+      else if (field === "retry" && /^\d+$/.test(value)) retryMs = parseInt(value, 10);
+    });
+    if (data.length === 0) return;
+    var payload = JSON.parse(data.join("\n"));
+    if (event === "snapshot") renderSnapshot(payload);
+    else if (event === "errors") { recentErrors = payload || []; renderErrors(); }
+    else if (event === "error") { recentErrors.push(payload); recentErrors = recentErrors.slice(-50); renderErrors(); }
+  }
+
+  // ---- 渲染 ----
+
+  function pushHistory(key, value) {
+    history[key].push(value || 0);
+    if (history[key].length > HISTORY) history[key].shift();
+  }
+
+  function sparkline(id, values, color) {
+    var canvas = $(id), ctx = canvas.getContext("2d");
+    var w = canvas.width, h = canvas.height;
+    var max = Math.max.apply(null, values.concat([1e-9]));
+    ctx.clearRect(0, 0, w, h);
+    ctx.strokeStyle = color;
+    ctx.lineWidth = 1.5;
+    ctx.beginPath();
+    values.forEach(function (v, i) {
+      var x = w - (values.length - 1 - i) * (w / (HISTORY - 1));
+      var y = h - 2 - (v / max) * (h - 4);
+      if (i === 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+    });
+    ctx.stroke();
+  }
+
+  function renderSnapshot(s) {
+    var t = s.totals || {};
+    $("t-clients").textContent = t.clients;
+    $("t-rps").textContent = rate(t.requestsPerSecond);
+    $("t-eps").textContent = rate(t.errorsPerSecond);
+    $("t-in").textContent = bitRate(t.inBytesPerSecond);
+    $("t-out").textContent = bitRate(t.outBytesPerSecond);
+    $("t-pending").textContent = t.pendingRequests;
+    $("t-uptime").textContent = duration(s.uptime);
+
+    pushHistory("rps", t.requestsPerSecond);
+    pushHistory("eps", t.errorsPerSecond);
+    pushHistory("in", t.inBytesPerSecond);
+    pushHistory("out", t.outBytesPerSecond);
+    sparkline("c-rps", history.rps, "#2f6fdb");
+    sparkline("c-eps", history.eps, "#c9362c");
+    sparkline("c-in", history.in, "#2f8f4e");
+    sparkline("c-out", history.out, "#7a4fd6");
+
+    renderClients(s.clients || []);
+    renderTokens(s.tokens || []);
+  }
+
+  function renderClients(clients) {
+    var body = $("clients");
+    body.textContent = "";
+    if (clients.length === 0) {
+      body.appendChild(el("tr", {}, el("td", { colspan: 12, class: "empty" }, "没有在线的客户端")));
+      return;
+    }
+    clients.forEach(function (c) {
+      var tp = c.throughput || {}, rq = c.requests || {};
+      var id = encodeURIComponent(c.id);
+      var drain = c.draining
+        ? el("button", { onclick: function () { action(this, "DELETE", "/api/clients/" + id + "/drain"); } }, "恢复")
+        : el("button", { onclick: function () { action(this, "POST", "/api/clients/" + id + "/drain"); } }, "排空");
+      var kick = el("button", {
+        class: "danger",
+        onclick: function () { action(this, "DELETE", "/api/clients/" + id, "断开客户端 " + c.id + "？客户端不会自动重连。"); }
+      }, "断开");
+      body.appendChild(el("tr", {}, [
+        el("td", {}, [c.id, el("div", { class: "sub" }, c.type || "http")]),
+        el("td", {}, c.hostname || "*"),
+        el("td", {}, [c.token || "-", c.identity ? el("div", { class: "sub" }, c.identity) : null]),
+        el("td", {}, c.remoteAddr || "-"),
+        el("td", {}, [time(c.connectedAt), el("div", { class: "sub" }, duration((Date.now() - new Date(c.connectedAt)) / 1000))]),
+        el("td", { class: "num" }, [rate(rq.perSecond), el("div", { class: "sub" }, rq.total || 0)]),
+        el("td", { class: "num" }, [rate(rq.errorsPerSecond), el("div", { class: "sub" }, rq.errors || 0)]),
+        el("td", { class: "num" }, [bitRate(tp.inBytesPerSecond), el("div", { class: "sub" }, bytes(tp.inTotal))]),
+        el("td", { class: "num" }, [bitRate(tp.outBytesPerSecond), el("div", { class: "sub" }, bytes(tp.outTotal))]),
+        el("td", { class: "num" }, c.pendingRequests),
+        el("td", {}, c.draining ? el("span", { class: "tag draining" }, "排空中") : el("span", { class: "tag" }, "正常")),
+        el("td", {}, [drain, kick])
+      ]));
+    });
+  }
+
+  function renderErrors() {
+    var body = $("errors");
+    body.textContent = "";
+    if (recentErrors.length === 0) {
+      body.appendChild(el("tr", {}, el("td", { colspan: 5, class: "empty" }, "没有错误")));
+      return;
+    }
+    recentErrors.slice().reverse().forEach(function (e) {
+      body.appendChild(el("tr", {}, [
+        el("td", {}, time(e.time)),
+        el("td", {}, el("span", { class: "tag expired" }, e.status)),
+        el("td", {}, [e.method + " " + e.path, el("div", { class: "sub" }, e.host)]),
+        el("td", {}, [e.clientId || "-", e.requestId ? el("div", { class: "sub" }, e.requestId) : null]),
+        el("td", { class: "error-text" }, e.message)
+      ]));
+    });
+  }
+
+  function renderTokens(tokens) {
+    var body = $("tokens");
+    body.textContent = "";
+    if (tokens.length === 0) {
+      body.appendChild(el("tr", {}, el("td", { colspan: 6, class: "empty" }, "没有配置令牌")));
+      return;
+    }
+    tokens.forEach(function (t) {
+      // 配置文件中的令牌只能修改配置文件后重启服务器来吊销
+      var revoke = t.source === "api"
+        ? el("button", {
+          class: "danger",
+          onclick: function () {
+            action(this, "DELETE", "/api/tokens/" + encodeURIComponent(t.name),
+              "吊销令牌 " + t.name + "？使用该令牌的客户端会被断开。");
+          }
+        }, "吊销")
+        : el("button", { disabled: true, title: "配置文件中的令牌需要从配置文件中删除后重启服务器" }, "吊销");
+      body.appendChild(el("tr", {}, [
+        el("td", {}, t.name || "(未命名)"),
+        el("td", {}, t.source === "api" ? "管理接口" : "配置文件"),
+        el("td", {}, t.scope || "不限制"),
+        el("td", { class: "num" }, t.connections),
+        el("td", {}, t.expiresAt ? [time(t.expiresAt), t.expired ? el("span", { class: "tag expired" }, "已过期") : null] : "-"),
+        el("td", {}, revoke)
+      ]));
+    });
+  }
+
+  // ---- 登录 ----
+
+  $("login-form").onsubmit = function (e) {
+    e.preventDefault();
+    token = $("token").value.trim();
+    sessionStorage.setItem(TOKEN_KEY, token);
+    connect();
+  };
+
+  $("logout").onclick = function () {
+    disconnect();
+    token = "";
+    sessionStorage.removeItem(TOKEN_KEY);
+    setStatus(false, "未连接");
+    showLogin();
+  };
+
+  renderErrors();
+  if (token) connect(); else showLogin();
+})();
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 边缘认证使用的保留路径和 Cookie
+const (
+	edgeCallbackPath   = "/_edge/oidc/callback"
+	edgeLogoutPath     = "/_edge/logout"
+	edgeSessionCookie  = "_tunnel_edge_session"
+	edgeStateCookie    = "_tunnel_edge_state"
+	edgeStateTTL       = 10 * time.Minute
+	defaultSessionTTL  = 8 * time.Hour
+	defaultAuthHeader  = "X-Tunnel-Auth-User"
+	defaultGroupHeader = "X-Tunnel-Auth-Groups"
+)
+
+// BasicAuthConfig HTTP 基本认证配置
+type BasicAuthConfig struct {
+	Realm        string   `yaml:"realm" json:"realm"`
+	Users        []string `yaml:"users" json:"users"` // htpasswd 格式的 "user:hash"
+	HtpasswdFile string   `yaml:"htpasswdFile" json:"htpasswdFile"`
+}
+
+// edgeBasicAuth 解析后的基本认证
+type edgeBasicAuth struct {
+	realm string
+	users htpasswd
+}
+
+// edgeOIDC 解析后的 OIDC 登录配置
+type edgeOIDC struct {
+	config   *OIDCConfig
+	provider *oidcProvider
+	ttl      time.Duration
+}
+
+// edgeIdentity 通过边缘认证的访客身份
+type edgeIdentity struct {
+	User   string
+	Groups []string
+	Method string
+}
+
+// edgeSession 会话 Cookie 中保存的内容
+type edgeSession struct {
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Host    string   `json:"h"`
+	Expires int64    `json:"x"`
+}
+
+// edgeLoginState 登录过程中保存的状态
+type edgeLoginState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Return   string `json:"r"`
+	Expires  int64  `json:"x"`
+}
+
+// parseBasicAuth 解析基本认证配置
+func parseBasicAuth(cfg *BasicAuthConfig) (*edgeBasicAuth, error) {
+	users, err := parseHtpasswd(cfg.Users)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HtpasswdFile != "" {
+		fileUsers, err := loadHtpasswdFile(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		for user, hash := range fileUsers {
+			users[user] = hash
+		}
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("basicAuth 没有配置用户")
+	}
+
+	realm := cfg.Realm
+	if realm == "" {
+		realm = "Restricted"
+	}
+	return &edgeBasicAuth{realm: realm, users: users}, nil
+}
+
+// parseOIDC 解析 OIDC 配置
+func parseOIDC(cfg *OIDCConfig) (*edgeOIDC, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("oidc 需要 issuer 和 clientId")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	ttl := time.Duration(cfg.SessionTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return &edgeOIDC{config: cfg, provider: newOIDCProvider(cfg.Issuer), ttl: ttl}, nil
+}
+
+// loadSessionKey 会话签名密钥，未配置时随机生成 (重启后需要重新登录)
+func (s *TunnelServer) loadSessionKey() error {
+	if secret := s.config.Access.SessionSecret; secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		s.sessionKey = sum[:]
+		return nil
+	}
+	s.sessionKey = make([]byte, 32)
+	_, err := rand.Read(s.sessionKey)
+	return err
+}
+
+// signValue 序列化并签名 Cookie 内容
+func (s *TunnelServer) signValue(v interface{}) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, s.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyValue 校验签名并解析 Cookie 内容
+func (s *TunnelServer) verifyValue(value string, v interface{}) bool {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, s.sessionKey)
+	mac.Write([]byte(payload))
+	expected := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// randomString 生成 URL 安全的随机字符串
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// checkBasicAuth 校验基本认证，失败时返回 401
+func (p *accessPolicy) checkBasicAuth(w http.ResponseWriter, r *http.Request) (*edgeIdentity, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok || !p.basic.users.verify(user, password) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", p.basic.realm))
+		http.Error(w, "需要认证", http.StatusUnauthorized)
+		return nil, false
+	}
+	return &edgeIdentity{User: user, Method: "basic"}, true
+}
+
+// checkOIDC 校验会话，未登录时跳转到身份提供者；处理回调和退出路径
+func (s *TunnelServer) checkOIDC(w http.ResponseWriter, r *http.Request, p *accessPolicy) (*edgeIdentity, bool) {
+	switch r.URL.Path {
+	case edgeCallbackPath:
+		s.handleOIDCCallback(w, r, p)
+		return nil, false
+	case edgeLogoutPath:
+		http.SetCookie(w, s.edgeCookie(r, edgeSessionCookie, "", -1))
+		fmt.Fprintln(w, "已退出登录")
+		return nil, false
+	}
+
+	hostname := normalizeHostname(r.Host)
+	if cookie, err := r.Cookie(edgeSessionCookie); err == nil {
+		var session edgeSession
+		if s.verifyValue(cookie.Value, &session) && session.Host == hostname && time.Now().Unix() < session.Expires {
+			if p.oidc.allowed(session.User, session.Groups) {
+				return &edgeIdentity{User: session.User, Groups: session.Groups, Method: "oidc"}, true
+			}
+			s.metrics.authFailures.With(authFailureEdgeOIDC).Inc()
+			http.Error(w, "当前账号无权访问", http.StatusForbidden)
+			return nil, false
+		}
+	}
+
+	// 只有浏览器的页面请求才跳转登录，其余请求返回 401
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "需要登录", http.StatusUnauthorized)
+		return nil, false
+	}
+	state := edgeLoginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		Return:   r.URL.RequestURI(),
+		Expires:  time.Now().Add(edgeStateTTL).Unix(),
+	}
+	authURL, err := p.oidc.provider.authCodeURL(p.oidc.config, s.oidcRedirectURL(r, p), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		authLog.Error("OIDC登录失败", "error", err)
+		http.Error(w, "身份提供者不可用", http.StatusBadGateway)
+		return nil, false
+	}
+	http.SetCookie(w, s.edgeCookie(r, edgeStateCookie, s.signValue(state), int(edgeStateTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+	return nil, false
+}
+
+// handleOIDCCallback 校验授权回调，建立会话后跳回原地址
+func (s *TunnelServer) handleOIDCCallback(w http.ResponseWriter, r *http.Request, p *accessPolicy) {
+	var state edgeLoginState
+	cookie, err := r.Cookie(edgeStateCookie)
+	if err != nil || !s.verifyValue(cookie.Value, &state) || time.Now().Unix() > state.Expires {
+		http.Error(w, "登录状态无效或已过期，请重新访问", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, s.edgeCookie(r, edgeStateCookie, "", -1))
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "登录失败: "+e, http.StatusForbidden)
+		return
+	}
+	if query.Get("state") != state.State {
+		http.Error(w, "登录状态不匹配", http.StatusBadRequest)
+		return
+	}
+
+	rawToken, err := p.oidc.provider.exchange(p.oidc.config, s.oidcRedirectURL(r, p), query.Get("code"), state.Verifier)
+	if err == nil {
+		var claims *idTokenClaims
+		if claims, err = p.oidc.provider.verifyIDToken(p.oidc.config, rawToken, state.Nonce); err == nil {
+			s.startEdgeSession(w, r, p, claims, state.Return)
+			return
+		}
+	}
+	authLog.Warn("OIDC登录失败", "route", normalizeHostname(r.Host), "error", err)
+	s.metrics.authFailures.With(authFailureEdgeOIDC).Inc()
+	http.Error(w, "登录失败", http.StatusBadGateway)
+}
+
+// startEdgeSession 检查授权规则并写入会话 Cookie
+func (s *TunnelServer) startEdgeSession(w http.ResponseWriter, r *http.Request, p *accessPolicy, claims *idTokenClaims, returnTo string) {
+	user := claims.Email
+	if user == "" {
+		user = claims.Subject
+	}
+	if !p.oidc.allowed(claims.Email, claims.Groups) {
+		authLog.Info("OIDC登录被拒绝: 不满足授权规则", "identity", user, "route", normalizeHostname(r.Host))
+		s.metrics.authFailures.With(authFailureEdgeOIDC).Inc()
+		http.Error(w, "当前账号无权访问", http.StatusForbidden)
+		return
+	}
+
+	session := edgeSession{
+		User:    user,
+		Groups:  claims.Groups,
+		Host:    normalizeHostname(r.Host),
+		Expires: time.Now().Add(p.oidc.ttl).Unix(),
+	}
+	http.SetCookie(w, s.edgeCookie(r, edgeSessionCookie, s.signValue(session), int(p.oidc.ttl.Seconds())))
+	authLog.Info("OIDC登录成功", "identity", user, "route", session.Host)
+
+	// 只允许跳转到本站路径 (浏览器把 \ 当作 /，"/\" 开头同样会跳到其他站点)
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		returnTo = "/"
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// allowed 检查邮箱、域名和组规则，均未配置时允许所有登录用户
+func (o *edgeOIDC) allowed(email string, groups []string) bool {
+	cfg := o.config
+	if len(cfg.AllowedEmails) == 0 && len(cfg.AllowedDomains) == 0 && len(cfg.AllowedGroups) == 0 {
+		return true
+	}
+	email = strings.ToLower(email)
+	if email != "" {
+		for _, allowed := range cfg.AllowedEmails {
+			if strings.EqualFold(allowed, email) {
+				return true
+			}
+		}
+		if at := strings.LastIndexByte(email, '@'); at >= 0 {
+			for _, domain := range cfg.AllowedDomains {
+				if strings.EqualFold(strings.TrimPrefix(domain, "@"), email[at+1:]) {
+					return true
+				}
+			}
+		}
+	}
+	for _, group := range groups {
+		if containsString(cfg.AllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcRedirectURL 回调地址，默认使用访客访问的地址
+func (s *TunnelServer) oidcRedirectURL(r *http.Request, p *accessPolicy) string {
+	if p.oidc.config.RedirectURL != "" {
+		return p.oidc.config.RedirectURL
+	}
+	u := url.URL{Scheme: s.requestScheme(r), Host: r.Host, Path: edgeCallbackPath}
+	return u.String()
+}
+
+// edgeCookie 构造边缘认证 Cookie，maxAge < 0 表示删除
+func (s *TunnelServer) edgeCookie(r *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// applyIdentityHeaders 丢弃访客伪造的身份头和边缘凭据，写入通过认证的身份
+func applyIdentityHeaders(decision accessDecision, headers map[string]string) {
+	for _, auth := range decision.auths {
+		if auth.identity.Method == "basic" {
+			delete(headers, "Authorization") // 边缘凭据不转发给源站
+		}
+	}
+	spoofable := []string{defaultAuthHeader, defaultGroupHeader}
+	for _, p := range decision.policies {
+		spoofable = append(spoofable, p.identityHeader)
+	}
+	for k := range headers {
+		for _, name := range spoofable {
+			if strings.EqualFold(k, name) {
+				delete(headers, k)
+			}
+		}
+	}
+	if cookie, ok := headers["Cookie"]; ok {
+		if cookie = stripEdgeCookies(cookie); cookie == "" {
+			delete(headers, "Cookie")
+		} else {
+			headers["Cookie"] = cookie
+		}
+	}
+
+	// 多条策略使用同一个身份头时，以先执行的策略 (服务器策略) 为准
+	for _, auth := range decision.auths {
+		name := http.CanonicalHeaderKey(auth.policy.identityHeader)
+		if _, ok := headers[name]; !ok {
+			headers[name] = auth.identity.User
+		}
+		if _, ok := headers[defaultGroupHeader]; !ok && len(auth.identity.Groups) > 0 {
+			headers[defaultGroupHeader] = strings.Join(auth.identity.Groups, ",")
+		}
+	}
+}
+
+// stripEdgeCookies 从 Cookie 头中去掉边缘认证使用的 Cookie
+func stripEdgeCookies(header string) string {
+	parts := strings.Split(header, ";")
+	kept := parts[:0]
+	for _, part := range parts {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == edgeSessionCookie || name == edgeStateCookie {
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(part))
+	}
+	return strings.Join(kept, "; ")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tunnel/internal/testutil"
+)
+
+// newOIDCTestServer 创建对 *.test 要求 OIDC 登录的服务器，只允许 example.com 的邮箱
+func newOIDCTestServer(t *testing.T, idp *testutil.MockIdP) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	config.Access.SessionSecret = "test-session-secret"
+	config.Access.Policies = []AccessPolicyConfig{{
+		Hostnames: []string{"*.test"},
+		OIDC: &OIDCConfig{
+			Issuer:         idp.Issuer,
+			ClientID:       idp.ClientID,
+			ClientSecret:   idp.ClientSecret,
+			AllowedDomains: []string{"example.com"},
+		},
+	}}
+	s := NewTunnelServer(config)
+	if err := s.loadAccessPolicies(); err != nil {
+		t.Fatalf("加载访问策略失败: %v", err)
+	}
+	if err := s.loadSessionKey(); err != nil {
+		t.Fatalf("生成会话密钥失败: %v", err)
+	}
+	return s
+}
+
+// serveEdge 执行访问控制，通过时返回转发给源站的身份头
+func serveEdge(s *TunnelServer, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	decision, ok := s.checkAccess(rec, r, nil)
+	if ok {
+		headers := map[string]string{defaultAuthHeader: "spoofed"}
+		applyIdentityHeaders(decision, headers)
+		fmt.Fprint(rec, headers[defaultAuthHeader])
+	}
+	return rec
+}
+
+func responseCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// oidcLogin 访问 target，跟随跳转完成登录，返回回调的响应
+func oidcLogin(t *testing.T, s *TunnelServer, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := serveEdge(s, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("未登录时应跳转到身份提供者，得到 %d: %s", rec.Code, rec.Body)
+	}
+	state := responseCookie(rec, edgeStateCookie)
+	if state == nil {
+		t.Fatal("跳转登录时应设置状态 Cookie")
+	}
+
+	// 身份提供者直接登录并带授权码跳回
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("请求授权端点失败: %v", err)
+	}
+	resp.Body.Close()
+	callback := resp.Header.Get("Location")
+	if !strings.Contains(callback, edgeCallbackPath) {
+		t.Fatalf("身份提供者应跳回回调地址，得到 %q", callback)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	r.AddCookie(state)
+	return serveEdge(s, r)
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	s := newOIDCTestServer(t, idp)
+
+	rec := oidcLogin(t, s, "http://app.test/private?x=1")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/private?x=1" {
+		t.Fatalf("登录后应跳回原地址，得到 %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	session := responseCookie(rec, edgeSessionCookie)
+	if session == nil || !session.HttpOnly {
+		t.Fatalf("应设置 HttpOnly 会话 Cookie，得到 %+v", session)
+	}
+	if c := responseCookie(rec, edgeStateCookie); c == nil || c.MaxAge >= 0 {
+		t.Error("登录完成后应删除状态 Cookie")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.test/private", nil)
+	r.AddCookie(session)
+	rec = serveEdge(s, r)
+	if rec.Code != http.StatusOK || rec.Body.String() != "alice@example.com" {
+		t.Fatalf("已登录的请求应转发并替换访客伪造的身份头，得到 %d %q", rec.Code, rec.Body)
+	}
+
+	// 非 GET 请求不跳转登录
+	rec = serveEdge(s, httptest.NewRequest(http.MethodPost, "http://app.test/api", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("未登录的 POST 应返回 401，得到 %d", rec.Code)
+	}
+
+	// 退出后会话 Cookie 被删除
+	r = httptest.NewRequest(http.MethodGet, "http://app.test"+edgeLogoutPath, nil)
+	r.AddCookie(session)
+	if c := responseCookie(serveEdge(s, r), edgeSessionCookie); c == nil || c.MaxAge >= 0 {
+		t.Error("退出登录应删除会话 Cookie")
+	}
+}
+
+func TestOIDCCodeFlowRejectsUnauthorizedUser(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	idp.Email = "mallory@evil.example"
+	s := newOIDCTestServer(t, idp)
+
+	rec := oidcLogin(t, s, "http://app.test/")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("不满足 allowedDomains 的用户应被拒绝，得到 %d", rec.Code)
+	}
+	if responseCookie(rec, edgeSessionCookie) != nil {
+		t.Error("被拒绝的用户不应得到会话")
+	}
+}
+
+func TestOIDCCodeFlowRejectsInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(claims map[string]interface{})
+	}{
+		{"nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }},
+		{"iss", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }},
+		{"aud", func(c map[string]interface{}) { c["aud"] = "other-client" }},
+		{"exp", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := testutil.NewMockIdP(t)
+			idp.Claims = tt.modify
+			s := newOIDCTestServer(t, idp)
+
+			rec := oidcLogin(t, s, "http://app.test/")
+			if rec.Code != http.StatusBadGateway {
+				t.Fatalf("ID Token 的 %s 无效时登录应失败，得到 %d", tt.name, rec.Code)
+			}
+			if responseCookie(rec, edgeSessionCookie) != nil {
+				t.Error("登录失败时不应设置会话 Cookie")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresState(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	s := newOIDCTestServer(t, idp)
+
+	// 没有状态 Cookie (如攻击者构造的回调链接)
+	rec := serveEdge(s, httptest.NewRequest(http.MethodGet, "http://app.test"+edgeCallbackPath+"?code=x&state=y", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("没有状态 Cookie 的回调应返回 400，得到 %d", rec.Code)
+	}
+
+	// 状态参数与 Cookie 不一致
+	state := s.signValue(edgeLoginState{State: "expected", Expires: time.Now().Add(time.Minute).Unix()})
+	r := httptest.NewRequest(http.MethodGet, "http://app.test"+edgeCallbackPath+"?code=x&state=other", nil)
+	r.AddCookie(&http.Cookie{Name: edgeStateCookie, Value: state})
+	if rec := serveEdge(s, r); rec.Code != http.StatusBadRequest {
+		t.Errorf("state 不匹配的回调应返回 400，得到 %d", rec.Code)
+	}
+
+	// 过期的状态
+	state = s.signValue(edgeLoginState{State: "expected", Expires: time.Now().Add(-time.Minute).Unix()})
+	r = httptest.NewRequest(http.MethodGet, "http://app.test"+edgeCallbackPath+"?code=x&state=expected", nil)
+	r.AddCookie(&http.Cookie{Name: edgeStateCookie, Value: state})
+	if rec := serveEdge(s, r); rec.Code != http.StatusBadRequest {
+		t.Errorf("过期的登录状态应返回 400，得到 %d", rec.Code)
+	}
+}
+
+func TestEdgeSessionReturnOnlyToLocalPaths(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	s := newOIDCTestServer(t, idp)
+	policy := s.accessPolicies[0]
+	claims := &idTokenClaims{Subject: "alice", Email: "alice@example.com"}
+
+	tests := map[string]string{
+		"/dashboard?tab=1":     "/dashboard?tab=1",
+		"/":                    "/",
+		"":                     "/",
+		"//evil.example/path":  "/",
+		"/\\evil.example/path": "/",
+		"https://evil.example": "/",
+		"evil.example":         "/",
+		"javascript:alert(1)":  "/",
+	}
+	for returnTo, want := range tests {
+		rec := httptest.NewRecorder()
+		s.startEdgeSession(rec, httptest.NewRequest(http.MethodGet, "http://app.test"+edgeCallbackPath, nil), policy, claims, returnTo)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("returnTo %q: 期望 302，得到 %d", returnTo, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != want {
+			t.Errorf("returnTo %q: 跳转到 %q，期望 %q", returnTo, got, want)
+		}
+	}
+}
+
+func TestEdgeSessionCookieBoundToHost(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	s := newOIDCTestServer(t, idp)
+	valid := s.signValue(edgeSession{User: "alice@example.com", Host: "app.test", Expires: time.Now().Add(time.Hour).Unix()})
+
+	other := newOIDCTestServer(t, idp)
+	other.sessionKey = []byte("another-server-key-another-server")
+
+	tests := []struct {
+		name   string
+		host   string
+		cookie string
+		want   int
+	}{
+		{"同一主机名", "app.test", valid, http.StatusOK},
+		{"其他主机名", "other.test", valid, http.StatusFound},
+		{"篡改内容", "app.test", strings.Replace(valid, valid[:4], "AAAA", 1), http.StatusFound},
+		{"其他密钥签名", "app.test", other.signValue(edgeSession{User: "alice@example.com", Host: "app.test",
+			Expires: time.Now().Add(time.Hour).Unix()}), http.StatusFound},
+		{"已过期", "app.test", s.signValue(edgeSession{User: "alice@example.com", Host: "app.test",
+			Expires: time.Now().Add(-time.Second).Unix()}), http.StatusFound},
+		{"未授权的用户", "app.test", s.signValue(edgeSession{User: "mallory@evil.example", Host: "app.test",
+			Expires: time.Now().Add(time.Hour).Unix()}), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/", nil)
+			r.AddCookie(&http.Cookie{Name: edgeSessionCookie, Value: tt.cookie})
+			if rec := serveEdge(s, r); rec.Code != tt.want {
+				t.Errorf("期望 %d，得到 %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestApplyIdentityHeadersStripsEdgeCookies(t *testing.T) {
+	headers := map[string]string{
+		"Cookie":             edgeSessionCookie + "=abc; app=1; " + edgeStateCookie + "=def",
+		"X-Tunnel-Auth-User": "spoofed",
+	}
+	applyIdentityHeaders(accessDecision{}, headers)
+	if headers["Cookie"] != "app=1" {
+		t.Errorf("应去掉边缘认证 Cookie，得到 %q", headers["Cookie"])
+	}
+	if _, ok := headers["X-Tunnel-Auth-User"]; ok {
+		t.Error("未认证时应丢弃访客伪造的身份头")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// 转发给源站的代理头
+var forwardedHeaderNames = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Host",
+	"X-Real-Ip",
+}
+
+// parsePrefixes 解析地址列表，支持 CIDR 和单个 IP
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("无效的地址 %q: %v", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(normalizeBindHost(entry))
+		if err != nil {
+			return nil, fmt.Errorf("无效的地址 %q: %v", entry, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// containsAddr 判断地址是否属于列表中的任一网段
+func containsAddr(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedProxy 判断地址是否属于可信代理
+func (s *TunnelServer) isTrustedProxy(ip string) bool {
+	return containsAddr(s.trustedProxies, ip)
+}
+
+// remoteIP 返回连接的对端地址 (不含端口)
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
+
+// clientIP 返回访客的真实地址：对端为可信代理时，
+// 从 X-Forwarded-For 右侧起跳过可信代理，取第一个不可信的地址
+func (s *TunnelServer) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !s.isTrustedProxy(ip) {
+		return ip
+	}
+
+	chain := splitHeaderList(r.Header.Values("X-Forwarded-For"))
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := normalizeBindHost(chain[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break // 无法解析的地址，不再信任更左侧的值
+		}
+		ip = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// applyForwardedHeaders 设置转发给源站的代理头；
+// 来自不可信对端的同名请求头会被丢弃，可信代理传来的值会被保留并追加
+func (s *TunnelServer) applyForwardedHeaders(r *http.Request, headers map[string]string) {
+	peer := remoteIP(r)
+	trusted := s.isTrustedProxy(peer)
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	proto, host := s.requestScheme(r), r.Host
+
+	var priorFor, priorForwarded []string
+	if trusted {
+		priorFor = splitHeaderList(r.Header.Values("X-Forwarded-For"))
+		priorForwarded = r.Header.Values("Forwarded")
+		if v := firstHeaderValue(r.Header.Get("X-Forwarded-Host")); v != "" {
+			host = v
+		}
+	}
+
+	for _, name := range forwardedHeaderNames {
+		delete(headers, name)
+	}
+
+	headers["X-Forwarded-For"] = strings.Join(append(priorFor, peer), ", ")
+	headers["X-Forwarded-Proto"] = proto
+	headers["X-Forwarded-Host"] = host
+	headers["X-Real-Ip"] = s.clientIP(r)
+
+	element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(peer), quoteForwarded(r.Host), scheme)
+	headers["Forwarded"] = strings.Join(append(priorForwarded, element), ", ")
+}
+
+// requestScheme 返回访客使用的协议，经可信代理时以 X-Forwarded-Proto 为准
+func (s *TunnelServer) requestScheme(r *http.Request) string {
+	if s.isTrustedProxy(remoteIP(r)) {
+		if v := firstHeaderValue(r.Header.Get("X-Forwarded-Proto")); v != "" {
+			return v
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedNode 按 RFC 7239 格式化节点地址，IPv6 需要加方括号和引号
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteForwarded 对含有特殊字符的值加引号 (RFC 7239 quoted-string)
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]\",;= ") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+// splitHeaderList 拆分逗号分隔的多值请求头
+func splitHeaderList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+	}
+	return result
+}
+
+// firstHeaderValue 返回逗号分隔列表中的第一项
+func firstHeaderValue(value string) string {
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplyForwardedHeaders(t *testing.T) {
+	s := NewTunnelServer(DefaultConfig())
+	var err error
+	if s.trustedProxies, err = parsePrefixes([]string{"10.0.0.0/8", "192.0.2.1"}); err != nil {
+		t.Fatalf("解析可信代理失败: %v", err)
+	}
+
+	spoofed := http.Header{
+		"X-Forwarded-For":   {"6.6.6.6"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"admin.internal"},
+		"X-Real-Ip":         {"6.6.6.6"},
+		"Forwarded":         {"for=6.6.6.6"},
+	}
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   map[string]string
+	}{
+		{
+			name:   "不可信对端伪造的代理头被丢弃",
+			remote: "203.0.113.5:1234",
+			header: spoofed,
+			want: map[string]string{
+				"X-Forwarded-For":   "203.0.113.5",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "app.example.com",
+				"X-Real-Ip":         "203.0.113.5",
+				"Forwarded":         "for=203.0.113.5;host=app.example.com;proto=http",
+			},
+		},
+		{
+			name:   "可信代理的代理头被保留并追加",
+			remote: "10.1.2.3:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"198.51.100.7, 192.0.2.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"www.example.com"},
+				"Forwarded":         {"for=198.51.100.7"},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.7, 192.0.2.1, 10.1.2.3",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "www.example.com",
+				"X-Real-Ip":         "198.51.100.7",
+				"Forwarded":         "for=198.51.100.7, for=10.1.2.3;host=app.example.com;proto=http",
+			},
+		},
+		{
+			name:   "可信代理链中伪造的最左侧地址不被采用",
+			remote: "10.1.2.3:1234",
+			header: http.Header{"X-Forwarded-For": {"6.6.6.6, 198.51.100.7"}},
+			want: map[string]string{
+				"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.1.2.3",
+				"X-Real-Ip":       "198.51.100.7",
+			},
+		},
+		{
+			name:   "IPv6 对端",
+			remote: "[2001:db8::1]:1234",
+			header: spoofed,
+			want: map[string]string{
+				"X-Forwarded-For": "2001:db8::1",
+				"X-Real-Ip":       "2001:db8::1",
+				"Forwarded":       `for="[2001:db8::1]";host=app.example.com;proto=http`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header = tt.header.Clone()
+			// 客户端收到的请求头中原有的同名头
+			headers := map[string]string{"X-Forwarded-For": "6.6.6.6", "X-Real-Ip": "6.6.6.6", "Accept": "*/*"}
+			s.applyForwardedHeaders(r, headers)
+			for name, want := range tt.want {
+				if got := headers[name]; got != want {
+					t.Errorf("%s = %q，期望 %q", name, got, want)
+				}
+			}
+			if headers["Accept"] != "*/*" {
+				t.Error("其他请求头不应被修改")
+			}
+		})
+	}
+}
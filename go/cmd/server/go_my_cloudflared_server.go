@@ -1,20 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
 	"gopkg.in/yaml.v3"
+
+	"tunnel/internal/logging"
+	"tunnel/internal/tracing"
 )
 
 // Config 服务器配置
@@ -22,7 +34,24 @@ type Config struct {
 	Server struct {
 		HTTPPort      int    `yaml:"httpPort" json:"httpPort"`
 		WSPort        int    `yaml:"wsPort" json:"wsPort"`
-		Host          string `yaml:"host" json:"host"`
+		EnableHTTP    bool   `yaml:"enableHttp" json:"enableHttp"`
+		EnableWS      bool   `yaml:"enableWs" json:"enableWs"`
+		// 单端口模式：公网端口上按路径或主机名 (加 Upgrade 头) 识别隧道连接
+		TunnelPath     string `yaml:"tunnelPath" json:"tunnelPath"`
+		TunnelHostname string `yaml:"tunnelHostname" json:"tunnelHostname"`
+		Host          string `yaml:"host" json:"host"` // IPv6 地址可写作 "[::]"
+		Hosts         []string `yaml:"hosts" json:"hosts"` // 多个监听地址，设置后代替 host
+		// 监听器网络类型 dual/tcp4/tcp6，各监听器可单独覆盖
+		Network       string `yaml:"network" json:"network"`
+		HTTPNetwork   string `yaml:"httpNetwork" json:"httpNetwork"`
+		WSNetwork     string `yaml:"wsNetwork" json:"wsNetwork"`
+		HTTPSNetwork  string `yaml:"httpsNetwork" json:"httpsNetwork"`
+		WSSNetwork    string `yaml:"wssNetwork" json:"wssNetwork"`
+		// 可信代理 (CIDR 或 IP)，其传来的 X-Forwarded-* / Forwarded 头会被保留
+		TrustedProxies []string `yaml:"trustedProxies" json:"trustedProxies"`
+		// 接受 PROXY protocol v1/v2 头部的监听器 (http/https/ws/wss)；
+		// 配置了 trustedProxies 时只要求来自可信代理的连接携带头部
+		ProxyProtocol []string `yaml:"proxyProtocol" json:"proxyProtocol"`
 		PublicDomain  string `yaml:"publicDomain" json:"publicDomain"`
 		RequestTimeout int   `yaml:"requestTimeout" json:"requestTimeout"`
 		MaxClients    int    `yaml:"maxClients" json:"maxClients"`
@@ -33,11 +62,70 @@ type Config struct {
 		KeyFile       string `yaml:"keyFile" json:"keyFile"`
 		EnableWSS     bool   `yaml:"enableWss" json:"enableWss"`
 		WSSPort       int    `yaml:"wssPort" json:"wssPort"`
+		// 额外的证书，按 SNI 选择 (如客户域名与 *.windy.run 共用端口)
+		Certificates       []CertificateConfig `yaml:"certificates" json:"certificates"`
+		CertReloadInterval int                 `yaml:"certReloadInterval" json:"certReloadInterval"` // 证书文件检查间隔(秒)
 	} `yaml:"server" json:"server"`
 	Auth struct {
-		RequireAuth bool     `yaml:"requireAuth" json:"requireAuth"`
-		Tokens      []string `yaml:"tokens" json:"tokens"`
+		RequireAuth bool          `yaml:"requireAuth" json:"requireAuth"`
+		Tokens      []TokenConfig `yaml:"tokens" json:"tokens"`
+		// 客户端证书认证 (仅WSS监听器)
+		MTLS struct {
+			Mode    string `yaml:"mode" json:"mode"` // optional 或 require，留空表示关闭
+			CAFile  string `yaml:"caFile" json:"caFile"`
+			CRLFile string `yaml:"crlFile" json:"crlFile"`
+		} `yaml:"mtls" json:"mtls"`
 	} `yaml:"auth" json:"auth"`
+	// 访客访问策略 (按主机名，先匹配的策略生效)
+	Access struct {
+		DenyMessage   string               `yaml:"denyMessage" json:"denyMessage"`     // 默认的 403 响应内容
+		SessionSecret string               `yaml:"sessionSecret" json:"sessionSecret"` // 边缘认证会话签名密钥，未配置时每次启动随机生成
+		Policies      []AccessPolicyConfig `yaml:"policies" json:"policies"`
+		// 客户端在握手时声明的访问策略 (--basic-auth、--allow-cidr、--oauth-allowed-emails)
+		ClientPolicies ClientPolicyConfig `yaml:"clientPolicies" json:"clientPolicies"`
+	} `yaml:"access" json:"access"`
+	// 访客请求限流，所有匹配的规则都需要通过
+	RateLimits []RateLimitConfig `yaml:"rateLimits" json:"rateLimits"`
+	// 日志级别和格式
+	Log logging.Config `yaml:"log" json:"log"`
+	// Prometheus 指标
+	Metrics struct {
+		Enabled bool   `yaml:"enabled" json:"enabled"` // 默认 true
+		Listen  string `yaml:"listen" json:"listen"`   // 单独的监听地址，如 127.0.0.1:9100；为空时由管理接口提供
+		Path    string `yaml:"path" json:"path"`       // 默认 /metrics
+		Public  bool   `yaml:"public" json:"public"`   // 同时在公网端口提供 (不需要认证，且会占用所有隧道应用的该路径)
+	} `yaml:"metrics" json:"metrics"`
+	// 按令牌或主机名限制带宽
+	BandwidthLimits []BandwidthLimitConfig `yaml:"bandwidthLimits" json:"bandwidthLimits"`
+	// 公网请求的访问日志
+	AccessLog AccessLogConfig `yaml:"accessLog" json:"accessLog"`
+	// 链路追踪，以 OTLP/HTTP 导出
+	Tracing tracing.Config `yaml:"tracing" json:"tracing"`
+	// 管理接口 (客户端、请求、令牌和主机名保留)
+	Admin AdminConfig `yaml:"admin" json:"admin"`
+	// 用量统计和每月流量配额
+	Usage struct {
+		File          string        `yaml:"file" json:"file"`                   // 每日用量文件，为空表示不统计
+		FlushInterval int           `yaml:"flushInterval" json:"flushInterval"` // 保存间隔(秒)，默认 60
+		Quotas        []QuotaConfig `yaml:"quotas" json:"quotas"`
+	} `yaml:"usage" json:"usage"`
+	// ACME 自动证书配置
+	ACME struct {
+		Enabled      bool     `yaml:"enabled" json:"enabled"`
+		Email        string   `yaml:"email" json:"email"`
+		DirectoryURL string   `yaml:"directoryUrl" json:"directoryUrl"`
+		CARootFile   string   `yaml:"caRootFile" json:"caRootFile"` // 信任 ACME 服务器的自定义CA (如 Pebble)
+		CacheDir     string   `yaml:"cacheDir" json:"cacheDir"`
+		Domains      []string `yaml:"domains" json:"domains"`     // 默认为 publicDomain
+		Wildcard     bool     `yaml:"wildcard" json:"wildcard"`   // 申请 *.publicDomain (需要 DNS-01)
+		Challenge    string   `yaml:"challenge" json:"challenge"` // http-01、tls-alpn-01 或 dns-01
+		RenewBefore  int      `yaml:"renewBefore" json:"renewBefore"` // 到期前多少天续期
+		DNS          struct {
+			Provider           string            `yaml:"provider" json:"provider"`
+			PropagationSeconds int               `yaml:"propagationSeconds" json:"propagationSeconds"`
+			Options            map[string]string `yaml:"options" json:"options"`
+		} `yaml:"dns" json:"dns"`
+	} `yaml:"acme" json:"acme"`
 }
 
 // DefaultConfig 默认配置
@@ -45,7 +133,10 @@ func DefaultConfig() *Config {
 	config := &Config{}
 	config.Server.HTTPPort = 6000
 	config.Server.WSPort = 6001
+	config.Server.EnableHTTP = true
+	config.Server.EnableWS = true
 	config.Server.Host = "0.0.0.0"
+	config.Server.Network = NetworkDual
 	config.Server.PublicDomain = ""
 	config.Server.RequestTimeout = 30000
 	config.Server.MaxClients = 100
@@ -56,8 +147,19 @@ func DefaultConfig() *Config {
 	config.Server.KeyFile = "server.key"
 	config.Server.EnableWSS = false
 	config.Server.WSSPort = 6444
+	config.Server.CertReloadInterval = 60
 	config.Auth.RequireAuth = true
-	config.Auth.Tokens = []string{"default-token"}
+	config.Metrics.Enabled = true
+	// 默认允许客户端声明访问策略，与服务器策略同时执行 (override 为 false)
+	config.Access.ClientPolicies.Enabled = true
+	config.Access.ClientPolicies.BasicAuth = true
+	config.Access.ClientPolicies.AllowCIDR = true
+	config.Access.ClientPolicies.OAuth = true
+	// ACME 默认配置
+	config.ACME.DirectoryURL = acme.LetsEncryptURL
+	config.ACME.CacheDir = "acme-cache"
+	config.ACME.Challenge = ChallengeHTTP01
+	config.ACME.RenewBefore = 30
 	return config
 }
 
@@ -91,7 +193,66 @@ type Client struct {
 	Conn     *websocket.Conn
 	Host     string
 	Port     int
+	Route    TunnelRoute
+	Token    *authToken
+	Identity string
 	LastPing time.Time
+	RemoteAddr  string
+	ConnectedAt time.Time
+	// 排空中：不再分配新请求 (由管理接口设置)
+	Draining bool
+	// 客户端声明的访问策略
+	Policy        *accessPolicy
+	PolicySummary string
+	// 上次累计连接时长的时间
+	usageMark time.Time
+	// 上行 (访客到源站) 和下行吞吐量
+	inMeter  *throughputMeter
+	outMeter *throughputMeter
+	// 每秒请求数和错误 (5xx) 数，供控制台显示
+	requestMeter *throughputMeter
+	errorMeter   *throughputMeter
+	// 写队列，由 writeLoop 串行写入 WebSocket
+	sendQueue chan interface{}
+	done      chan struct{}
+}
+
+// 每个客户端写队列的长度
+const clientSendQueueSize = 256
+
+// send 将消息放入写队列，客户端断开时返回错误
+func (c *Client) send(msg interface{}) error {
+	select {
+	case c.sendQueue <- msg:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("客户端已断开")
+	}
+}
+
+// closeConn 放入写队列后，writeLoop 写完之前的消息再关闭连接
+type closeConn struct{}
+
+// writeLoop 串行写入队列中的消息 (WebSocket 连接不支持并发写)
+func (c *Client) writeLoop() {
+	for {
+		select {
+		case msg := <-c.sendQueue:
+			if _, ok := msg.(closeConn); ok {
+				c.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				c.Conn.Close()
+				return
+			}
+			if err := c.Conn.WriteJSON(msg); err != nil {
+				tunnelLog.Warn("写入客户端失败", "client_id", c.ID, "error", err)
+				c.Conn.Close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
 }
 
 // TunnelServer 隧道服务器
@@ -104,8 +265,31 @@ type TunnelServer struct {
 	httpsServer    *http.Server
 	wsServer       *http.Server
 	wssServer      *http.Server
-	pendingRequests map[string]chan HTTPResponse
+	pendingRequests map[string]*pendingRequest
 	requestMux     sync.RWMutex
+	tokens         []*authToken
+	tokensMux      sync.RWMutex
+	tokenConns     map[*authToken]int
+	acme           *acmeManager
+	certs          *certStore
+	trustedProxies []netip.Prefix
+	accessPolicies []*accessPolicy
+	sessionKey     []byte
+	clientOIDC     *edgeOIDC
+	rateLimiters   []*rateLimiter
+	usage          *usageStore
+	quotas         []*quota
+	bandwidthLimits []*bandwidthLimit
+	metrics         *serverMetrics
+	startedAt       time.Time
+	accessLog       *accessLogger
+	tracer          *tracing.Tracer
+	adminTokens     []*authToken
+	admin           *adminStore
+	dashboard       *dashboard
+	captures        *captureHub
+	authFailureLimiter *rateLimiter // 每个地址的认证失败次数
+	clientSeq       atomic.Uint64 // 客户端ID序号，同一秒内连接的客户端也不会重复
 }
 
 // HTTPResponse HTTP响应结构
@@ -114,152 +298,458 @@ type HTTPResponse struct {
 	Headers    map[string]string `json:"headers"`
 	Body       string            `json:"body"`
 	Error      string            `json:"error"`
+	// 客户端请求本地服务的耗时，用于区分隧道和源站的耗时
+	OriginTime time.Duration `json:"-"`
+	// 请求被管理接口取消
+	Canceled bool `json:"-"`
+}
+
+// pendingRequest 等待客户端响应的请求
+type pendingRequest struct {
+	ID        string
+	ClientID  string
+	Method    string
+	Path      string
+	Hostname  string
+	Remote    string
+	StartedAt time.Time
+	response  chan HTTPResponse
 }
 
 // NewTunnelServer 创建隧道服务器
 func NewTunnelServer(config *Config) *TunnelServer {
-	return &TunnelServer{
+	s := &TunnelServer{
 		config:          config,
 		clients:         make(map[string]*Client),
-		pendingRequests: make(map[string]chan HTTPResponse),
+		pendingRequests: make(map[string]*pendingRequest),
+		tokenConns:      make(map[*authToken]int),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // 允许跨域
 			},
 		},
+		startedAt: time.Now(),
+		admin:     newAdminStore(),
+		dashboard: newDashboard(),
+		captures:  newCaptureHub(),
 	}
+	s.metrics = newServerMetrics(s)
+	s.authFailureLimiter, _ = newRateLimiter(RateLimitConfig{Name: "auth-failures", Rate: authFailureRate, Burst: authFailureBurst})
+	return s
 }
 
 // Start 启动服务器
 func (s *TunnelServer) Start() error {
+	// 加载认证令牌
+	if err := s.loadTokens(); err != nil {
+		return err
+	}
+	if err := s.loadAdmin(); err != nil {
+		return err
+	}
+	// optional 模式下客户端可以只使用证书，其余情况没有令牌时所有客户端都无法连接
+	if s.config.Auth.RequireAuth && len(s.tokens) == 0 && s.config.Auth.MTLS.Mode != MTLSModeOptional {
+		return fmt.Errorf("已启用认证但没有配置令牌 (auth.tokens)，请使用 'tunnel-server token add' 生成")
+	}
+	
+	// 解析可信代理列表
+	trustedProxies, err := parsePrefixes(s.config.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trustedProxies 配置错误: %v", err)
+	}
+	s.trustedProxies = trustedProxies
+	if err := s.validateProxyProtocol(); err != nil {
+		return err
+	}
+	
+	// 加载访客访问策略
+	if err := s.loadAccessPolicies(); err != nil {
+		return err
+	}
+	if err := s.loadClientPolicyOIDC(); err != nil {
+		return err
+	}
+	if err := s.loadSessionKey(); err != nil {
+		return err
+	}
+	if err := s.loadRateLimits(); err != nil {
+		return err
+	}
+	if err := s.loadUsage(); err != nil {
+		return err
+	}
+	if err := s.loadBandwidthLimits(); err != nil {
+		return err
+	}
+	if err := s.loadAccessLog(); err != nil {
+		return err
+	}
+	if err := s.loadTracing(); err != nil {
+		return err
+	}
+	
+	// 启动ACME证书管理
+	if s.config.ACME.Enabled {
+		manager, err := newACMEManager(s.config)
+		if err != nil {
+			return fmt.Errorf("ACME配置错误: %v", err)
+		}
+		s.acme = manager
+		go s.acme.run()
+	}
+	
+	// 加载证书文件，文件变化时自动重新加载
+	if s.config.Server.EnableHTTPS || s.config.Server.EnableWSS {
+		if configs := s.certificateConfigs(); len(configs) > 0 {
+			certs, err := newCertStore(configs)
+			if err != nil {
+				return err
+			}
+			s.certs = certs
+			interval := time.Duration(s.config.Server.CertReloadInterval) * time.Second
+			if interval <= 0 {
+				interval = time.Minute
+			}
+			go s.certs.watch(interval)
+		} else if s.acme == nil {
+			return fmt.Errorf("启用HTTPS/WSS需要配置证书文件或ACME")
+		}
+	}
+	
+	// 至少需要一个隧道入口
+	if !s.config.Server.EnableWS && !s.config.Server.EnableWSS && !s.singlePortEnabled() {
+		return fmt.Errorf("没有可用的隧道入口: 请启用 enableWs/enableWss 或配置 tunnelPath/tunnelHostname")
+	}
+	if !s.config.Server.EnableHTTP && !s.config.Server.EnableHTTPS {
+		return fmt.Errorf("没有可用的公网入口: 请启用 enableHttp 或 enableHttps")
+	}
+	
+	// 启动各监听器，任一监听器退出时返回错误
+	errCh := make(chan error, 6)
+	
 	// 启动WebSocket服务器
-	go s.startWebSocketServer()
+	if s.config.Server.EnableWS {
+		go func() { errCh <- s.startWebSocketServer() }()
+	}
 	
 	// 启动WebSocket Secure服务器 (WSS)
 	if s.config.Server.EnableWSS {
-		go s.startWebSocketSecureServer()
+		go func() { errCh <- s.startWebSocketSecureServer() }()
 	}
 	
 	// 启动HTTPS服务器
 	if s.config.Server.EnableHTTPS {
-		go s.startHTTPSServer()
+		go func() { errCh <- s.startHTTPSServer() }()
+	}
+	
+	// 启动HTTP服务器
+	if s.config.Server.EnableHTTP {
+		go func() { errCh <- s.startHTTPServer() }()
+	}
+	
+	// 在单独的管理地址上提供指标 (与管理接口地址相同时由管理接口提供)
+	if s.config.Metrics.Enabled && s.config.Metrics.Listen != "" && s.config.Metrics.Listen != s.config.Admin.Listen {
+		go func() { errCh <- s.startMetricsServer() }()
+	}
+	if s.config.Metrics.Enabled && s.metricsListen() == "" && !s.config.Metrics.Public {
+		serverLog.Warn("未配置 metrics.listen 或 admin.listen，不提供 Prometheus 指标")
+	}
+	
+	// 启动管理接口
+	if s.config.Admin.Listen != "" {
+		go func() { errCh <- s.startAdminServer() }()
 	}
 	
-	// 启动HTTP服务器 (最后启动，阻塞)
-	return s.startHTTPServer()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		s.shutdown()
+		return err
+	case sig := <-sigChan:
+		serverLog.Info("收到信号，正在停止...", "signal", sig.String())
+		s.shutdown()
+		return nil
+	}
+}
+
+// shutdown 退出前保存用量并导出剩余的跨度
+func (s *TunnelServer) shutdown() {
+	s.saveUsage(time.Now())
+	s.tracer.Shutdown()
+}
+
+// singlePortEnabled 是否在公网端口上同时提供隧道入口
+func (s *TunnelServer) singlePortEnabled() bool {
+	return s.config.Server.TunnelPath != "" || s.config.Server.TunnelHostname != ""
+}
+
+// isTunnelRequest 判断公网端口上的请求是否为隧道连接 (专用路径或主机名，且带 Upgrade 头)
+func (s *TunnelServer) isTunnelRequest(r *http.Request) bool {
+	if !s.singlePortEnabled() || !websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+	if s.config.Server.TunnelPath != "" && r.URL.Path == s.config.Server.TunnelPath {
+		return true
+	}
+	return s.config.Server.TunnelHostname != "" && normalizeHostname(r.Host) == normalizeHostname(s.config.Server.TunnelHostname)
+}
+
+// publicHandler 公网端口的处理器：隧道连接和请求转发 (健康检查和客户端列表在管理接口上)
+func (s *TunnelServer) publicHandler() http.Handler {
+	mux := http.NewServeMux()
+	if s.config.Metrics.Enabled && s.config.Metrics.Public {
+		mux.Handle(s.metricsPath(), s.metrics.registry)
+	}
+	mux.HandleFunc("/", s.handleHTTPRequest)
+	
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isTunnelRequest(r) {
+			s.handleWebSocket(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
 }
 
 // startWebSocketServer 启动WebSocket服务器
-func (s *TunnelServer) startWebSocketServer() {
+func (s *TunnelServer) startWebSocketServer() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleWebSocket)
 	
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.WSPort)
-	
-	// 创建TCP4监听器，强制使用IPv4
-	listener, err := net.Listen("tcp4", addr)
+	listeners, err := s.listen("ws", s.config.Server.WSNetwork, s.config.Server.WSPort)
 	if err != nil {
-		log.Printf("WebSocket监听失败: %v", err)
-		return
+		return fmt.Errorf("WebSocket监听失败: %v", err)
 	}
 	
 	s.wsServer = &http.Server{
 		Handler: mux,
 	}
 	
-	log.Printf("WebSocket服务器启动在端口 %d (IPv4: %s)", s.config.Server.WSPort, addr)
-	if err := s.wsServer.Serve(listener); err != nil && err != http.ErrServerClosed {
-		log.Printf("WebSocket服务器错误: %v", err)
-	}
+	serverLog.Info("WebSocket服务器启动", "port", s.config.Server.WSPort)
+	return serve("WebSocket服务器", s.wsServer, listeners, false)
 }
 
 // startHTTPServer 启动HTTP服务器
 func (s *TunnelServer) startHTTPServer() error {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/clients", s.handleClients)
-	mux.HandleFunc("/", s.handleHTTPRequest)
-	
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.HTTPPort)
-	
-	// 创建TCP4监听器，强制使用IPv4
-	listener, err := net.Listen("tcp4", addr)
+	listeners, err := s.listen("http", s.config.Server.HTTPNetwork, s.config.Server.HTTPPort)
 	if err != nil {
 		return fmt.Errorf("监听失败: %v", err)
 	}
 	
+	// ACME HTTP-01 验证
+	handler := s.publicHandler()
+	if s.acme != nil {
+		handler = s.acme.HTTPHandler(handler)
+	}
+	
 	s.httpServer = &http.Server{
-		Handler: mux,
+		Handler:     handler,
+		ConnContext: withConn,
 	}
 	
-	log.Printf("HTTP服务器启动在端口 %d (IPv4: %s)", s.config.Server.HTTPPort, addr)
-	log.Printf("管理接口: http://localhost:%d/health", s.config.Server.HTTPPort)
-	log.Printf("客户端列表: http://localhost:%d/clients", s.config.Server.HTTPPort)
+	serverLog.Info("HTTP服务器启动", "port", s.config.Server.HTTPPort)
+	if s.singlePortEnabled() {
+		serverLog.Info("隧道入口", "url", fmt.Sprintf("ws://%s%s", s.tunnelEndpointHost(s.config.Server.HTTPPort), s.config.Server.TunnelPath))
+	}
 	
-	return s.httpServer.Serve(listener)
+	return serve("HTTP服务器", s.httpServer, listeners, false)
 }
 
 // startHTTPSServer 启动HTTPS服务器
-func (s *TunnelServer) startHTTPSServer() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/clients", s.handleClients)
-	mux.HandleFunc("/", s.handleHTTPRequest)
+func (s *TunnelServer) startHTTPSServer() error {
+	// 单端口模式下隧道客户端也可能出示证书
+	var base *tls.Config
+	if s.singlePortEnabled() {
+		var err error
+		if base, err = s.clientTLSConfig(true); err != nil {
+			return fmt.Errorf("HTTPS客户端证书配置错误: %v", err)
+		}
+	}
 	
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.HTTPSPort)
+	listeners, err := s.listen("https", s.config.Server.HTTPSNetwork, s.config.Server.HTTPSPort)
+	if err != nil {
+		return fmt.Errorf("HTTPS监听失败: %v", err)
+	}
 	
 	s.httpsServer = &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Handler:     s.publicHandler(),
+		ConnContext: withConn,
+		TLSConfig: s.serverTLSConfig(base, "h2", "http/1.1"),
 	}
 	
-	log.Printf("HTTPS服务器启动在端口 %d (IPv4: %s)", s.config.Server.HTTPSPort, addr)
-	log.Printf("HTTPS管理接口: https://localhost:%d/health", s.config.Server.HTTPSPort)
-	log.Printf("HTTPS客户端列表: https://localhost:%d/clients", s.config.Server.HTTPSPort)
-	
-	if err := s.httpsServer.ListenAndServeTLS(s.config.Server.CertFile, s.config.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-		log.Printf("HTTPS服务器错误: %v", err)
+	serverLog.Info("HTTPS服务器启动", "port", s.config.Server.HTTPSPort)
+	if s.singlePortEnabled() {
+		serverLog.Info("隧道入口", "url", fmt.Sprintf("wss://%s%s", s.tunnelEndpointHost(s.config.Server.HTTPSPort), s.config.Server.TunnelPath))
 	}
+	
+	// 证书由 TLSConfig.GetCertificate 提供
+	return serve("HTTPS服务器", s.httpsServer, listeners, true)
 }
 
 // startWebSocketSecureServer 启动WebSocket Secure服务器 (WSS)
-func (s *TunnelServer) startWebSocketSecureServer() {
+func (s *TunnelServer) startWebSocketSecureServer() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleWebSocket)
 	
-	addr := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.WSSPort)
+	tlsConfig, err := s.clientTLSConfig(false)
+	if err != nil {
+		return fmt.Errorf("WSS客户端证书配置错误: %v", err)
+	}
+	
+	listeners, err := s.listen("wss", s.config.Server.WSSNetwork, s.config.Server.WSSPort)
+	if err != nil {
+		return fmt.Errorf("WSS监听失败: %v", err)
+	}
 	
 	s.wssServer = &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Handler:   mux,
+		TLSConfig: s.serverTLSConfig(tlsConfig, "http/1.1"),
 	}
 	
-	log.Printf("WebSocket Secure服务器启动在端口 %d (IPv4: %s)", s.config.Server.WSSPort, addr)
+	serverLog.Info("WebSocket Secure服务器启动", "port", s.config.Server.WSSPort)
+	if tlsConfig != nil {
+		tlsLog.Info("WSS客户端证书认证", "mode", s.config.Auth.MTLS.Mode, "ca", s.config.Auth.MTLS.CAFile)
+	}
 	
-	if err := s.wssServer.ListenAndServeTLS(s.config.Server.CertFile, s.config.Server.KeyFile); err != nil && err != http.ErrServerClosed {
-		log.Printf("WSS服务器错误: %v", err)
+	// 证书由 TLSConfig.GetCertificate 提供
+	return serve("WSS服务器", s.wssServer, listeners, true)
+}
+
+// tunnelEndpointHost 单端口模式下隧道入口的主机部分
+func (s *TunnelServer) tunnelEndpointHost(port int) string {
+	host := s.config.Server.TunnelHostname
+	if host == "" {
+		host = s.config.Server.PublicDomain
+	}
+	if host == "" {
+		host = "localhost"
 	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// publicURL 返回主机名对应的公网访问地址，优先使用HTTPS
+func (s *TunnelServer) publicURL(host string) string {
+	scheme, port, defaultPort := "http", s.config.Server.HTTPPort, 80
+	if s.config.Server.EnableHTTPS {
+		scheme, port, defaultPort = "https", s.config.Server.HTTPSPort, 443
+	}
+	if port == defaultPort {
+		return fmt.Sprintf("%s://%s", scheme, host)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
+
+// serverTLSConfig 为TLS监听器配置证书来源 (证书文件按SNI选择并自动重新加载，或由ACME提供)
+func (s *TunnelServer) serverTLSConfig(base *tls.Config, nextProtos ...string) *tls.Config {
+	tlsConfig := base
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig.GetCertificate = s.getCertificate
+	tlsConfig.NextProtos = nextProtos
+	if s.acme != nil {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	return tlsConfig
 }
 
 // handleWebSocket 处理WebSocket连接
 func (s *TunnelServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// 验证认证
-	if s.config.Auth.RequireAuth {
-		token := r.Header.Get("Authorization")
-		if !s.validateToken(token) {
+	// 验证客户端证书
+	mtlsMode := s.config.Auth.MTLS.Mode
+	identity, hasCert := clientCertIdentity(r)
+	if mtlsMode == MTLSModeRequire && !hasCert {
+		s.metrics.authFailures.With(authFailureClientCert).Inc()
+		authLog.Info("拒绝隧道连接: 需要客户端证书", "remote", s.clientIP(r))
+		http.Error(w, "需要客户端证书", http.StatusUnauthorized)
+		return
+	}
+	
+	// 验证认证 (optional 模式下有效证书可代替令牌)
+	var token *authToken
+	if s.config.Auth.RequireAuth && !(mtlsMode == MTLSModeOptional && hasCert) {
+		if !s.allowAuthAttempt(w, r) {
+			return
+		}
+		var ok bool
+		token, ok = s.validateToken(r.Header.Get("Authorization"))
+		if !ok {
+			s.recordAuthFailure(r)
+			s.metrics.authFailures.With(authFailureToken).Inc()
+			authLog.Info("拒绝隧道连接: 令牌无效", "remote", s.clientIP(r))
 			http.Error(w, "认证失败", http.StatusUnauthorized)
 			return
 		}
+	} else if s.config.Auth.RequireAuth && hasCert {
+		// 证书代替令牌时，按证书身份找到同名令牌并套用其作用域和连接数限制
+		var err error
+		token, err = s.certToken(identity)
+		if err != nil {
+			authLog.Info("拒绝隧道连接: 证书没有对应的令牌", "identity", identity, "remote", s.clientIP(r))
+			s.metrics.authFailures.With(authFailureScope).Inc()
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if identity == "" && token != nil {
+		identity = token.label()
+	}
+	
+	// 解析客户端注册的路由
+	route, err := parseTunnelRoute(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	// 检查令牌作用域
+	if token != nil {
+		if err := token.scope.authorize(route); err != nil {
+			authLog.Info("拒绝隧道连接: 令牌权限不足", "identity", token.label(), "error", err)
+			s.metrics.authFailures.With(authFailureScope).Inc()
+			http.Error(w, fmt.Sprintf("令牌权限不足: %v", err), http.StatusForbidden)
+			return
+		}
+	}
+	
+	// 检查主机名保留
+	if err := s.admin.checkReservation(route.Hostname, token); err != nil {
+		authLog.Info("拒绝隧道连接: 主机名已被保留", "identity", identity, "route", route.Hostname)
+		s.metrics.authFailures.With(authFailureScope).Inc()
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	
+	if route.Type != TunnelTypeHTTP {
+		http.Error(w, fmt.Sprintf("暂不支持的隧道类型: %s", route.Type), http.StatusNotImplemented)
+		return
+	}
+	
+	// 客户端声明的访问策略
+	clientID := fmt.Sprintf("client_%d_%d", time.Now().Unix(), s.clientSeq.Add(1))
+	policy, policySummary, err := s.parseClientPolicy(r, clientID, route)
+	if err != nil {
+		authLog.Info("拒绝客户端访问策略", "identity", identity, "error", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	
+	// 占用令牌连接数
+	if !s.acquireTokenConn(token) {
+		authLog.Info("令牌连接数已达上限", "identity", token.label(), "max", token.scope.maxConnections)
+		http.Error(w, "令牌连接数已达上限", http.StatusTooManyRequests)
+		return
 	}
 	
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket升级失败: %v", err)
+		s.releaseTokenConn(token)
+		tunnelLog.Warn("WebSocket升级失败", "remote", s.clientIP(r), "error", err)
 		return
 	}
 	
 	// 创建客户端
-	clientID := fmt.Sprintf("client_%d", time.Now().Unix())
 	host := r.Header.Get("X-Tunnel-Host")
 	if host == "" {
 		host = "localhost"
@@ -276,39 +766,80 @@ func (s *TunnelServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		Conn:     conn,
 		Host:     host,
 		Port:     port,
+		Route:    route,
+		Token:    token,
+		Identity: identity,
 		LastPing: time.Now(),
+		RemoteAddr:    s.clientIP(r),
+		ConnectedAt:   time.Now(),
+		Policy:        policy,
+		PolicySummary: policySummary,
+		usageMark:     time.Now(),
+		inMeter:       &throughputMeter{},
+		outMeter:      &throughputMeter{},
+		requestMeter:  &throughputMeter{},
+		errorMeter:    &throughputMeter{},
+		sendQueue:     make(chan interface{}, clientSendQueueSize),
+		done:          make(chan struct{}),
 	}
 	
 	s.clientsMux.Lock()
 	s.clients[clientID] = client
 	s.clientsMux.Unlock()
 	
-	log.Printf("客户端连接: %s (%s:%d)", clientID, host, port)
+	s.metrics.connections.Inc()
+	if r.Header.Get("X-Tunnel-Reconnect") != "" {
+		s.metrics.reconnects.Inc()
+	}
+	
+	connectedAt := time.Now()
+	logger := tunnelLog.With("client_id", clientID, "route", usageRouteKey(client))
+	logger.Info("客户端连接", "identity", identity, "local", fmt.Sprintf("%s:%d", host, port))
+	// override 时客户端声明的策略会被服务器策略取代，同时告知客户端，避免开发者误以为隧道受到保护
+	var policyWarning string
+	if policy != nil {
+		authLog.Info("客户端声明访问策略", "client_id", clientID, "policy", policySummary)
+		if s.config.Access.ClientPolicies.Override && s.accessPolicyFor(route.Hostname) != nil {
+			policyWarning = "主机名已配置服务器访问策略且服务器设置了 override，客户端声明的访问策略不会生效"
+			authLog.Warn(policyWarning, "client_id", clientID, "route", route.Hostname)
+		}
+	}
 	
 	// 发送欢迎消息
+	publicHost := s.config.Server.PublicDomain
+	if route.Hostname != "" {
+		publicHost = route.Hostname
+	}
 	welcomeMsg := map[string]interface{}{
 		"type": "connected",
 		"data": map[string]interface{}{
 			"clientId":     clientID,
-			"publicUrl":    fmt.Sprintf("http://%s:%d", s.config.Server.PublicDomain, s.config.Server.HTTPPort),
+			"publicUrl":    s.publicURL(publicHost),
 			"localTarget": fmt.Sprintf("%s:%d", host, port),
 		},
 	}
-	client.Conn.WriteJSON(welcomeMsg)
+	if policyWarning != "" {
+		welcomeMsg["data"].(map[string]interface{})["policyWarning"] = policyWarning
+	}
+	go client.writeLoop()
+	client.send(welcomeMsg)
 	
 	// 处理消息
 	defer func() {
+		close(client.done)
 		s.clientsMux.Lock()
+		s.accountConnection(client, time.Now())
 		delete(s.clients, clientID)
 		s.clientsMux.Unlock()
+		s.releaseTokenConn(token)
 		conn.Close()
-		log.Printf("客户端断开: %s", clientID)
+		logger.Info("客户端断开", "duration", time.Since(connectedAt).Round(time.Second))
 	}()
 	
 	for {
 		var msg map[string]interface{}
 		if err := conn.ReadJSON(&msg); err != nil {
-			log.Printf("读取消息失败: %v", err)
+			logger.Debug("读取消息失败", "error", err)
 			break
 		}
 		
@@ -327,13 +858,13 @@ func (s *TunnelServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 func (s *TunnelServer) handleHTTPResponse(msg map[string]interface{}) {
 	requestID, ok := msg["id"].(string)
 	if !ok {
-		log.Printf("HTTP响应缺少请求ID")
+		httpLog.Warn("HTTP响应缺少请求ID")
 		return
 	}
 	
 	data, ok := msg["data"].(map[string]interface{})
 	if !ok {
-		log.Printf("HTTP响应数据格式错误")
+		httpLog.Warn("HTTP响应数据格式错误", "request_id", requestID)
 		return
 	}
 	
@@ -361,32 +892,59 @@ func (s *TunnelServer) handleHTTPResponse(msg map[string]interface{}) {
 		response.Error = errorMsg
 	}
 	
+	if originMs, ok := data["originMs"].(float64); ok {
+		response.OriginTime = time.Duration(originMs * float64(time.Millisecond))
+	}
+	
 	// 查找等待的请求通道
 	s.requestMux.Lock()
-	responseChan, exists := s.pendingRequests[requestID]
+	pending, exists := s.pendingRequests[requestID]
 	if exists {
 		delete(s.pendingRequests, requestID)
 	}
 	s.requestMux.Unlock()
 	
 	if !exists {
-		log.Printf("未找到等待的请求: %s", requestID)
+		httpLog.Debug("未找到等待的请求 (可能已超时或被取消)", "request_id", requestID)
 		return
 	}
 	
 	// 发送响应到通道
 	select {
-	case responseChan <- response:
-		log.Printf("HTTP响应已处理: %s (状态: %d)", requestID, response.StatusCode)
+	case pending.response <- response:
+		httpLog.Debug("HTTP响应已处理", "request_id", requestID, "status", response.StatusCode)
 	default:
-		log.Printf("响应通道已关闭: %s", requestID)
+		httpLog.Debug("响应通道已关闭", "request_id", requestID)
 	}
 }
 
-// validateToken 验证令牌
-func (s *TunnelServer) validateToken(authHeader string) bool {
+// loadTokens 解析配置中的认证令牌
+func (s *TunnelServer) loadTokens() error {
+	tokens := make([]*authToken, 0, len(s.config.Auth.Tokens))
+	plainCount := 0
+	for i, cfg := range s.config.Auth.Tokens {
+		token, err := parseAuthToken(cfg)
+		if err != nil {
+			return fmt.Errorf("第 %d 个认证令牌无效: %v", i+1, err)
+		}
+		if token.hash == nil {
+			plainCount++
+		}
+		tokens = append(tokens, token)
+	}
+	
+	if plainCount > 0 {
+		authLog.Warn("配置中有明文令牌，请使用 'tunnel-server token hash' 转换为哈希", "count", plainCount)
+	}
+	
+	s.tokens = tokens
+	return nil
+}
+
+// validateToken 验证令牌，返回匹配的令牌配置
+func (s *TunnelServer) validateToken(authHeader string) (*authToken, bool) {
 	if authHeader == "" {
-		return false
+		return nil, false
 	}
 	
 	// 移除 "Bearer " 前缀
@@ -395,76 +953,200 @@ func (s *TunnelServer) validateToken(authHeader string) bool {
 		token = authHeader[7:]
 	}
 	
-	for _, validToken := range s.config.Auth.Tokens {
-		if token == validToken {
-			return true
+	s.tokensMux.RLock()
+	defer s.tokensMux.RUnlock()
+	validToken := findToken(s.tokens, token)
+	return validToken, validToken != nil
+}
+
+// certToken 返回与证书身份同名的令牌。没有同名令牌时，如果配置了带限制的令牌则拒绝，
+// 避免只出示证书的客户端绕过令牌作用域；否则返回 nil 表示不限制
+func (s *TunnelServer) certToken(identity string) (*authToken, error) {
+	s.tokensMux.RLock()
+	defer s.tokensMux.RUnlock()
+	limited := false
+	for _, token := range s.tokens {
+		if token.name != "" && token.name == identity {
+			return token, nil
+		}
+		if token.scope.limited() {
+			limited = true
 		}
 	}
-	return false
+	if limited {
+		return nil, fmt.Errorf("证书 %s 没有对应的令牌 (已配置带作用域的令牌时，证书身份必须与某个令牌的 name 相同)", identity)
+	}
+	return nil, nil
 }
 
-// handleHealth 健康检查
-func (s *TunnelServer) handleHealth(w http.ResponseWriter, r *http.Request) {
-	s.clientsMux.RLock()
-	clientCount := len(s.clients)
-	s.clientsMux.RUnlock()
+// acquireTokenConn 占用一个令牌连接名额
+func (s *TunnelServer) acquireTokenConn(token *authToken) bool {
+	if token == nil {
+		return true
+	}
 	
-	response := map[string]interface{}{
-		"status":  "healthy",
-		"clients": clientCount,
-		"uptime":  time.Now().Unix(),
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+	
+	if token.scope.maxConnections > 0 && s.tokenConns[token] >= token.scope.maxConnections {
+		return false
+	}
+	s.tokenConns[token]++
+	return true
+}
+
+// releaseTokenConn 释放令牌连接名额
+func (s *TunnelServer) releaseTokenConn(token *authToken) {
+	if token == nil {
+		return
 	}
 	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	s.clientsMux.Lock()
+	defer s.clientsMux.Unlock()
+	
+	s.tokenConns[token]--
+	if s.tokenConns[token] <= 0 {
+		delete(s.tokenConns, token)
+	}
 }
 
-// handleClients 客户端列表
-func (s *TunnelServer) handleClients(w http.ResponseWriter, r *http.Request) {
+// parseTunnelRoute 从握手请求头解析客户端注册的路由
+func parseTunnelRoute(r *http.Request) (TunnelRoute, error) {
+	route := TunnelRoute{
+		Hostname: normalizeHostname(r.Header.Get("X-Tunnel-Hostname")),
+		Type:     strings.ToLower(strings.TrimSpace(r.Header.Get("X-Tunnel-Type"))),
+	}
+	if route.Type == "" {
+		route.Type = TunnelTypeHTTP
+	}
+	
+	if portStr := r.Header.Get("X-Tunnel-Remote-Port"); portStr != "" {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return route, fmt.Errorf("无效的远程端口: %s", portStr)
+		}
+		route.RemotePort = port
+	}
+	
+	return route, nil
+}
+
+// selectClient 根据请求的主机名选择客户端
+func (s *TunnelServer) selectClient(host string) *Client {
+	hostname := normalizeHostname(host)
+	now := time.Now()
+	
 	s.clientsMux.RLock()
 	defer s.clientsMux.RUnlock()
 	
-	clients := make([]map[string]interface{}, 0)
+	// 优先匹配注册了该主机名的客户端，其次使用未指定主机名的客户端 (简单负载均衡)
+	var fallback *Client
 	for _, client := range s.clients {
-		clients = append(clients, map[string]interface{}{
-			"id":        client.ID,
-			"host":      client.Host,
-			"port":      client.Port,
-			"lastPing":  client.LastPing,
-			"connected": true,
-		})
+		if client.Draining || (client.Token != nil && client.Token.scope.expired(now)) {
+			continue
+		}
+		if client.Route.Hostname == "" {
+			if fallback == nil {
+				fallback = client
+			}
+			continue
+		}
+		if client.Route.Hostname == hostname {
+			return client
+		}
+	}
+	return fallback
+}
+
+// handleHealth 健康检查
+func (s *TunnelServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.clientsMux.RLock()
+	clientCount := len(s.clients)
+	s.clientsMux.RUnlock()
+	
+	response := map[string]interface{}{
+		"status":    "healthy",
+		"clients":   clientCount,
+		"uptime":    int64(time.Since(s.startedAt).Seconds()),
+		"startedAt": s.startedAt.Format(time.RFC3339),
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clients)
+	json.NewEncoder(w).Encode(response)
 }
 
 // handleHTTPRequest 处理HTTP请求转发
 func (s *TunnelServer) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
-	s.clientsMux.RLock()
+	// 按客户端路由记录请求结果，包括被限流、拒绝和超时的请求
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	route, clientID, requestID, user := "none", "", "", ""
+	var selectedClient *Client
+	var tunnelTime, originTime time.Duration
+	var bodyBytes []byte
+	// 有抓包会话时保留响应体，请求开始后才开始的抓包不包含此请求
+	capturing := s.captures.capturing()
+	if capturing {
+		rec.bodyLimit = s.captureBodyLimit()
+	}
+	span := s.startEdgeSpan(r)
+	defer func() {
+		duration := time.Since(start)
+		span.SetAttributes("http.response.status_code", rec.status, "http.response.body.size", rec.bytes,
+			"tunnel.client_id", clientID, "tunnel.request_id", requestID, "tunnel.route", route)
+		if rec.status >= 500 {
+			span.SetError(http.StatusText(rec.status))
+		}
+		span.End()
+		s.metrics.observeRequest(route, r.Method, rec.status)
+		s.dashboard.observe(selectedClient, r, rec, route, requestID)
+		if capturing {
+			s.captureExchange(r, rec, bodyBytes, start, tunnelTime+originTime, clientID, requestID, route)
+		}
+		httpLog.Debug("请求完成", "client_id", clientID, "request_id", requestID, "route", route,
+			"method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", duration)
+		if s.accessLog != nil {
+			entry := s.newAccessLogEntry(r, start)
+			entry.Status, entry.Bytes, entry.User = rec.status, rec.bytes, user
+			entry.Duration, entry.TunnelTime, entry.OriginTime = duration, tunnelTime, originTime
+			entry.ClientID, entry.RequestID, entry.Route = clientID, requestID, route
+			s.accessLog.write(entry)
+		}
+	}()
 	
-	if len(s.clients) == 0 {
-		s.clientsMux.RUnlock()
+	selectedClient = s.selectClient(r.Host)
+	if selectedClient == nil {
 		http.Error(w, "没有可用的隧道客户端", http.StatusServiceUnavailable)
 		return
 	}
+	route, clientID = usageRouteKey(selectedClient), selectedClient.ID
 	
-	// 选择第一个可用客户端 (简单负载均衡)
-	var selectedClient *Client
-	for _, client := range s.clients {
-		selectedClient = client
-		break
+	// 限流和访问策略 (包括客户端声明的策略) 在转发前执行，被拒绝的请求不会转发
+	if !s.checkRateLimit(w, r, selectedClient) {
+		return
 	}
-	s.clientsMux.RUnlock()
+	access, ok := s.checkAccess(w, r, selectedClient)
+	if !ok {
+		return
+	}
+	if access.identity != nil {
+		user = access.identity.User
+	}
+	throttle, ok := s.checkQuota(w, selectedClient)
+	if !ok {
+		return
+	}
+	hostname := normalizeHostname(r.Host)
+	upstream, downstream := s.shapers(selectedClient, throttle)
 	
 	// 生成请求ID
-	requestID := fmt.Sprintf("req_%d", time.Now().UnixNano())
+	requestID = fmt.Sprintf("req_%d", time.Now().UnixNano())
 	
 	// 读取请求体
-	bodyBytes := []byte{}
 	if r.Body != nil {
 		var err error
-		bodyBytes, err = io.ReadAll(r.Body)
+		bodyBytes, err = io.ReadAll(upstream.reader(r.Body))
 		if err != nil {
 			http.Error(w, "读取请求体失败", http.StatusBadRequest)
 			return
@@ -480,6 +1162,14 @@ func (s *TunnelServer) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	
+	// 告知源站访客的真实地址、协议和主机名
+	s.applyForwardedHeaders(r, headers)
+	applyIdentityHeaders(access, headers)
+	// 追踪上下文放在消息中传给客户端，由客户端的跨度生成发给源站的 traceparent
+	delete(headers, "Traceparent")
+	bytesIn := int64(len(bodyBytes)) + headerSize(headers)
+	s.metrics.bytesIn.With(route).Add(float64(bytesIn))
+	
 	// 创建HTTP请求消息
 	requestMsg := map[string]interface{}{
 		"type": "http_request",
@@ -490,42 +1180,88 @@ func (s *TunnelServer) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 			"query":   r.URL.RawQuery,
 			"headers": headers,
 			"body":    string(bodyBytes),
+			// 访客地址和公网入口地址，客户端向源站发送 PROXY protocol 头部时使用
+			"remoteAddr": s.clientAddr(r),
+			"localAddr":  connLocalAddr(r),
 		},
 	}
 	
 	// 创建响应通道
 	responseChan := make(chan HTTPResponse, 1)
 	s.requestMux.Lock()
-	s.pendingRequests[requestID] = responseChan
+	s.pendingRequests[requestID] = &pendingRequest{
+		ID:        requestID,
+		ClientID:  clientID,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Hostname:  hostname,
+		Remote:    s.clientIP(r),
+		StartedAt: start,
+		response:  responseChan,
+	}
 	s.requestMux.Unlock()
 	
 	// 发送请求到客户端
-	if err := selectedClient.Conn.WriteJSON(requestMsg); err != nil {
+	sendSpan := s.tracer.Start("tunnel send", tracing.SpanKindClient, span.Context())
+	sendSpan.SetAttributes("tunnel.client_id", clientID, "tunnel.request_id", requestID, "http.request.body.size", len(bodyBytes))
+	defer sendSpan.End()
+	if traceparent := sendSpan.Traceparent(); traceparent != "" {
+		requestMsg["data"].(map[string]interface{})["traceparent"] = traceparent
+	}
+	sentAt := time.Now()
+	if err := selectedClient.send(requestMsg); err != nil {
+		sendSpan.SetError(err.Error())
 		s.requestMux.Lock()
 		delete(s.pendingRequests, requestID)
 		s.requestMux.Unlock()
-		log.Printf("发送请求到客户端失败: %v", err)
+		s.recordUsage(selectedClient, bytesIn, 0)
+		httpLog.Warn("发送请求到客户端失败", "client_id", clientID, "request_id", requestID, "error", err)
 		http.Error(w, "发送请求失败", http.StatusInternalServerError)
 		return
 	}
 	
-	log.Printf("转发请求到客户端: %s %s (ID: %s)", r.Method, r.URL.Path, requestID)
 	
 	// 等待响应
 	select {
 	case response := <-responseChan:
+		roundTrip := time.Since(sentAt)
+		s.metrics.latency.With(route).Observe(roundTrip.Seconds())
+		originTime = min(response.OriginTime, roundTrip)
+		tunnelTime = roundTrip - originTime
+		sendSpan.SetAttributes("tunnel.origin_time", originTime, "tunnel.tunnel_time", tunnelTime)
+		// 跨度在往返结束时结束 (不包括写回访客)，错误要在 End 之前设置才会导出
+		if response.Canceled {
+			sendSpan.SetError("请求已被取消")
+		} else if response.Error != "" {
+			sendSpan.SetError(response.Error)
+		}
+		sendSpan.End()
+		
 		// 清理等待的请求
 		s.requestMux.Lock()
 		delete(s.pendingRequests, requestID)
 		s.requestMux.Unlock()
 		
+		// 被管理接口取消
+		if response.Canceled {
+			s.recordUsage(selectedClient, bytesIn, 0)
+			httpLog.Info("请求已被取消", "client_id", clientID, "request_id", requestID, "route", route)
+			http.Error(w, "请求已被取消", http.StatusServiceUnavailable)
+			return
+		}
+		
 		// 处理错误响应
 		if response.Error != "" {
-			log.Printf("客户端响应错误: %s", response.Error)
+			s.recordUsage(selectedClient, bytesIn, 0)
+			httpLog.Warn("客户端响应错误", "client_id", clientID, "request_id", requestID, "route", route, "error", response.Error)
 			http.Error(w, response.Error, http.StatusBadGateway)
 			return
 		}
 		
+		// 把响应写回访客
+		returnSpan := s.tracer.Start("response return", tracing.SpanKindInternal, span.Context())
+		defer returnSpan.End()
+		
 		// 设置响应头
 		for k, v := range response.Headers {
 			w.Header().Set(k, v)
@@ -534,12 +1270,14 @@ func (s *TunnelServer) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 		// 设置状态码
 		w.WriteHeader(response.StatusCode)
 		
-		// 写入响应体
+		// 写入响应体 (按带宽限制和配额限速)
 		if response.Body != "" {
-			w.Write([]byte(response.Body))
+			downstream.write(w, []byte(response.Body))
 		}
-		
-		log.Printf("响应已返回: %d (ID: %s)", response.StatusCode, requestID)
+		bytesOut := int64(len(response.Body)) + headerSize(response.Headers)
+		s.metrics.bytesOut.With(route).Add(float64(bytesOut))
+		returnSpan.SetAttributes("http.response.status_code", response.StatusCode, "http.response.body.size", len(response.Body))
+		s.recordUsage(selectedClient, bytesIn, bytesOut)
 		
 	case <-time.After(time.Duration(s.config.Server.RequestTimeout) * time.Millisecond):
 		// 超时处理
@@ -547,7 +1285,11 @@ func (s *TunnelServer) handleHTTPRequest(w http.ResponseWriter, r *http.Request)
 		delete(s.pendingRequests, requestID)
 		s.requestMux.Unlock()
 		
-		log.Printf("请求超时: %s (ID: %s)", r.URL.Path, requestID)
+		s.metrics.timeouts.With(route).Inc()
+		tunnelTime = time.Since(sentAt)
+		sendSpan.SetError("请求超时")
+		s.recordUsage(selectedClient, bytesIn, 0)
+		httpLog.Warn("请求超时", "client_id", clientID, "request_id", requestID, "route", route, "path", r.URL.Path)
 		http.Error(w, "请求超时", http.StatusGatewayTimeout)
 	}
 }
@@ -565,13 +1307,21 @@ var serverCmd = &cobra.Command{
 		configPath, _ := cmd.Flags().GetString("config")
 		httpPort, _ := cmd.Flags().GetInt("http-port")
 		wsPort, _ := cmd.Flags().GetInt("ws-port")
-		host, _ := cmd.Flags().GetString("host")
+		hosts, _ := cmd.Flags().GetStringSlice("host")
+		network, _ := cmd.Flags().GetString("network")
 		enableHTTPS, _ := cmd.Flags().GetBool("enable-https")
 		httpsPort, _ := cmd.Flags().GetInt("https-port")
 		certFile, _ := cmd.Flags().GetString("cert-file")
 		keyFile, _ := cmd.Flags().GetString("key-file")
 		enableWSS, _ := cmd.Flags().GetBool("enable-wss")
 		wssPort, _ := cmd.Flags().GetInt("wss-port")
+		enableHTTP, _ := cmd.Flags().GetBool("enable-http")
+		enableWS, _ := cmd.Flags().GetBool("enable-ws")
+		tunnelPath, _ := cmd.Flags().GetString("tunnel-path")
+		tunnelHostname, _ := cmd.Flags().GetString("tunnel-hostname")
+		logLevel, _ := cmd.Flags().GetString("log-level")
+		logFormat, _ := cmd.Flags().GetString("log-format")
+		otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint")
 		
 		// 加载配置
 		config, err := LoadConfig(configPath)
@@ -586,8 +1336,11 @@ var serverCmd = &cobra.Command{
 		if wsPort != 0 {
 			config.Server.WSPort = wsPort
 		}
-		if host != "" {
-			config.Server.Host = host
+		if len(hosts) > 0 {
+			config.Server.Hosts = hosts
+		}
+		if network != "" {
+			config.Server.Network = network
 		}
 		if cmd.Flags().Changed("enable-https") {
 			config.Server.EnableHTTPS = enableHTTPS
@@ -607,23 +1360,69 @@ var serverCmd = &cobra.Command{
 		if wssPort != 0 {
 			config.Server.WSSPort = wssPort
 		}
+		if cmd.Flags().Changed("enable-http") {
+			config.Server.EnableHTTP = enableHTTP
+		}
+		if cmd.Flags().Changed("enable-ws") {
+			config.Server.EnableWS = enableWS
+		}
+		if tunnelPath != "" {
+			config.Server.TunnelPath = tunnelPath
+		}
+		if tunnelHostname != "" {
+			config.Server.TunnelHostname = tunnelHostname
+		}
+		if err := config.Log.ParseLevelSpec(logLevel); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if logFormat != "" {
+			config.Log.Format = logFormat
+		}
+		if err := logging.Setup(config.Log); err != nil {
+			log.Fatalf("日志配置错误: %v", err)
+		}
+		if otlpEndpoint != "" {
+			config.Tracing.Enabled = true
+			config.Tracing.Endpoint = otlpEndpoint
+		}
 		
 		fmt.Printf("启动隧道服务器...\n")
-		fmt.Printf("HTTP 端口: %d\n", config.Server.HTTPPort)
-		fmt.Printf("WebSocket 端口: %d\n", config.Server.WSPort)
+		if config.Server.EnableHTTP {
+			fmt.Printf("HTTP 端口: %d\n", config.Server.HTTPPort)
+		}
+		if config.Server.EnableWS {
+			fmt.Printf("WebSocket 端口: %d\n", config.Server.WSPort)
+		}
 		if config.Server.EnableHTTPS {
 			fmt.Printf("HTTPS 端口: %d\n", config.Server.HTTPSPort)
-			fmt.Printf("SSL 证书: %s\n", config.Server.CertFile)
+			if config.ACME.Enabled {
+				fmt.Printf("SSL 证书: ACME (%s)\n", config.ACME.DirectoryURL)
+			} else {
+				fmt.Printf("SSL 证书: %s\n", config.Server.CertFile)
+			}
+			for _, c := range config.Server.Certificates {
+				fmt.Printf("SSL 证书: %s\n", c.CertFile)
+			}
 		}
 		if config.Server.EnableWSS {
 			fmt.Printf("WSS 端口: %d\n", config.Server.WSSPort)
 		}
+		if config.Server.TunnelPath != "" || config.Server.TunnelHostname != "" {
+			fmt.Printf("单端口隧道入口: 路径 %q 主机名 %q\n", config.Server.TunnelPath, config.Server.TunnelHostname)
+		}
+		if config.Auth.MTLS.Mode != MTLSModeOff {
+			fmt.Printf("客户端证书认证: %s\n", config.Auth.MTLS.Mode)
+			if !config.Server.EnableWSS && !(config.Server.EnableHTTPS && (config.Server.TunnelPath != "" || config.Server.TunnelHostname != "")) {
+				fmt.Printf("警告: 客户端证书认证仅作用于WSS和单端口HTTPS入口，当前均未启用\n")
+			}
+		}
 		fmt.Printf("认证令牌数量: %d\n", len(config.Auth.Tokens))
 		
 		// 创建并启动服务器
 		server := NewTunnelServer(config)
 		if err := server.Start(); err != nil {
-			log.Fatalf("启动服务器失败: %v", err)
+			serverLog.Error("启动服务器失败", "error", err)
+			os.Exit(1)
 		}
 	},
 }
@@ -639,9 +1438,45 @@ var addTokenCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// 生成随机令牌
-		token := fmt.Sprintf("token_%d_%s", time.Now().Unix(), args[0])
-		fmt.Printf("✓ 新令牌已创建: %s - %s\n", args[0], token)
-		fmt.Printf("请将此令牌添加到配置文件的 auth.tokens 数组中\n")
+		token, err := GenerateToken()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		hash, err := HashToken(token)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("✓ 新令牌已创建: %s\n", args[0])
+		fmt.Printf("客户端令牌 (仅显示一次): %s\n", token)
+		fmt.Printf("配置哈希: %s\n", hash)
+		fmt.Printf("请将配置哈希添加到配置文件的 auth.tokens 数组中，客户端使用令牌本身\n")
+	},
+}
+
+var hashTokenCmd = &cobra.Command{
+	Use:   "hash [token]",
+	Short: "生成令牌哈希 (未指定令牌时从标准输入读取)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var token string
+		if len(args) == 1 {
+			token = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				log.Fatalf("读取令牌失败: %v", err)
+			}
+			token = strings.TrimRight(line, "\r\n")
+		}
+		if token == "" {
+			log.Fatalf("令牌不能为空")
+		}
+		
+		hash, err := HashToken(token)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Println(hash)
 	},
 }
 
@@ -657,19 +1492,176 @@ var listTokenCmd = &cobra.Command{
 		
 		fmt.Printf("\n认证令牌列表:\n")
 		fmt.Printf("─────────────────────────────────\n")
-		for i, token := range config.Auth.Tokens {
-			fmt.Printf("%d: %s\n", i+1, token)
+		for i, cfg := range config.Auth.Tokens {
+			token, err := parseAuthToken(cfg)
+			if err != nil {
+				fmt.Printf("%d: 无效 (%v)\n", i+1, err)
+				continue
+			}
+			fmt.Printf("%d: %s\n", i+1, token.describe())
 		}
 		fmt.Printf("\n总计: %d 个令牌\n", len(config.Auth.Tokens))
 	},
 }
 
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "查看用量报表",
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, _ := cmd.Flags().GetString("config")
+		file, _ := cmd.Flags().GetString("file")
+		by, _ := cmd.Flags().GetString("by")
+		from, _ := cmd.Flags().GetString("from")
+		to, _ := cmd.Flags().GetString("to")
+		daily, _ := cmd.Flags().GetBool("daily")
+		format, _ := cmd.Flags().GetString("format")
+		
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			log.Fatalf("加载配置失败: %v", err)
+		}
+		if file == "" {
+			file = config.Usage.File
+		}
+		if file == "" {
+			log.Fatalf("未配置用量文件: 请设置 usage.file 或使用 --file")
+		}
+		if by != UsageByToken && by != UsageByRoute {
+			log.Fatalf("未知的统计维度: %s (可用 token、route)", by)
+		}
+		
+		// 默认统计本月
+		now := time.Now()
+		if from == "" {
+			from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format(usageDateLayout)
+		}
+		if to == "" {
+			to = now.Format(usageDateLayout)
+		}
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse(usageDateLayout, date); err != nil {
+				log.Fatalf("日期格式应为 YYYY-MM-DD: %s", date)
+			}
+		}
+		
+		store, err := loadUsageStore(file)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		rows := usageReport(store, by, from, to, daily)
+		if format == "table" {
+			fmt.Printf("用量报表 (%s ~ %s, 按 %s)\n\n", from, to, by)
+		}
+		if err := writeUsageReport(os.Stdout, rows, format, daily); err != nil {
+			log.Fatalf("%v", err)
+		}
+	},
+}
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "客户端证书CA管理",
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "初始化本地CA",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		commonName, _ := cmd.Flags().GetString("cn")
+		days, _ := cmd.Flags().GetInt("days")
+		
+		ca, err := InitLocalCA(dir, commonName, time.Duration(days)*24*time.Hour)
+		if err != nil {
+			log.Fatalf("初始化CA失败: %v", err)
+		}
+		fmt.Printf("✓ CA已创建: %s\n", ca.cert.Subject.CommonName)
+		fmt.Printf("CA证书: %s\n", filepath.Join(dir, caCertName))
+		fmt.Printf("吊销列表: %s\n", filepath.Join(dir, caCRLName))
+		fmt.Printf("请在配置文件的 auth.mtls 中设置 caFile 和 crlFile\n")
+	},
+}
+
+var caIssueCmd = &cobra.Command{
+	Use:   "issue [name]",
+	Short: "签发客户端证书 (name 作为连接器身份)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		days, _ := cmd.Flags().GetInt("days")
+		
+		ca, err := OpenLocalCA(dir)
+		if err != nil {
+			log.Fatalf("打开CA失败: %v", err)
+		}
+		cert, err := ca.Issue(args[0], time.Duration(days)*24*time.Hour)
+		if err != nil {
+			log.Fatalf("签发证书失败: %v", err)
+		}
+		fmt.Printf("✓ 证书已签发: %s (序列号 %s)\n", args[0], cert.SerialNumber.Text(16))
+		fmt.Printf("证书: %s\n", filepath.Join(dir, caIssuedDir, args[0]+".crt"))
+		fmt.Printf("私钥: %s\n", filepath.Join(dir, caIssuedDir, args[0]+".key"))
+		fmt.Printf("有效期至: %s\n", cert.NotAfter.Format(time.RFC3339))
+	},
+}
+
+var caRevokeCmd = &cobra.Command{
+	Use:   "revoke [name|serial]",
+	Short: "吊销客户端证书",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		
+		ca, err := OpenLocalCA(dir)
+		if err != nil {
+			log.Fatalf("打开CA失败: %v", err)
+		}
+		serial, err := ca.Revoke(args[0])
+		if err != nil {
+			log.Fatalf("吊销证书失败: %v", err)
+		}
+		fmt.Printf("✓ 证书已吊销: %s\n", serial.Text(16))
+		fmt.Printf("运行中的服务器会自动重新加载CRL\n")
+	},
+}
+
+var caListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "列出已签发的客户端证书",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, _ := cmd.Flags().GetString("dir")
+		
+		ca, err := OpenLocalCA(dir)
+		if err != nil {
+			log.Fatalf("打开CA失败: %v", err)
+		}
+		certs, err := ca.List()
+		if err != nil {
+			log.Fatalf("读取证书列表失败: %v", err)
+		}
+		
+		fmt.Printf("\n客户端证书列表 (CA: %s):\n", ca.cert.Subject.CommonName)
+		fmt.Printf("─────────────────────────────────\n")
+		for _, c := range certs {
+			status := "有效"
+			if c.Revoked {
+				status = "已吊销"
+			} else if time.Now().After(c.Cert.NotAfter) {
+				status = "已过期"
+			}
+			fmt.Printf("%s  序列号 %s  有效期至 %s  %s\n", c.Name, c.Cert.SerialNumber.Text(16), c.Cert.NotAfter.Format("2006-01-02"), status)
+		}
+		fmt.Printf("\n总计: %d 个证书\n", len(certs))
+	},
+}
+
 func init() {
 	// server 命令标志
 	serverCmd.Flags().StringP("config", "c", "", "配置文件路径")
 	serverCmd.Flags().Int("http-port", 0, "HTTP服务端口")
 	serverCmd.Flags().Int("ws-port", 0, "WebSocket端口") 
-	serverCmd.Flags().String("host", "", "监听地址")
+	serverCmd.Flags().StringSlice("host", nil, "监听地址，可重复指定或用逗号分隔 (IPv6 如 [::])")
+	serverCmd.Flags().String("network", "", "监听网络类型 dual/tcp4/tcp6")
 	// HTTPS 相关标志
 	serverCmd.Flags().Bool("enable-https", false, "启用HTTPS服务器")
 	serverCmd.Flags().Int("https-port", 0, "HTTPS服务端口 (默认6443)")
@@ -677,13 +1669,37 @@ func init() {
 	serverCmd.Flags().String("key-file", "", "SSL私钥文件路径")
 	serverCmd.Flags().Bool("enable-wss", false, "启用WebSocket Secure (WSS)")
 	serverCmd.Flags().Int("wss-port", 0, "WSS端口 (默认6444)")
+	// 单端口模式标志
+	serverCmd.Flags().Bool("enable-http", true, "启用HTTP服务器")
+	serverCmd.Flags().Bool("enable-ws", true, "启用WebSocket服务器")
+	serverCmd.Flags().String("tunnel-path", "", "公网端口上的隧道路径 (如 /_tunnel)")
+	serverCmd.Flags().String("tunnel-hostname", "", "公网端口上的隧道专用主机名")
+	serverCmd.Flags().String("log-level", "", "日志级别，可按组件覆盖 (如 info,http=debug)")
+	serverCmd.Flags().String("log-format", "", "日志格式 (text/json)")
+	serverCmd.Flags().String("otlp-endpoint", "", "启用链路追踪，导出到此 OTLP/HTTP 地址 (如 http://localhost:4318)")
 	
 	// token 命令标志
 	tokenCmd.PersistentFlags().StringP("config", "c", "", "配置文件路径")
 	
+	// usage 命令标志
+	usageCmd.Flags().StringP("config", "c", "", "配置文件路径")
+	usageCmd.Flags().String("file", "", "用量文件 (默认使用配置中的 usage.file)")
+	usageCmd.Flags().String("by", UsageByToken, "统计维度 token/route")
+	usageCmd.Flags().String("from", "", "开始日期 YYYY-MM-DD (默认本月1日)")
+	usageCmd.Flags().String("to", "", "结束日期 YYYY-MM-DD (默认今天)")
+	usageCmd.Flags().Bool("daily", false, "按天分别列出")
+	usageCmd.Flags().String("format", "table", "输出格式 table/csv/json")
+	
+	// ca 命令标志
+	caCmd.PersistentFlags().String("dir", "ca", "CA目录")
+	caInitCmd.Flags().String("cn", "Tunnel Client CA", "CA名称")
+	caInitCmd.Flags().Int("days", 3650, "CA有效天数")
+	caIssueCmd.Flags().Int("days", 365, "证书有效天数")
+	
 	// 添加子命令
-	tokenCmd.AddCommand(addTokenCmd, listTokenCmd)
-	rootCmd.AddCommand(serverCmd, tokenCmd)
+	tokenCmd.AddCommand(addTokenCmd, hashTokenCmd, listTokenCmd)
+	caCmd.AddCommand(caInitCmd, caIssueCmd, caRevokeCmd, caListCmd)
+	rootCmd.AddCommand(serverCmd, tokenCmd, caCmd, usageCmd)
 }
 
 func main() {
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 用户不存在时用于比较的哈希
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// htpasswd 用户名到密码哈希的映射，兼容 Apache htpasswd 格式
+type htpasswd map[string]string
+
+// parseHtpasswd 解析 "user:hash" 形式的行，支持 bcrypt、apr1 (MD5)、{SHA} 和 argon2id
+func parseHtpasswd(lines []string) (htpasswd, error) {
+	users := make(htpasswd)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("htpasswd 格式错误: %q", line)
+		}
+		if !supportedPasswordHash(hash) {
+			return nil, fmt.Errorf("用户 %s 的密码哈希格式不受支持 (请使用 bcrypt、apr1、{SHA} 或 argon2id)", user)
+		}
+		users[user] = hash
+	}
+	return users, nil
+}
+
+// loadHtpasswdFile 读取 htpasswd 文件
+func loadHtpasswdFile(path string) (htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 htpasswd 文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取 htpasswd 文件失败: %v", err)
+	}
+	return parseHtpasswd(lines)
+}
+
+// supportedPasswordHash 检查哈希格式
+func supportedPasswordHash(hash string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return true
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "{SHA}"):
+		return true
+	case isTokenHash(hash):
+		_, err := parseTokenHash(hash)
+		return err == nil
+	}
+	return false
+}
+
+// verify 校验用户名和密码，用户不存在时同样执行一次哈希计算以避免时间差
+func (h htpasswd) verify(user, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return verifyPasswordHash(hash, password)
+}
+
+// verifyPasswordHash 按哈希格式校验密码
+func verifyPasswordHash(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		// Apache 的 $2y$ 与 $2b$ 算法相同
+		if strings.HasPrefix(hash, "$2y$") {
+			hash = "$2b$" + hash[4:]
+		}
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(apr1Crypt(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	case isTokenHash(hash):
+		parsed, err := parseTokenHash(hash)
+		return err == nil && parsed.verify(password)
+	}
+	return false
+}
+
+// apr1Crypt 计算 Apache 的 MD5 密码哈希 ($apr1$salt$hash)
+func apr1Crypt(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	h := md5.New()
+	h.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(alt[:min(16, i)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+
+	return magic + salt + "$" + out.String()
+}
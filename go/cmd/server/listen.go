@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 监听器网络类型
+const (
+	NetworkDual = "dual" // IPv4 和 IPv6 (通配地址时为双栈套接字)
+	NetworkTCP4 = "tcp4" // 仅 IPv4
+	NetworkTCP6 = "tcp6" // 仅 IPv6
+)
+
+// listenNetwork 将配置的网络类型转换为 net.Listen 使用的名称
+func listenNetwork(network string) (string, error) {
+	switch strings.ToLower(network) {
+	case "", NetworkDual, "tcp":
+		return "tcp", nil
+	case NetworkTCP4:
+		return NetworkTCP4, nil
+	case NetworkTCP6:
+		return NetworkTCP6, nil
+	}
+	return "", fmt.Errorf("未知的网络类型: %s (可选 dual/tcp4/tcp6)", network)
+}
+
+// normalizeBindHost 去掉 IPv6 字面量的方括号，如 "[::1]" -> "::1"
+func normalizeBindHost(host string) string {
+	host = strings.TrimSpace(host)
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// bindHosts 返回所有监听地址，hosts 优先于 host
+func (s *TunnelServer) bindHosts() []string {
+	hosts := s.config.Server.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{s.config.Server.Host}
+	}
+
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		result = append(result, normalizeBindHost(host))
+	}
+	return result
+}
+
+// listen 在所有监听地址上创建监听器，任一失败时关闭已创建的监听器；
+// kind 为监听器名称 (http/https/ws/wss)，用于判断是否启用 PROXY protocol
+func (s *TunnelServer) listen(kind, network string, port int) ([]net.Listener, error) {
+	if network == "" {
+		network = s.config.Server.Network
+	}
+	netName, err := listenNetwork(network)
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []net.Listener
+	for _, host := range s.bindHosts() {
+		host, ok := hostForNetwork(host, netName)
+		if !ok {
+			continue
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		listener, err := net.Listen(netName, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("监听 %s (%s) 失败: %v", addr, network, err)
+		}
+		listeners = append(listeners, s.wrapProxyProtocol(kind, listener))
+	}
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("没有适用于 %s 的监听地址", network)
+	}
+	return listeners, nil
+}
+
+// hostForNetwork 按网络类型调整监听地址：通配地址转换为对应协议族，
+// 协议族不符的 IP 地址跳过 (共用 hosts 时各监听器可单独指定 tcp4/tcp6)
+func hostForNetwork(host, netName string) (string, bool) {
+	ip := net.ParseIP(host)
+	if host != "" && ip == nil {
+		return host, true // 主机名由系统解析
+	}
+
+	switch netName {
+	case NetworkTCP4:
+		if host == "" || ip.IsUnspecified() {
+			return "0.0.0.0", true
+		}
+		return host, ip.To4() != nil
+	case NetworkTCP6:
+		if host == "" || ip.IsUnspecified() {
+			return "::", true
+		}
+		return host, ip.To4() == nil
+	}
+	return host, true
+}
+
+// serve 在所有监听器上运行服务器，返回第一个错误；tls 为 true 时证书由 TLSConfig 提供
+func serve(name string, server *http.Server, listeners []net.Listener, tls bool) error {
+	errCh := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		serverLog.Info(name+"监听地址", "addr", listener.Addr().String())
+		go func(l net.Listener) {
+			if tls {
+				errCh <- server.ServeTLS(l, "", "")
+			} else {
+				errCh <- server.Serve(l)
+			}
+		}(listener)
+	}
+
+	if err := <-errCh; err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("%s错误: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"tunnel/internal/logging"
+)
+
+// 各组件的日志记录器，级别可在 log.components 中单独设置
+var (
+	serverLog = logging.Component("server") // 启动和监听
+	tunnelLog = logging.Component("tunnel") // 客户端连接
+	httpLog   = logging.Component("http")   // 请求转发
+	authLog   = logging.Component("auth")   // 令牌、访问策略、边缘认证和限流
+	tlsLog    = logging.Component("tls")    // 证书、ACME 和 CRL
+	usageLog  = logging.Component("usage")  // 用量、配额和带宽限制
+	adminLog  = logging.Component("admin")  // 管理接口的操作
+)
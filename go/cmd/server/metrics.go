@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tunnel/internal/metrics"
+)
+
+// 认证失败原因
+const (
+	authFailureToken      = "token"
+	authFailureClientCert = "client_cert"
+	authFailureScope      = "scope"
+	authFailureEdgeBasic  = "edge_basic"
+	authFailureEdgeOIDC   = "edge_oidc"
+	authFailureAdmin      = "admin"
+)
+
+// serverMetrics 服务器的 Prometheus 指标
+type serverMetrics struct {
+	registry     *metrics.Registry
+	requests     *metrics.CounterVec
+	latency      *metrics.HistogramVec
+	bytesIn      *metrics.CounterVec
+	bytesOut     *metrics.CounterVec
+	timeouts     *metrics.CounterVec
+	authFailures *metrics.CounterVec
+	connections  *metrics.Counter
+	reconnects   *metrics.Counter
+}
+
+// newServerMetrics 注册服务器指标，连接数等当前值在输出时读取
+func newServerMetrics(s *TunnelServer) *serverMetrics {
+	r := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry: r,
+		requests: r.NewCounterVec("tunnel_http_requests_total",
+			"按客户端路由、方法和状态码统计的请求数", "route", "method", "status"),
+		latency: r.NewHistogramVec("tunnel_http_request_duration_seconds",
+			"经隧道从服务器到源站的往返时间", nil, "route"),
+		bytesIn: r.NewCounterVec("tunnel_http_request_bytes_total",
+			"转发给客户端的请求字节数 (请求头和请求体)", "route"),
+		bytesOut: r.NewCounterVec("tunnel_http_response_bytes_total",
+			"客户端返回的响应字节数 (响应头和响应体)", "route"),
+		timeouts: r.NewCounterVec("tunnel_http_request_timeouts_total",
+			"等待客户端响应超时的请求数", "route"),
+		authFailures: r.NewCounterVec("tunnel_auth_failures_total",
+			"按原因统计的隧道注册和边缘认证失败次数", "reason"),
+		connections: r.NewCounter("tunnel_client_connections_total",
+			"接受的隧道客户端连接数"),
+		reconnects: r.NewCounter("tunnel_client_reconnects_total",
+			"断线后重连的隧道客户端连接数"),
+	}
+	r.NewGaugeFunc("tunnel_clients_connected", "当前连接的隧道客户端数", func() float64 {
+		s.clientsMux.RLock()
+		defer s.clientsMux.RUnlock()
+		return float64(len(s.clients))
+	})
+	r.NewGaugeFunc("tunnel_pending_requests", "等待客户端响应的请求数", func() float64 {
+		s.requestMux.Lock()
+		defer s.requestMux.Unlock()
+		return float64(len(s.pendingRequests))
+	})
+	r.NewGaugeFunc("tunnel_write_queue_depth", "等待写入隧道客户端的消息数", func() float64 {
+		s.clientsMux.RLock()
+		defer s.clientsMux.RUnlock()
+		depth := 0
+		for _, client := range s.clients {
+			depth += len(client.sendQueue)
+		}
+		return float64(depth)
+	})
+	r.NewGaugeFunc("tunnel_uptime_seconds", "服务器运行时长(秒)", func() float64 {
+		return time.Since(s.startedAt).Seconds()
+	})
+	return m
+}
+
+// observeRequest 记录一次请求的结果
+func (m *serverMetrics) observeRequest(route, method string, status int) {
+	m.requests.With(route, metricMethod(method), strconv.Itoa(status)).Inc()
+}
+
+// metricMethod 非标准方法归为 OTHER，避免标签无限增长
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusRecorder 记录写入的状态码和响应体字节数，5xx 响应另外保留响应体开头作为错误信息。
+// bodyLimit 大于 0 时 (抓包中) 保留响应体的前 bodyLimit 字节
+type statusRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	errorBody []byte
+	body      []byte
+	bodyLimit int
+}
+
+// 保留的错误响应体长度
+const errorBodyLimit = 256
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	if r.status >= 500 && len(r.errorBody) < errorBodyLimit {
+		r.errorBody = append(r.errorBody, p[:min(n, errorBodyLimit-len(r.errorBody))]...)
+	}
+	if len(r.body) < r.bodyLimit {
+		r.body = append(r.body, p[:min(n, r.bodyLimit-len(r.body))]...)
+	}
+	return n, err
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// metricsPath 指标路径，默认 /metrics
+func (s *TunnelServer) metricsPath() string {
+	if s.config.Metrics.Path != "" {
+		return s.config.Metrics.Path
+	}
+	return "/metrics"
+}
+
+// metricsListen 提供指标的地址，未设置 metrics.listen 时由管理接口提供
+func (s *TunnelServer) metricsListen() string {
+	if s.config.Metrics.Listen != "" {
+		return s.config.Metrics.Listen
+	}
+	return s.config.Admin.Listen
+}
+
+// startMetricsServer 在单独的管理地址上提供指标和健康检查
+func (s *TunnelServer) startMetricsServer() error {
+	mux := http.NewServeMux()
+	mux.Handle(s.metricsPath(), s.metrics.registry)
+	mux.HandleFunc("/health", s.handleHealth)
+
+	listener, err := net.Listen("tcp", s.config.Metrics.Listen)
+	if err != nil {
+		return fmt.Errorf("指标监听失败: %v", err)
+	}
+	server := &http.Server{Handler: mux}
+	return serve("指标服务器", server, []net.Listener{listener}, false)
+}
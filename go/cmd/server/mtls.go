@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// 客户端证书认证模式
+const (
+	MTLSModeOff      = ""
+	MTLSModeOptional = "optional" // 有效证书可代替令牌
+	MTLSModeRequire  = "require"  // 必须提供证书，令牌按 requireAuth 额外检查
+)
+
+// crlChecker 证书吊销列表检查，文件变化时自动重新加载
+type crlChecker struct {
+	path    string
+	ca      *x509.Certificate
+	mu      sync.RWMutex
+	modTime time.Time
+	revoked map[string]bool
+}
+
+// newCRLChecker 创建吊销列表检查器
+func newCRLChecker(path string, ca *x509.Certificate) (*crlChecker, error) {
+	c := &crlChecker{path: path, ca: ca, revoked: make(map[string]bool)}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload 文件修改时间变化时重新加载吊销列表
+func (c *crlChecker) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("读取CRL文件失败: %v", err)
+	}
+
+	c.mu.RLock()
+	unchanged := info.ModTime().Equal(c.modTime)
+	c.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	revoked, err := loadCRL(c.path, c.ca)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.revoked = revoked
+	c.modTime = info.ModTime()
+	c.mu.Unlock()
+
+	tlsLog.Info("已加载CRL", "file", c.path, "revoked", len(revoked))
+	return nil
+}
+
+// isRevoked 检查证书序列号是否已吊销
+func (c *crlChecker) isRevoked(serial *big.Int) bool {
+	if err := c.reload(); err != nil {
+		// 保留上一次成功加载的列表
+		tlsLog.Error("重新加载CRL失败", "error", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.revoked[serial.String()]
+}
+
+// loadCRL 读取并校验 CRL，返回吊销的序列号集合
+func loadCRL(path string, ca *x509.Certificate) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取CRL文件失败: %v", err)
+	}
+
+	crl, err := parseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := crl.CheckSignatureFrom(ca); err != nil {
+		return nil, fmt.Errorf("CRL签名校验失败: %v", err)
+	}
+
+	revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.String()] = true
+	}
+	return revoked, nil
+}
+
+// loadCACertificate 读取 PEM 格式的 CA 证书
+func loadCACertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取CA证书失败: %v", err)
+	}
+	return parseCertificatePEM(data)
+}
+
+// clientTLSConfig 根据配置构造客户端证书校验；
+// public 为 true 时用于公网端口 (单端口模式)，访客不会出示证书，因此只校验出示的证书，
+// require 模式由 handleWebSocket 检查
+func (s *TunnelServer) clientTLSConfig(public bool) (*tls.Config, error) {
+	mtls := s.config.Auth.MTLS
+	switch mtls.Mode {
+	case MTLSModeOff:
+		return nil, nil
+	case MTLSModeOptional, MTLSModeRequire:
+	default:
+		return nil, fmt.Errorf("未知的客户端证书模式: %s", mtls.Mode)
+	}
+
+	ca, err := loadCACertificate(mtls.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	var crl *crlChecker
+	if mtls.CRLFile != "" {
+		if crl, err = newCRLChecker(mtls.CRLFile, ca); err != nil {
+			return nil, err
+		}
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if mtls.Mode == MTLSModeRequire && !public {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		ClientAuth: clientAuth,
+		ClientCAs:  pool,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if crl == nil || len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			cert := cs.PeerCertificates[0]
+			if crl.isRevoked(cert.SerialNumber) {
+				tlsLog.Warn("拒绝已吊销的客户端证书", "identity", cert.Subject.CommonName, "serial", cert.SerialNumber.Text(16))
+				return fmt.Errorf("客户端证书已吊销")
+			}
+			return nil
+		},
+	}, nil
+}
+
+// clientCertIdentity 返回已校验客户端证书的身份 (CN，其次为 SAN)
+func clientCertIdentity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName, true
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], true
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String(), true
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0], true
+	}
+	return cert.SerialNumber.Text(16), true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientTLSConfigRejectsRevokedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := InitLocalCA(dir, "test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("初始化CA失败: %v", err)
+	}
+	for _, name := range []string{"laptop", "stolen"} {
+		if _, err := ca.Issue(name, time.Hour); err != nil {
+			t.Fatalf("签发证书失败: %v", err)
+		}
+	}
+	if _, err := ca.Revoke("stolen"); err != nil {
+		t.Fatalf("吊销证书失败: %v", err)
+	}
+	other, err := InitLocalCA(t.TempDir(), "other-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("初始化CA失败: %v", err)
+	}
+	if _, err := other.Issue("laptop", time.Hour); err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Auth.MTLS.Mode = MTLSModeOptional
+	config.Auth.MTLS.CAFile = filepath.Join(dir, caCertName)
+	config.Auth.MTLS.CRLFile = filepath.Join(dir, caCRLName)
+	s := NewTunnelServer(config)
+	tlsConfig, err := s.clientTLSConfig(false)
+	if err != nil {
+		t.Fatalf("构造TLS配置失败: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, _ := clientCertIdentity(r)
+		w.Write([]byte(identity))
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		caDir    string
+		cert     string
+		wantOK   bool
+		identity string
+	}{
+		{"有效证书", dir, "laptop", true, "laptop"},
+		{"已吊销的证书", dir, "stolen", false, ""},
+		{"其他CA签发的证书", other.dir, "laptop", false, ""},
+		{"未出示证书 (optional)", "", "", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientTLS := &tls.Config{InsecureSkipVerify: true}
+			if tt.cert != "" {
+				cert, err := tls.LoadX509KeyPair(
+					filepath.Join(tt.caDir, caIssuedDir, tt.cert+".crt"),
+					filepath.Join(tt.caDir, caIssuedDir, tt.cert+".key"))
+				if err != nil {
+					t.Fatalf("加载客户端证书失败: %v", err)
+				}
+				// 即使服务器不信任签发者也出示证书
+				clientTLS.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+			resp, err := client.Get(srv.URL)
+			if !tt.wantOK {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("握手应失败")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("请求失败: %v", err)
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, 64)
+			n, _ := resp.Body.Read(buf)
+			if got := string(buf[:n]); got != tt.identity {
+				t.Errorf("证书身份应为 %q，得到 %q", tt.identity, got)
+			}
+		})
+	}
+}
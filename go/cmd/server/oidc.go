@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig OpenID Connect 登录配置
+type OIDCConfig struct {
+	Issuer         string   `yaml:"issuer" json:"issuer"`
+	ClientID       string   `yaml:"clientId" json:"clientId"`
+	ClientSecret   string   `yaml:"clientSecret" json:"clientSecret"`
+	RedirectURL    string   `yaml:"redirectUrl" json:"redirectUrl"` // 默认为 <访问地址>/_edge/oidc/callback
+	Scopes         []string `yaml:"scopes" json:"scopes"`
+	AllowedEmails  []string `yaml:"allowedEmails" json:"allowedEmails"`
+	AllowedDomains []string `yaml:"allowedDomains" json:"allowedDomains"`
+	AllowedGroups  []string `yaml:"allowedGroups" json:"allowedGroups"`
+	GroupsClaim    string   `yaml:"groupsClaim" json:"groupsClaim"` // 默认 groups
+	SessionTTL     int      `yaml:"sessionTtl" json:"sessionTtl"`   // 会话有效期(秒)，默认 8 小时
+}
+
+// oidcProvider 发现文档和签名公钥，首次使用时加载
+type oidcProvider struct {
+	issuer string
+	client *http.Client
+
+	mu            sync.Mutex
+	authURL       string
+	tokenURL      string
+	jwksURL       string
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func newOIDCProvider(issuer string) *oidcProvider {
+	return &oidcProvider{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover 读取 /.well-known/openid-configuration
+func (p *oidcProvider) discover() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.authURL != "" {
+		return nil
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return fmt.Errorf("读取OIDC发现文档失败: %v", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return fmt.Errorf("OIDC issuer 不匹配: %s", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return fmt.Errorf("OIDC发现文档缺少必要的端点")
+	}
+	p.authURL, p.tokenURL, p.jwksURL = doc.AuthorizationEndpoint, doc.TokenEndpoint, doc.JWKSURI
+	return nil
+}
+
+func (p *oidcProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回 %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// authCodeURL 构造授权地址 (带 PKCE)
+func (p *oidcProvider) authCodeURL(cfg *OIDCConfig, redirectURL, state, nonce, verifier string) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+	return p.authURL + sep + q.Encode(), nil
+}
+
+// exchange 用授权码换取 ID Token
+func (p *oidcProvider) exchange(cfg *OIDCConfig, redirectURL, code, verifier string) (string, error) {
+	if err := p.discover(); err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	resp, err := p.client.PostForm(p.tokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("请求令牌端点失败: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("令牌端点返回 %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return "", fmt.Errorf("令牌端点未返回 id_token")
+	}
+	return token.IDToken, nil
+}
+
+// publicKey 按 kid 查找签名公钥，未知 kid 时重新获取 JWKS (最多每分钟一次)
+func (p *oidcProvider) publicKey(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < time.Minute && p.keys != nil {
+		return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(p.jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("读取JWKS失败: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未知的签名密钥: %s", kid)
+}
+
+// jsonWebKey JWKS 中的公钥 (RSA 或 P-256)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("RSA 公钥格式错误")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("EC 公钥格式错误")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+}
+
+// idTokenClaims ID Token 中使用的声明
+type idTokenClaims struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// verifyIDToken 校验 ID Token 的签名、issuer、audience、有效期和 nonce
+func (p *oidcProvider) verifyIDToken(cfg *OIDCConfig, rawToken, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ID Token 格式错误")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID Token 签名格式错误")
+	}
+	key, err := p.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return nil, fmt.Errorf("ID Token 签名无效")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 ||
+			!ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return nil, fmt.Errorf("ID Token 签名无效")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的签名算法: %s", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("ID Token issuer 不匹配: %s", iss)
+	}
+	if !audienceContains(claims["aud"], cfg.ClientID) {
+		return nil, fmt.Errorf("ID Token audience 不匹配")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-time.Minute).Unix() > int64(exp) {
+		return nil, fmt.Errorf("ID Token 已过期")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, fmt.Errorf("ID Token nonce 不匹配")
+	}
+
+	result := &idTokenClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		result.Email = "" // 未验证的邮箱不参与授权
+	}
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	if groups, ok := claims[groupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				result.Groups = append(result.Groups, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("ID Token 格式错误")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("ID Token 格式错误")
+	}
+	return nil
+}
+
+// audienceContains aud 可以是字符串或字符串数组
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"tunnel/internal/testutil"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := testutil.NewMockIdP(t)
+	cfg := &OIDCConfig{Issuer: idp.Issuer, ClientID: idp.ClientID}
+	provider := newOIDCProvider(idp.Issuer)
+	if err := provider.discover(); err != nil {
+		t.Fatalf("读取发现文档失败: %v", err)
+	}
+
+	claims := func(modify func(c map[string]interface{})) map[string]interface{} {
+		c := idp.DefaultClaims("alice@example.com", "nonce-1")
+		c["groups"] = []string{"staff", "ops"}
+		if modify != nil {
+			modify(c)
+		}
+		return c
+	}
+
+	valid := idp.Sign(claims(nil))
+	got, err := provider.verifyIDToken(cfg, valid, "nonce-1")
+	if err != nil {
+		t.Fatalf("有效的 ID Token 被拒绝: %v", err)
+	}
+	if got.Email != "alice@example.com" || strings.Join(got.Groups, ",") != "staff,ops" {
+		t.Errorf("解析的声明不正确: %+v", got)
+	}
+
+	// aud 可以是数组
+	if _, err := provider.verifyIDToken(cfg, idp.Sign(claims(func(c map[string]interface{}) {
+		c["aud"] = []string{"other", idp.ClientID}
+	})), "nonce-1"); err != nil {
+		t.Errorf("aud 数组包含 clientId 时应通过: %v", err)
+	}
+
+	// 未验证的邮箱不参与授权
+	got, err = provider.verifyIDToken(cfg, idp.Sign(claims(func(c map[string]interface{}) {
+		c["email_verified"] = false
+	})), "nonce-1")
+	if err != nil || got.Email != "" {
+		t.Errorf("email_verified 为 false 时应忽略邮箱，得到 %+v, %v", got, err)
+	}
+
+	parts := strings.Split(valid, ".")
+	tampered := idp.Sign(claims(func(c map[string]interface{}) { c["email"] = "admin@example.com" }))
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+	}{
+		{"nonce 不匹配", valid, "nonce-2"},
+		{"issuer 不匹配", idp.Sign(claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })), "nonce-1"},
+		{"audience 不匹配", idp.Sign(claims(func(c map[string]interface{}) { c["aud"] = "other" })), "nonce-1"},
+		{"aud 数组不含 clientId", idp.Sign(claims(func(c map[string]interface{}) { c["aud"] = []string{"a", "b"} })), "nonce-1"},
+		{"已过期", idp.Sign(claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() })), "nonce-1"},
+		{"没有 exp", idp.Sign(claims(func(c map[string]interface{}) { delete(c, "exp") })), "nonce-1"},
+		{"签名不匹配", parts[0] + "." + strings.Split(tampered, ".")[1] + "." + parts[2], "nonce-1"},
+		{"未知的 kid", idp.SignWith(map[string]string{"alg": "RS256", "kid": "unknown"}, claims(nil)), "nonce-1"},
+		{"alg none", idp.SignWith(map[string]string{"alg": "none", "kid": testutil.MockIdPKeyID}, claims(nil)), "nonce-1"},
+		{"没有签名", parts[0] + "." + parts[1] + ".", "nonce-1"},
+		{"格式错误", "not-a-jwt", "nonce-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.verifyIDToken(cfg, tt.token, tt.nonce); err == nil {
+				t.Error("应拒绝该 ID Token")
+			}
+		})
+	}
+}
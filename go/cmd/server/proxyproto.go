@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"tunnel/internal/proxyproto"
+)
+
+// 可启用 PROXY protocol 的监听器
+var proxyProtocolListeners = []string{"http", "https", "ws", "wss"}
+
+// validateProxyProtocol 检查 proxyProtocol 配置中的监听器名称
+func (s *TunnelServer) validateProxyProtocol() error {
+	for _, kind := range s.config.Server.ProxyProtocol {
+		if !containsString(proxyProtocolListeners, strings.ToLower(kind)) {
+			return fmt.Errorf("未知的 PROXY protocol 监听器: %s (可选 %s)", kind, strings.Join(proxyProtocolListeners, "/"))
+		}
+	}
+	return nil
+}
+
+// wrapProxyProtocol 为启用了 PROXY protocol 的监听器解析头部
+func (s *TunnelServer) wrapProxyProtocol(kind string, listener net.Listener) net.Listener {
+	enabled := false
+	for _, name := range s.config.Server.ProxyProtocol {
+		if strings.EqualFold(name, kind) {
+			enabled = true
+			break
+		}
+	}
+	if !enabled {
+		return listener
+	}
+
+	serverLog.Info(strings.ToUpper(kind)+"监听器接受 PROXY protocol 头部", "addr", listener.Addr().String())
+	pl := &proxyproto.Listener{Listener: listener}
+	if len(s.trustedProxies) > 0 {
+		pl.Required = func(addr net.Addr) bool {
+			host, _, err := net.SplitHostPort(addr.String())
+			return err == nil && s.isTrustedProxy(host)
+		}
+	}
+	return pl
+}
+
+type connContextKey struct{}
+
+// withConn 将连接保存到请求上下文 (此时不能读取地址，否则会阻塞 Accept 等待 PROXY 头部)
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// connLocalAddr 返回访客连接的目标地址 (PROXY protocol 头部中的地址优先)
+func connLocalAddr(r *http.Request) string {
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		return c.LocalAddr().String()
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// clientAddr 返回访客地址 (含端口)，经可信代理转发时端口未知，记为 0
+func (s *TunnelServer) clientAddr(r *http.Request) string {
+	ip := s.clientIP(r)
+	port := "0"
+	if ip == remoteIP(r) {
+		if _, p, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			port = p
+		}
+	}
+	return net.JoinHostPort(ip, port)
+}
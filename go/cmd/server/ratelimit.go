@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限流维度
+const (
+	RateLimitPerIP    = "ip"    // 每个访客地址一个令牌桶
+	RateLimitPerRoute = "route" // 每个客户端注册的主机名一个令牌桶 (未注册主机名的客户端共用 *)
+	RateLimitPerToken = "token" // 每个客户端令牌 (或证书身份) 一个令牌桶
+)
+
+// 空闲令牌桶的回收间隔
+const rateLimitGCInterval = time.Minute
+
+// 每个地址的认证失败限制，超过后在计算令牌哈希之前拒绝
+const (
+	authFailureRate  = "10/m"
+	authFailureBurst = 10
+)
+
+// RateLimitConfig 令牌桶限流规则
+type RateLimitConfig struct {
+	Name      string   `yaml:"name" json:"name"`
+	Per       string   `yaml:"per" json:"per"`             // ip、route 或 token，默认 ip
+	Hostnames []string `yaml:"hostnames" json:"hostnames"` // 按客户端注册的主机名匹配，为空表示所有主机名，支持 * 通配符
+	Tokens    []string `yaml:"tokens" json:"tokens"`       // 令牌名称或证书身份，为空表示所有客户端
+	Rate      string   `yaml:"rate" json:"rate"`           // 如 "10/s"、"600/m"、"1000/h"
+	Burst     int      `yaml:"burst" json:"burst"`         // 桶容量，默认等于每秒速率 (至少 1)
+}
+
+// rateLimiter 一条限流规则及其令牌桶
+type rateLimiter struct {
+	name      string
+	per       string
+	hostnames []string
+	tokens    []string
+	rate      float64 // 每秒补充的令牌数
+	burst     float64
+
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	allowed  uint64
+	rejected uint64
+}
+
+// tokenBucket 单个限流对象的令牌桶
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	limited  bool // 上一次请求是否被拒绝，用于只记录一次日志
+	rejected uint64
+}
+
+// parseRate 解析 "次数/单位" 形式的速率，返回每秒次数
+func parseRate(value string) (float64, error) {
+	count, unit, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		unit = "s"
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(count), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("无效的速率: %q", value)
+	}
+	switch strings.TrimSpace(unit) {
+	case "s", "sec", "second":
+		return n, nil
+	case "m", "min", "minute":
+		return n / 60, nil
+	case "h", "hour":
+		return n / 3600, nil
+	}
+	return 0, fmt.Errorf("无效的速率单位: %q (可用 s、m、h)", value)
+}
+
+// newRateLimiter 解析限流规则
+func newRateLimiter(cfg RateLimitConfig) (*rateLimiter, error) {
+	l := &rateLimiter{
+		name:    cfg.Name,
+		per:     strings.ToLower(strings.TrimSpace(cfg.Per)),
+		tokens:  cfg.Tokens,
+		buckets: make(map[string]*tokenBucket),
+	}
+	if l.per == "" {
+		l.per = RateLimitPerIP
+	}
+	switch l.per {
+	case RateLimitPerIP, RateLimitPerRoute, RateLimitPerToken:
+	default:
+		return nil, fmt.Errorf("未知的限流维度: %s (可用 ip、route、token)", cfg.Per)
+	}
+	for _, host := range cfg.Hostnames {
+		l.hostnames = append(l.hostnames, normalizeHostname(host))
+	}
+
+	var err error
+	if l.rate, err = parseRate(cfg.Rate); err != nil {
+		return nil, err
+	}
+	l.burst = float64(cfg.Burst)
+	if cfg.Burst < 0 {
+		return nil, fmt.Errorf("burst 不能为负数")
+	}
+	if l.burst == 0 {
+		l.burst = math.Max(1, math.Ceil(l.rate))
+	}
+	if l.name == "" {
+		l.name = fmt.Sprintf("%s %s", l.per, cfg.Rate)
+	}
+	return l, nil
+}
+
+// matches 判断规则是否作用于该路由和客户端
+func (l *rateLimiter) matches(route, identity string) bool {
+	if len(l.tokens) > 0 && !containsString(l.tokens, identity) {
+		return false
+	}
+	if len(l.hostnames) == 0 {
+		return true
+	}
+	for _, pattern := range l.hostnames {
+		if matchHostname(pattern, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// take 从 key 对应的令牌桶中取出一个令牌，失败时返回需要等待的时间
+func (l *rateLimiter) take(key string, now time.Time) (bool, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		l.allowed++
+		return true, 0, false
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	first := !b.limited
+	b.limited = true
+	b.rejected++
+	l.rejected++
+	return false, wait, first
+}
+
+// limited 检查 key 的令牌桶是否已经用完，不消耗令牌
+func (l *rateLimiter) limited(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	return ok && b.tokens+now.Sub(b.last).Seconds()*l.rate < 1
+}
+
+// gc 删除已经补满的令牌桶，补满后与新建的桶没有区别
+func (l *rateLimiter) gc(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// loadRateLimits 解析限流规则
+func (s *TunnelServer) loadRateLimits() error {
+	s.rateLimiters = nil
+	for i, cfg := range s.config.RateLimits {
+		limiter, err := newRateLimiter(cfg)
+		if err != nil {
+			return fmt.Errorf("限流规则 #%d 配置错误: %v", i+1, err)
+		}
+		s.rateLimiters = append(s.rateLimiters, limiter)
+	}
+	if len(s.rateLimiters) > 0 {
+		authLog.Info("已加载限流规则", "count", len(s.rateLimiters))
+	}
+	go s.rateLimitGC()
+	return nil
+}
+
+// rateLimitGC 定期回收空闲的令牌桶
+func (s *TunnelServer) rateLimitGC() {
+	ticker := time.NewTicker(rateLimitGCInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, limiter := range s.rateLimiters {
+			limiter.gc(now)
+		}
+		s.authFailureLimiter.gc(now)
+	}
+}
+
+// allowAuthAttempt 访客地址认证失败次数过多时返回 429，避免无需认证就能让服务器反复计算令牌哈希
+func (s *TunnelServer) allowAuthAttempt(w http.ResponseWriter, r *http.Request) bool {
+	ip := s.clientIP(r)
+	if !s.authFailureLimiter.limited(ip, time.Now()) {
+		return true
+	}
+	authLog.Info("拒绝认证: 失败次数过多", "remote", ip, "path", r.URL.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/s.authFailureLimiter.rate))))
+	http.Error(w, "认证失败次数过多，请稍后再试", http.StatusTooManyRequests)
+	return false
+}
+
+// recordAuthFailure 记录一次认证失败
+func (s *TunnelServer) recordAuthFailure(r *http.Request) {
+	s.authFailureLimiter.take(s.clientIP(r), time.Now())
+}
+
+// checkRateLimit 依次检查匹配的限流规则，超限时返回 429 并返回 false。
+// 按客户端注册的路由匹配和计数，与访客发送的 Host 无关
+func (s *TunnelServer) checkRateLimit(w http.ResponseWriter, r *http.Request, client *Client) bool {
+	if len(s.rateLimiters) == 0 {
+		return true
+	}
+	route := usageRouteKey(client)
+	identity := client.Identity
+	now := time.Now()
+
+	for _, limiter := range s.rateLimiters {
+		if !limiter.matches(route, identity) {
+			continue
+		}
+		var key string
+		switch limiter.per {
+		case RateLimitPerIP:
+			key = s.clientIP(r)
+		case RateLimitPerRoute:
+			key = route
+		case RateLimitPerToken:
+			key = identity
+			if key == "" {
+				key = client.ID // 未认证的客户端各自限流
+			}
+		}
+		ok, wait, first := limiter.take(key, now)
+		if ok {
+			continue
+		}
+		if first {
+			authLog.Info("限流: 超过限制", "remote", s.clientIP(r), "route", route, "path", r.URL.Path, "rule", limiter.name, "per", limiter.per, "key", key)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "请求过于频繁，请稍后再试", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// adminListRateLimits 限流规则和令牌桶状态 (桶的键包含访客地址和令牌名称，只在管理接口上提供)
+func (s *TunnelServer) adminListRateLimits(w http.ResponseWriter) {
+	now := time.Now()
+	result := make([]map[string]interface{}, 0, len(s.rateLimiters))
+	for _, limiter := range s.rateLimiters {
+		limiter.mu.Lock()
+		buckets := make([]map[string]interface{}, 0, len(limiter.buckets))
+		for key, b := range limiter.buckets {
+			tokens := math.Min(limiter.burst, b.tokens+now.Sub(b.last).Seconds()*limiter.rate)
+			buckets = append(buckets, map[string]interface{}{
+				"key":      key,
+				"tokens":   math.Floor(tokens*100) / 100,
+				"limited":  tokens < 1,
+				"rejected": b.rejected,
+				"lastSeen": b.last,
+			})
+		}
+		result = append(result, map[string]interface{}{
+			"name":      limiter.name,
+			"per":       limiter.per,
+			"hostnames": limiter.hostnames,
+			"tokens":    limiter.tokens,
+			"rate":      limiter.rate,
+			"burst":     limiter.burst,
+			"allowed":   limiter.allowed,
+			"rejected":  limiter.rejected,
+			"buckets":   buckets,
+		})
+		limiter.mu.Unlock()
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i]["key"].(string) < buckets[j]["key"].(string) })
+	}
+
+	adminJSON(w, http.StatusOK, result)
+}
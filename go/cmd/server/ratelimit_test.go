@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitKeyedByRoute(t *testing.T) {
+	config := DefaultConfig()
+	config.RateLimits = []RateLimitConfig{
+		{Name: "all", Per: RateLimitPerRoute, Rate: "1/m", Burst: 2},
+		{Name: "example", Per: RateLimitPerRoute, Hostnames: []string{"*.example.com"}, Rate: "1/m", Burst: 1},
+	}
+	s := NewTunnelServer(config)
+	for _, cfg := range config.RateLimits {
+		l, err := newRateLimiter(cfg)
+		if err != nil {
+			t.Fatalf("解析限流规则失败: %v", err)
+		}
+		s.rateLimiters = append(s.rateLimiters, l)
+	}
+	check := func(client *Client, host string) int {
+		rec := httptest.NewRecorder()
+		if !s.checkRateLimit(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil), client) {
+			return rec.Code
+		}
+		return http.StatusOK
+	}
+
+	// 未注册主机名的客户端：访客更换 Host 不能绕过限流，也不会新建令牌桶
+	fallback := &Client{ID: "client_1"}
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if got := check(fallback, fmt.Sprintf("h%d.example.com", i)); got != want {
+			t.Errorf("第 %d 个请求得到 %d, 期望 %d", i+1, got, want)
+		}
+	}
+	all, example := s.rateLimiters[0], s.rateLimiters[1]
+	if len(all.buckets) != 1 || all.buckets["*"] == nil {
+		t.Errorf("应只有 * 的令牌桶，得到 %v", all.buckets)
+	}
+	if len(example.buckets) != 0 {
+		t.Error("hostnames 应按客户端注册的主机名匹配，而不是访客的 Host")
+	}
+
+	// 注册的主机名命中 hostnames
+	routed := &Client{ID: "client_2", Route: TunnelRoute{Hostname: "app.example.com"}}
+	if got := check(routed, "anything.test"); got != http.StatusOK {
+		t.Errorf("第一个请求应通过，得到 %d", got)
+	}
+	if got := check(routed, "other.test"); got != http.StatusTooManyRequests {
+		t.Errorf("app.example.com 超过 example 规则后应限流，得到 %d", got)
+	}
+}
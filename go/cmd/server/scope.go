@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 隧道类型
+const (
+	TunnelTypeHTTP = "http"
+	TunnelTypeTCP  = "tcp"
+	TunnelTypeUDP  = "udp"
+)
+
+// TokenConfig 令牌配置，可以写成单个哈希字符串，也可以写成带作用域的对象
+type TokenConfig struct {
+	Name           string   `yaml:"name" json:"name"`
+	Token          string   `yaml:"token" json:"token"`
+	Hostnames      []string `yaml:"hostnames" json:"hostnames"`
+	TunnelTypes    []string `yaml:"tunnelTypes" json:"tunnelTypes"`
+	Ports          []string `yaml:"ports" json:"ports"`
+	MaxConnections int      `yaml:"maxConnections" json:"maxConnections"`
+	ExpiresAt      string   `yaml:"expiresAt" json:"expiresAt"`
+}
+
+// UnmarshalYAML 支持字符串和对象两种写法
+func (t *TokenConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*t = TokenConfig{Token: value.Value}
+		return nil
+	}
+	type plain TokenConfig
+	return value.Decode((*plain)(t))
+}
+
+// UnmarshalJSON 支持字符串和对象两种写法
+func (t *TokenConfig) UnmarshalJSON(data []byte) error {
+	var token string
+	if err := json.Unmarshal(data, &token); err == nil {
+		*t = TokenConfig{Token: token}
+		return nil
+	}
+	type plain TokenConfig
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// portRange 端口范围 (闭区间)
+type portRange struct {
+	min int
+	max int
+}
+
+// tokenScope 令牌作用域，空字段表示不限制
+type tokenScope struct {
+	hostnames      []string
+	tunnelTypes    []string
+	ports          []portRange
+	maxConnections int
+	expiresAt      time.Time
+}
+
+// TunnelRoute 客户端注册的路由
+type TunnelRoute struct {
+	Hostname   string
+	Type       string
+	RemotePort int
+}
+
+// parseTokenScope 解析令牌作用域配置
+func parseTokenScope(cfg TokenConfig) (tokenScope, error) {
+	scope := tokenScope{maxConnections: cfg.MaxConnections}
+
+	for _, pattern := range cfg.Hostnames {
+		pattern = normalizeHostname(pattern)
+		if pattern == "" {
+			return scope, fmt.Errorf("主机名模式不能为空")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return scope, fmt.Errorf("无效的主机名模式 %q: %v", pattern, err)
+		}
+		scope.hostnames = append(scope.hostnames, pattern)
+	}
+
+	for _, t := range cfg.TunnelTypes {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case TunnelTypeHTTP, TunnelTypeTCP, TunnelTypeUDP:
+			scope.tunnelTypes = append(scope.tunnelTypes, t)
+		default:
+			return scope, fmt.Errorf("未知的隧道类型: %s", t)
+		}
+	}
+
+	for _, p := range cfg.Ports {
+		r, err := parsePortRange(p)
+		if err != nil {
+			return scope, err
+		}
+		scope.ports = append(scope.ports, r)
+	}
+
+	if cfg.MaxConnections < 0 {
+		return scope, fmt.Errorf("maxConnections 不能为负数")
+	}
+
+	if cfg.ExpiresAt != "" {
+		expiresAt, err := parseExpiry(cfg.ExpiresAt)
+		if err != nil {
+			return scope, err
+		}
+		scope.expiresAt = expiresAt
+	}
+
+	return scope, nil
+}
+
+// parsePortRange 解析 "8080" 或 "20000-20100" 格式的端口范围
+func parsePortRange(value string) (portRange, error) {
+	value = strings.TrimSpace(value)
+	lo, hi, found := strings.Cut(value, "-")
+	if !found {
+		hi = lo
+	}
+
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return portRange{}, fmt.Errorf("无效的端口范围 %q", value)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return portRange{}, fmt.Errorf("无效的端口范围 %q", value)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return portRange{}, fmt.Errorf("无效的端口范围 %q", value)
+	}
+	return portRange{min: min, max: max}, nil
+}
+
+// parseExpiry 解析过期时间，支持 RFC3339 和日期格式
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无效的过期时间 %q (应为 RFC3339 或 YYYY-MM-DD)", value)
+}
+
+// normalizeHostname 去掉端口并转为小写
+func normalizeHostname(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// matchHostname 按标签匹配主机名，通配符不跨越 "."
+func matchHostname(pattern, hostname string) bool {
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(hostname, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i := range patternLabels {
+		if ok, _ := path.Match(patternLabels[i], hostLabels[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// expired 检查令牌是否已过期
+func (sc *tokenScope) expired(now time.Time) bool {
+	return !sc.expiresAt.IsZero() && now.After(sc.expiresAt)
+}
+
+// restricted 是否限制了主机名
+func (sc *tokenScope) restricted() bool {
+	return len(sc.hostnames) > 0
+}
+
+// limited 是否有任何限制 (主机名、类型、端口、连接数或过期时间)
+func (sc *tokenScope) limited() bool {
+	return sc.restricted() || len(sc.tunnelTypes) > 0 || len(sc.ports) > 0 || sc.maxConnections > 0 || !sc.expiresAt.IsZero()
+}
+
+// authorize 检查路由是否在令牌作用域内
+func (sc *tokenScope) authorize(route TunnelRoute) error {
+	if sc.expired(time.Now()) {
+		return fmt.Errorf("令牌已于 %s 过期", sc.expiresAt.Format(time.RFC3339))
+	}
+
+	if len(sc.tunnelTypes) > 0 && !containsString(sc.tunnelTypes, route.Type) {
+		return fmt.Errorf("不允许的隧道类型: %s", route.Type)
+	}
+
+	if sc.restricted() {
+		if route.Hostname == "" {
+			return fmt.Errorf("此令牌必须指定主机名")
+		}
+		allowed := false
+		for _, pattern := range sc.hostnames {
+			if matchHostname(pattern, route.Hostname) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("不允许的主机名: %s", route.Hostname)
+		}
+	}
+
+	if route.Type != TunnelTypeHTTP && len(sc.ports) > 0 {
+		allowed := false
+		for _, r := range sc.ports {
+			if route.RemotePort >= r.min && route.RemotePort <= r.max {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("不允许的端口: %d", route.RemotePort)
+		}
+	}
+
+	return nil
+}
+
+// describe 返回作用域的简要描述
+func (sc *tokenScope) describe() string {
+	parts := []string{}
+	if len(sc.hostnames) > 0 {
+		parts = append(parts, "主机名="+strings.Join(sc.hostnames, ","))
+	}
+	if len(sc.tunnelTypes) > 0 {
+		parts = append(parts, "类型="+strings.Join(sc.tunnelTypes, ","))
+	}
+	if len(sc.ports) > 0 {
+		ranges := make([]string, 0, len(sc.ports))
+		for _, r := range sc.ports {
+			if r.min == r.max {
+				ranges = append(ranges, strconv.Itoa(r.min))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d-%d", r.min, r.max))
+			}
+		}
+		parts = append(parts, "端口="+strings.Join(ranges, ","))
+	}
+	if sc.maxConnections > 0 {
+		parts = append(parts, fmt.Sprintf("最大连接=%d", sc.maxConnections))
+	}
+	if !sc.expiresAt.IsZero() {
+		parts = append(parts, "过期="+sc.expiresAt.Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		return "不限制"
+	}
+	return strings.Join(parts, " ")
+}
+
+// containsString 检查切片是否包含字符串
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
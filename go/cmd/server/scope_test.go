@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchHostname(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		hostname string
+		want     bool
+	}{
+		{"app.example.com", "app.example.com", true},
+		{"app.example.com", "api.example.com", false},
+		{"*.example.com", "app.example.com", true},
+		{"*.example.com", "example.com", false},
+		// 通配符不跨越 "."
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "app.example.com.evil.test", false},
+		{"app-*.example.com", "app-dev.example.com", true},
+		{"app-*.example.com", "api-dev.example.com", false},
+		{"*.*.example.com", "a.b.example.com", true},
+	}
+	for _, tt := range tests {
+		if got := matchHostname(tt.pattern, tt.hostname); got != tt.want {
+			t.Errorf("matchHostname(%q, %q) = %v，期望 %v", tt.pattern, tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestTokenScopeAuthorize(t *testing.T) {
+	scope := func(cfg TokenConfig) tokenScope {
+		t.Helper()
+		sc, err := parseTokenScope(cfg)
+		if err != nil {
+			t.Fatalf("解析作用域失败: %v", err)
+		}
+		return sc
+	}
+	hostnames := scope(TokenConfig{Hostnames: []string{"*.dev.example.com", "API.example.com:443"}})
+	types := scope(TokenConfig{TunnelTypes: []string{"tcp"}, Ports: []string{"20000-20100", "2222"}})
+	expired := scope(TokenConfig{ExpiresAt: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+	future := scope(TokenConfig{ExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)})
+
+	tests := []struct {
+		name    string
+		scope   tokenScope
+		route   TunnelRoute
+		allowed bool
+	}{
+		{"不限制", tokenScope{}, TunnelRoute{Type: TunnelTypeHTTP}, true},
+		{"通配主机名", hostnames, TunnelRoute{Hostname: "app.dev.example.com", Type: TunnelTypeHTTP}, true},
+		{"主机名模式去掉端口并转为小写", hostnames, TunnelRoute{Hostname: "api.example.com", Type: TunnelTypeHTTP}, true},
+		{"通配符不跨越标签", hostnames, TunnelRoute{Hostname: "a.b.dev.example.com", Type: TunnelTypeHTTP}, false},
+		{"不允许的主机名", hostnames, TunnelRoute{Hostname: "app.example.com", Type: TunnelTypeHTTP}, false},
+		{"限制主机名时必须指定", hostnames, TunnelRoute{Type: TunnelTypeHTTP}, false},
+		{"允许的类型和端口范围", types, TunnelRoute{Type: TunnelTypeTCP, RemotePort: 20050}, true},
+		{"允许的单个端口", types, TunnelRoute{Type: TunnelTypeTCP, RemotePort: 2222}, true},
+		{"端口超出范围", types, TunnelRoute{Type: TunnelTypeTCP, RemotePort: 20101}, false},
+		{"不允许的类型", types, TunnelRoute{Type: TunnelTypeHTTP}, false},
+		{"已过期", expired, TunnelRoute{Type: TunnelTypeHTTP}, false},
+		{"未过期", future, TunnelRoute{Type: TunnelTypeHTTP}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.scope.authorize(tt.route)
+			if (err == nil) != tt.allowed {
+				t.Errorf("authorize(%+v) = %v，期望允许 %v", tt.route, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestParseTokenScopeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  TokenConfig
+	}{
+		{"空主机名", TokenConfig{Hostnames: []string{" "}}},
+		{"无效的主机名模式", TokenConfig{Hostnames: []string{"[app.example.com"}}},
+		{"未知的隧道类型", TokenConfig{TunnelTypes: []string{"quic"}}},
+		{"端口越界", TokenConfig{Ports: []string{"0-80"}}},
+		{"端口范围颠倒", TokenConfig{Ports: []string{"9000-8000"}}},
+		{"负的连接数", TokenConfig{MaxConnections: -1}},
+		{"无效的过期时间", TokenConfig{ExpiresAt: "tomorrow"}},
+	}
+	for _, tt := range tests {
+		if _, err := parseTokenScope(tt.cfg); err == nil {
+			t.Errorf("%s: 应返回错误", tt.name)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限速写入时每次写入的大小
+const throttleChunkSize = 16 * 1024
+
+// byteLimiter 按字节计的令牌桶，容量为一秒的流量
+type byteLimiter struct {
+	rate float64 // 每秒字节数
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(rate float64) *byteLimiter {
+	return &byteLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// wait 预留 n 字节并等待到可以发送为止，多个请求共享同一个限速
+func (l *byteLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// idle 令牌桶已满，与新建的限速器等价，可以回收
+func (l *byteLimiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens+now.Sub(l.last).Seconds()*l.rate >= l.rate
+}
+
+// trafficShaper 一个方向上的限速器和吞吐量统计
+type trafficShaper struct {
+	limiters []*byteLimiter
+	meter    *throughputMeter
+}
+
+// transfer 等待所有限速器后记录 n 字节
+func (t trafficShaper) transfer(n int) {
+	for _, l := range t.limiters {
+		l.wait(n)
+	}
+	t.meter.add(n)
+}
+
+// reader 读取时分块限速，访客上传过快时由 TCP 反压减速
+func (t trafficShaper) reader(r io.Reader) io.Reader {
+	return &shapedReader{r: r, shaper: t}
+}
+
+type shapedReader struct {
+	r      io.Reader
+	shaper trafficShaper
+}
+
+func (s *shapedReader) Read(p []byte) (int, error) {
+	if len(s.shaper.limiters) > 0 && len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.shaper.transfer(n)
+	}
+	return n, err
+}
+
+// write 分块写入数据，每块写入前等待限速器
+func (t trafficShaper) write(w io.Writer, data []byte) error {
+	if len(t.limiters) == 0 {
+		_, err := w.Write(data)
+		t.meter.add(len(data))
+		return err
+	}
+	flusher, _ := w.(http.Flusher)
+	for len(data) > 0 {
+		n := min(len(data), throttleChunkSize)
+		t.transfer(n)
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// throughputMeter 最近几秒的平均吞吐量
+type throughputMeter struct {
+	mu      sync.Mutex
+	buckets [throughputWindow + 1]int64 // 按秒环形计数，多出的一格是当前未结束的一秒
+	second  int64
+	total   int64
+}
+
+// 吞吐量统计窗口 (秒)
+const throughputWindow = 5
+
+func (m *throughputMeter) advance(now int64) {
+	if now-m.second > int64(len(m.buckets)) {
+		m.buckets = [throughputWindow + 1]int64{}
+	} else {
+		for s := m.second + 1; s <= now; s++ {
+			m.buckets[s%int64(len(m.buckets))] = 0
+		}
+	}
+	m.second = now
+}
+
+func (m *throughputMeter) add(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advance(time.Now().Unix())
+	m.buckets[m.second%int64(len(m.buckets))] += int64(n)
+	m.total += int64(n)
+}
+
+// rate 最近完整几秒的平均每秒字节数和累计字节数
+func (m *throughputMeter) rate() (float64, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advance(time.Now().Unix())
+	var sum int64
+	for i, b := range m.buckets {
+		if int64(i) != m.second%int64(len(m.buckets)) {
+			sum += b
+		}
+	}
+	return float64(sum) / throughputWindow, m.total
+}
+
+// parseByteRate 解析带宽，如 "5Mbit"、"5Mbps"、"512KB/s"，返回每秒字节数
+func parseByteRate(value string) (float64, error) {
+	s := strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	lower := strings.ToLower(s)
+	bits := false
+	switch {
+	case strings.HasSuffix(lower, "bps"):
+		s, bits = s[:len(s)-3], true
+	case strings.HasSuffix(lower, "bit"):
+		s, bits = s[:len(s)-3], true
+	}
+	if bits {
+		// 比特单位使用十进制前缀
+		n, mult, err := splitUnit(s)
+		if err != nil {
+			return 0, fmt.Errorf("无效的带宽: %q", value)
+		}
+		factor, ok := map[string]float64{"": 1, "k": 1e3, "m": 1e6, "g": 1e9}[mult]
+		if !ok || n <= 0 {
+			return 0, fmt.Errorf("无效的带宽: %q", value)
+		}
+		return n * factor / 8, nil
+	}
+	size, err := parseByteSize(s)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("无效的带宽: %q", value)
+	}
+	return float64(size), nil
+}
+
+// parseByteSize 解析字节数，如 "50GB"、"1.5TiB"、"1024"，KB/MB/GB/TB 按 1024 进位
+func parseByteSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "b")
+	s = strings.TrimSuffix(s, "i")
+	n, mult, err := splitUnit(s)
+	if err != nil {
+		return 0, fmt.Errorf("无效的大小: %q", value)
+	}
+	factor, ok := map[string]float64{"": 1, "k": 1 << 10, "m": 1 << 20, "g": 1 << 30, "t": 1 << 40}[mult]
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("无效的大小: %q", value)
+	}
+	return int64(n * factor), nil
+}
+
+// splitUnit 拆分数字和单位前缀
+func splitUnit(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	i := len(s)
+	for i > 0 && (s[i-1] < '0' || s[i-1] > '9') && s[i-1] != '.' {
+		i--
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s[:i]), 64)
+	return n, strings.ToLower(strings.TrimSpace(s[i:])), err
+}
+
+// formatBytes 以 1024 进位格式化字节数
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
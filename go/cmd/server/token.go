@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id 令牌哈希参数 (参考 OWASP 推荐值)
+const (
+	tokenHashPrefix = "$argon2id$"
+	argonTime       = 2
+	argonMemory     = 19 * 1024
+	argonThreads    = 1
+	argonKeyLen     = 32
+	argonSaltLen    = 16
+	tokenRandomLen  = 32
+	tokenPrefix     = "tk_"
+)
+
+// tokenHash 解析后的 argon2id 哈希
+type tokenHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// authToken 配置中的一个令牌
+type authToken struct {
+	name   string
+	hash   *tokenHash
+	digest []byte // 明文令牌的 SHA-256 摘要 (兼容旧配置)
+	scope  tokenScope
+	// 通过管理接口创建，保存在管理状态文件中
+	managed bool
+}
+
+// GenerateToken 生成随机令牌 tk_<ID>.<密钥>。ID 是生成哈希时使用的盐值 (本来就在哈希中公开)，
+// 服务器按 ID 找到对应的哈希，每次认证只计算一次 argon2id
+func GenerateToken() (string, error) {
+	id := make([]byte, argonSaltLen)
+	secret := make([]byte, tokenRandomLen)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("生成随机令牌失败: %v", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("生成随机令牌失败: %v", err)
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(id) + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// tokenID 解析令牌中的 ID (即哈希的盐值)，旧格式和自定义的令牌没有 ID
+func tokenID(token string) ([]byte, bool) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return nil, false
+	}
+	id, _, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, false
+	}
+	salt, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil || len(salt) != argonSaltLen {
+		return nil, false
+	}
+	return salt, true
+}
+
+// HashToken 生成令牌的 argon2id 哈希 (PHC 字符串格式)，带 ID 的令牌使用 ID 作为盐值
+func HashToken(token string) (string, error) {
+	salt, ok := tokenID(token)
+	if !ok {
+		salt = make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("生成盐值失败: %v", err)
+		}
+	}
+
+	key := argon2.IDKey([]byte(token), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		tokenHashPrefix, argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// isTokenHash 判断配置值是否为令牌哈希
+func isTokenHash(value string) bool {
+	return strings.HasPrefix(value, tokenHashPrefix)
+}
+
+// parseTokenHash 解析 $argon2id$v=19$m=...,t=...,p=...$salt$key 格式的哈希
+func parseTokenHash(encoded string) (*tokenHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("无效的令牌哈希格式")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, fmt.Errorf("无效的哈希版本: %v", err)
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("不支持的 argon2 版本: %d", version)
+	}
+
+	h := &tokenHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("无效的哈希参数: %v", err)
+	}
+	if h.memory == 0 || h.time == 0 || h.threads == 0 {
+		return nil, fmt.Errorf("无效的哈希参数: %s", parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("无效的盐值: %v", err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("无效的哈希值: %v", err)
+	}
+	if len(h.key) == 0 {
+		return nil, fmt.Errorf("哈希值为空")
+	}
+
+	return h, nil
+}
+
+// verify 以常量时间比较令牌与哈希
+func (h *tokenHash) verify(token string) bool {
+	key := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// describe 返回不含密钥信息的哈希描述
+func (h *tokenHash) describe() string {
+	return fmt.Sprintf("argon2id (m=%d,t=%d,p=%d)", h.memory, h.time, h.threads)
+}
+
+// parseAuthToken 解析配置中的令牌，支持哈希和旧的明文格式
+func parseAuthToken(cfg TokenConfig) (*authToken, error) {
+	scope, err := parseTokenScope(cfg)
+	if err != nil {
+		return nil, err
+	}
+	token := &authToken{name: cfg.Name, scope: scope}
+
+	value := cfg.Token
+	// 示例配置中的占位值 (如 "$argon2id$<run: tunnel-server token add>")，替换前拒绝启动
+	if strings.Contains(value, "<") && strings.HasSuffix(value, ">") {
+		return nil, fmt.Errorf("令牌是占位值 %q，请使用 'tunnel-server token add' 生成令牌并替换为输出的哈希", value)
+	}
+	if isTokenHash(value) {
+		h, err := parseTokenHash(value)
+		if err != nil {
+			return nil, err
+		}
+		token.hash = h
+		return token, nil
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("令牌为空")
+	}
+	digest := sha256.Sum256([]byte(value))
+	token.digest = digest[:]
+	return token, nil
+}
+
+// matches 检查令牌是否匹配
+func (t *authToken) matches(token string) bool {
+	if t.hash != nil {
+		return t.hash.verify(token)
+	}
+	// 比较摘要而非明文，避免泄露令牌长度
+	digest := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(digest[:], t.digest) == 1
+}
+
+// findToken 查找匹配的令牌。带 ID 的令牌只校验盐值与 ID 相同的哈希，
+// 旧格式的令牌需要依次校验所有哈希 (由认证失败的限制保护)
+func findToken(tokens []*authToken, value string) *authToken {
+	id, hasID := tokenID(value)
+	for _, t := range tokens {
+		if hasID && t.hash != nil && !bytes.Equal(t.hash.salt, id) {
+			continue
+		}
+		if t.matches(value) {
+			return t
+		}
+	}
+	return nil
+}
+
+// label 返回令牌名称，也是限流、用量、配额和带宽限制中的客户端身份。
+// 未命名的令牌用哈希盐值的前缀区分 (盐值是随机数，不含令牌信息，可以在配置中搜索到)，
+// 明文令牌用摘要的前缀区分
+func (t *authToken) label() string {
+	if t.name != "" {
+		return t.name
+	}
+	if t.hash != nil {
+		salt := base64.RawStdEncoding.EncodeToString(t.hash.salt)
+		return "未命名令牌-" + salt[:min(len(salt), 8)]
+	}
+	return fmt.Sprintf("未命名令牌-%x", t.digest[:4])
+}
+
+// describe 返回不含密钥信息的令牌描述
+func (t *authToken) describe() string {
+	kind := "明文令牌 (不安全，请使用 token hash 转换)"
+	if t.hash != nil {
+		kind = t.hash.describe()
+	}
+	return fmt.Sprintf("%s %s [作用域: %s]", t.label(), kind, t.scope.describe())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestTokenLabel(t *testing.T) {
+	parse := func(cfg TokenConfig) *authToken {
+		t.Helper()
+		token, err := parseAuthToken(cfg)
+		if err != nil {
+			t.Fatalf("解析令牌失败: %v", err)
+		}
+		return token
+	}
+	hash1, _ := HashToken("token-1")
+	hash2, _ := HashToken("token-2")
+
+	if got := parse(TokenConfig{Name: "ci", Token: hash1}).label(); got != "ci" {
+		t.Errorf("有名称时应使用名称，得到 %q", got)
+	}
+
+	// 未命名的令牌各自有稳定的身份，不共享限流和用量
+	a, b := parse(TokenConfig{Token: hash1}), parse(TokenConfig{Token: hash2})
+	if a.label() == b.label() {
+		t.Errorf("不同的未命名令牌应有不同的身份，都为 %q", a.label())
+	}
+	if a.label() != parse(TokenConfig{Token: hash1}).label() {
+		t.Error("同一哈希的身份应在重启后保持不变")
+	}
+	salt := strings.Split(hash1, "$")[4]
+	if a.label() != "未命名令牌-"+salt[:8] {
+		t.Errorf("身份应为盐值前缀 (可在配置中搜索)，得到 %q", a.label())
+	}
+
+	p1, p2 := parse(TokenConfig{Token: "plain-1"}), parse(TokenConfig{Token: "plain-2"})
+	if p1.label() == p2.label() || strings.Contains(p1.label(), "plain") {
+		t.Errorf("明文令牌应以摘要前缀区分且不包含明文，得到 %q / %q", p1.label(), p2.label())
+	}
+}
+
+func TestFindTokenByID(t *testing.T) {
+	parse := func(value string) *authToken {
+		t.Helper()
+		hash, err := HashToken(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := parseAuthToken(TokenConfig{Token: hash})
+		if err != nil {
+			t.Fatalf("解析令牌失败: %v", err)
+		}
+		return token
+	}
+	plain, err := GenerateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := tokenID(plain)
+	if !ok {
+		t.Fatalf("生成的令牌应带有 ID: %s", plain)
+	}
+	issued := parse(plain)
+	if string(issued.hash.salt) != string(id) {
+		t.Error("带 ID 的令牌应使用 ID 作为哈希的盐值")
+	}
+
+	legacy := parse("legacy-token")
+	tokens := []*authToken{legacy, issued}
+	if findToken(tokens, plain) != issued || findToken(tokens, "legacy-token") != legacy {
+		t.Error("应找到匹配的令牌")
+	}
+
+	// 带 ID 的令牌只校验 ID 对应的哈希：即使另一个哈希 (随机盐值) 的明文相同也不会被校验
+	other, _ := GenerateToken()
+	shadow, _ := parseAuthToken(TokenConfig{Token: mustHashWithRandomSalt(t, other)})
+	if findToken([]*authToken{shadow}, other) != nil {
+		t.Error("带 ID 的令牌不应校验盐值不同的哈希")
+	}
+	if findToken(tokens, other) != nil || findToken(tokens, plain+"x") != nil {
+		t.Error("不匹配的令牌应被拒绝")
+	}
+}
+
+// mustHashWithRandomSalt 不使用令牌的 ID，模拟旧版本生成的哈希
+func mustHashWithRandomSalt(t *testing.T, token string) string {
+	t.Helper()
+	hash, err := HashToken("legacy-" + token) // 没有 ID，使用随机盐值
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := parseTokenHash(hash)
+	key := argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	parts := strings.Split(hash, "$")
+	parts[5] = base64.RawStdEncoding.EncodeToString(key)
+	return strings.Join(parts, "$")
+}
+
+func TestParseAuthTokenRejectsPlaceholder(t *testing.T) {
+	for _, value := range []string{"$argon2id$<run: tunnel-server token add>", "<run tunnel-server token add>"} {
+		if _, err := parseAuthToken(TokenConfig{Token: value}); err == nil {
+			t.Errorf("占位值 %q 应被拒绝", value)
+		}
+	}
+}
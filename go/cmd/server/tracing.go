@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"tunnel/internal/tracing"
+)
+
+// loadTracing 按配置创建链路追踪，未启用时 s.tracer 为 nil，只透传访客的 traceparent
+func (s *TunnelServer) loadTracing() error {
+	tracer, err := tracing.New(s.config.Tracing, "tunnel-server")
+	if err != nil {
+		return err
+	}
+	s.tracer = tracer
+	if tracer != nil {
+		serverLog.Info("链路追踪已启用", "endpoint", tracer.Endpoint())
+	}
+	return nil
+}
+
+// startEdgeSpan 开始访客请求的跨度，上游 (如 CDN 或调用方) 的 traceparent 作为父上下文
+func (s *TunnelServer) startEdgeSpan(r *http.Request) *tracing.Span {
+	parent, _ := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader))
+	span := s.tracer.Start("edge receive", tracing.SpanKindServer, parent)
+	span.SetAttributes(
+		"http.request.method", r.Method,
+		"url.path", r.URL.Path,
+		"server.address", r.Host,
+		"client.address", s.clientIP(r),
+		"network.protocol.version", r.Proto,
+	)
+	return span
+}
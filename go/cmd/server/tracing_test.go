@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tunnel/internal/testutil"
+	"tunnel/internal/tracing"
+)
+
+// newTracingTestServer 创建导出到模拟收集器 (为 nil 时不启用追踪) 的服务器和一个未指定主机名的客户端，
+// respond 代替隧道客户端处理转发的请求消息并返回响应数据
+func newTracingTestServer(t *testing.T, collector *testutil.MockCollector,
+	respond func(data map[string]interface{}) map[string]interface{}) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	if collector != nil {
+		config.Tracing = tracing.Config{Enabled: true, Endpoint: collector.URL}
+	}
+	s := NewTunnelServer(config)
+	if err := s.loadTracing(); err != nil {
+		t.Fatalf("加载链路追踪失败: %v", err)
+	}
+
+	client := &Client{
+		ID:           "client_test",
+		inMeter:      &throughputMeter{},
+		outMeter:     &throughputMeter{},
+		requestMeter: &throughputMeter{},
+		errorMeter:   &throughputMeter{},
+		sendQueue:    make(chan interface{}, clientSendQueueSize),
+		done:         make(chan struct{}),
+	}
+	s.clients[client.ID] = client
+	t.Cleanup(func() { close(client.done) })
+
+	go func() {
+		for {
+			select {
+			case msg := <-client.sendQueue:
+				request := msg.(map[string]interface{})
+				s.handleHTTPResponse(map[string]interface{}{
+					"type": "http_response",
+					"id":   request["id"],
+					"data": respond(request["data"].(map[string]interface{})),
+				})
+			case <-client.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func TestHandleHTTPRequestPropagatesTraceparent(t *testing.T) {
+	collector := testutil.NewMockCollector(t)
+	const upstream = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var forwarded map[string]interface{}
+	s := newTracingTestServer(t, collector, func(data map[string]interface{}) map[string]interface{} {
+		forwarded = data
+		return map[string]interface{}{"statusCode": float64(http.StatusOK), "body": "ok"}
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.test/path", nil)
+	r.Header.Set(tracing.TraceparentHeader, upstream)
+	rec := httptest.NewRecorder()
+	s.handleHTTPRequest(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("期望 200，得到 %d: %s", rec.Code, rec.Body)
+	}
+	s.tracer.Shutdown()
+
+	edge, send, ret := collector.Span("edge receive"), collector.Span("tunnel send"), collector.Span("response return")
+	if edge == nil || send == nil || ret == nil {
+		t.Fatalf("缺少跨度: %+v", collector.Spans())
+	}
+	if edge.TraceID != upstream[3:35] || edge.ParentID != upstream[36:52] {
+		t.Errorf("edge receive 应以访客的 traceparent 为父上下文，得到 trace=%s parent=%s", edge.TraceID, edge.ParentID)
+	}
+	if send.TraceID != edge.TraceID || send.ParentID != edge.SpanID || ret.ParentID != edge.SpanID {
+		t.Errorf("tunnel send 和 response return 应为 edge receive 的子跨度: %+v %+v", send, ret)
+	}
+	if edge.Service != "tunnel-server" || edge.Attrs["http.response.status_code"] != "200" {
+		t.Errorf("edge receive 的服务名或属性不正确: %+v", edge)
+	}
+
+	// 客户端收到的上下文指向 tunnel send 跨度，访客的 traceparent 请求头不直接转发
+	sc, ok := tracing.ParseTraceparent(forwarded["traceparent"].(string))
+	if !ok || sc.Traceparent() != "00-"+send.TraceID+"-"+send.SpanID+"-01" {
+		t.Errorf("传给客户端的 traceparent 应指向 tunnel send，得到 %v", forwarded["traceparent"])
+	}
+	if headers := forwarded["headers"].(map[string]string); headers["Traceparent"] != "" {
+		t.Errorf("访客的 traceparent 请求头不应原样转发，得到 %q", headers["Traceparent"])
+	}
+}
+
+func TestHandleHTTPRequestStartsTraceWithoutUpstream(t *testing.T) {
+	collector := testutil.NewMockCollector(t)
+	var forwarded map[string]interface{}
+	s := newTracingTestServer(t, collector, func(data map[string]interface{}) map[string]interface{} {
+		forwarded = data
+		return map[string]interface{}{"statusCode": float64(http.StatusOK)}
+	})
+
+	// 无效的 traceparent 按没有上游处理
+	r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+	r.Header.Set(tracing.TraceparentHeader, "garbage")
+	s.handleHTTPRequest(httptest.NewRecorder(), r)
+	s.tracer.Shutdown()
+
+	edge := collector.Span("edge receive")
+	if edge == nil || edge.ParentID != "" {
+		t.Fatalf("没有有效的上游上下文时 edge receive 应为根跨度: %+v", edge)
+	}
+	if sc, ok := tracing.ParseTraceparent(forwarded["traceparent"].(string)); !ok ||
+		sc.Traceparent()[3:35] != edge.TraceID {
+		t.Errorf("传给客户端的 traceparent 应属于新的追踪，得到 %v", forwarded["traceparent"])
+	}
+}
+
+func TestHandleHTTPRequestWithoutTracing(t *testing.T) {
+	const upstream = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var forwarded map[string]interface{}
+	s := newTracingTestServer(t, nil, func(data map[string]interface{}) map[string]interface{} {
+		forwarded = data
+		return map[string]interface{}{"statusCode": float64(http.StatusOK)}
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+	r.Header.Set(tracing.TraceparentHeader, upstream)
+	s.handleHTTPRequest(httptest.NewRecorder(), r)
+	if forwarded["traceparent"] != upstream {
+		t.Errorf("未启用追踪时应把访客的 traceparent 原样传给客户端，得到 %v", forwarded["traceparent"])
+	}
+}
+
+func TestHandleHTTPRequestClientErrorSpan(t *testing.T) {
+	collector := testutil.NewMockCollector(t)
+	s := newTracingTestServer(t, collector, func(data map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"statusCode": float64(http.StatusInternalServerError), "error": "请求本地服务失败"}
+	})
+
+	rec := httptest.NewRecorder()
+	s.handleHTTPRequest(rec, httptest.NewRequest(http.MethodGet, "http://app.test/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("客户端返回错误时期望 502，得到 %d", rec.Code)
+	}
+	s.tracer.Shutdown()
+
+	send, edge := collector.Span("tunnel send"), collector.Span("edge receive")
+	if send == nil || edge == nil {
+		t.Fatalf("缺少跨度: %+v", collector.Spans())
+	}
+	if send.Error != "请求本地服务失败" {
+		t.Errorf("tunnel send 应导出客户端返回的错误，得到 %q", send.Error)
+	}
+	if edge.Error == "" {
+		t.Error("5xx 响应的 edge receive 应标记错误")
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// 用量统计维度
+const (
+	UsageByToken = "token" // 按客户端令牌 (或证书身份)
+	UsageByRoute = "route" // 按客户端注册的主机名 (未注册时为 *)
+)
+
+// 超出配额后的处理方式
+const (
+	QuotaActionReject   = "reject"
+	QuotaActionThrottle = "throttle"
+)
+
+const (
+	usageDateLayout      = "2006-01-02"
+	usageMonthLayout     = "2006-01"
+	anonymousUsageKey    = "anonymous" // 未认证客户端的统计名称
+	defaultUsageInterval = 60
+	defaultThrottleRate  = "1Mbit"
+)
+
+// QuotaConfig 每月流量配额
+type QuotaConfig struct {
+	Name         string   `yaml:"name" json:"name"`
+	Per          string   `yaml:"per" json:"per"`             // token 或 route，默认 token
+	Tokens       []string `yaml:"tokens" json:"tokens"`       // 令牌名称或证书身份，为空表示所有客户端
+	Hostnames    []string `yaml:"hostnames" json:"hostnames"` // 客户端注册的主机名，为空表示所有，支持 * 通配符
+	MonthlyBytes string   `yaml:"monthlyBytes" json:"monthlyBytes"`
+	Action       string   `yaml:"action" json:"action"`             // reject (默认) 或 throttle
+	ThrottleRate string   `yaml:"throttleRate" json:"throttleRate"` // throttle 时的带宽，默认 1Mbit
+}
+
+// usageCounters 一天内某个令牌或主机名的用量
+type usageCounters struct {
+	Requests          int64   `json:"requests"`
+	BytesIn           int64   `json:"bytesIn"`  // 访客发往源站的字节数
+	BytesOut          int64   `json:"bytesOut"` // 源站返回给访客的字节数
+	ConnectionSeconds float64 `json:"connectionSeconds"`
+}
+
+func (c *usageCounters) add(delta usageCounters) {
+	c.Requests += delta.Requests
+	c.BytesIn += delta.BytesIn
+	c.BytesOut += delta.BytesOut
+	c.ConnectionSeconds += delta.ConnectionSeconds
+}
+
+// usageDay 一天的用量，按令牌和主机名分别汇总
+type usageDay struct {
+	Tokens map[string]*usageCounters `json:"tokens"`
+	Routes map[string]*usageCounters `json:"routes"`
+}
+
+func newUsageDay() *usageDay {
+	return &usageDay{Tokens: make(map[string]*usageCounters), Routes: make(map[string]*usageCounters)}
+}
+
+// addTo 累加某个令牌或主机名的用量
+func (d *usageDay) addTo(by, name string, delta usageCounters) {
+	group := d.group(by)
+	counters, ok := group[name]
+	if !ok {
+		counters = &usageCounters{}
+		group[name] = counters
+	}
+	counters.add(delta)
+}
+
+func (d *usageDay) group(by string) map[string]*usageCounters {
+	if by == UsageByRoute {
+		return d.Routes
+	}
+	return d.Tokens
+}
+
+// usageStore 每日用量，定期写入 JSON 文件
+type usageStore struct {
+	path string
+	// 串行写文件 (定期保存和退出时的保存可能同时进行)
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	days map[string]*usageDay
+	// 按月累计的用量，配额检查直接查找，不需要遍历每天的记录；不写入文件，加载时重新计算
+	months map[string]*usageDay
+	dirty  bool
+}
+
+// loadUsageStore 读取用量文件，文件不存在时创建空记录
+func loadUsageStore(path string) (*usageStore, error) {
+	store := &usageStore{path: path, days: make(map[string]*usageDay), months: make(map[string]*usageDay)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取用量文件失败: %v", err)
+	}
+	var file struct {
+		Days map[string]*usageDay `json:"days"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析用量文件失败: %v", err)
+	}
+	for date, day := range file.Days {
+		if day.Tokens == nil {
+			day.Tokens = make(map[string]*usageCounters)
+		}
+		if day.Routes == nil {
+			day.Routes = make(map[string]*usageCounters)
+		}
+		store.days[date] = day
+		month := store.monthTotals(date[:min(len(date), len(usageMonthLayout))])
+		for name, c := range day.Tokens {
+			month.addTo(UsageByToken, name, *c)
+		}
+		for name, c := range day.Routes {
+			month.addTo(UsageByRoute, name, *c)
+		}
+	}
+	return store, nil
+}
+
+// monthTotals 返回某月的累计用量，调用方需持有 mu
+func (u *usageStore) monthTotals(month string) *usageDay {
+	totals, ok := u.months[month]
+	if !ok {
+		totals = newUsageDay()
+		u.months[month] = totals
+	}
+	return totals
+}
+
+// add 累加当天和当月的用量
+func (u *usageStore) add(by, name string, delta usageCounters, now time.Time) {
+	date := now.Format(usageDateLayout)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	day, ok := u.days[date]
+	if !ok {
+		day = newUsageDay()
+		u.days[date] = day
+	}
+	day.addTo(by, name, delta)
+	u.monthTotals(now.Format(usageMonthLayout)).addTo(by, name, delta)
+	u.dirty = true
+}
+
+// monthBytes 某个令牌或主机名在当月的总流量
+func (u *usageStore) monthBytes(by, name string, now time.Time) int64 {
+	month := now.Format(usageMonthLayout)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	totals, ok := u.months[month]
+	if !ok {
+		return 0
+	}
+	if c, ok := totals.group(by)[name]; ok {
+		return c.BytesIn + c.BytesOut
+	}
+	return 0
+}
+
+// gc 丢弃以前月份的累计用量 (每天的记录保留在文件中，用于用量报表)
+func (u *usageStore) gc(now time.Time) {
+	month := now.Format(usageMonthLayout)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for m := range u.months {
+		if m < month {
+			delete(u.months, m)
+		}
+	}
+}
+
+// flush 有变化时写入文件，写入失败时保留变化标记，下次保存时重试
+func (u *usageStore) flush() error {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+
+	u.mu.Lock()
+	if !u.dirty {
+		u.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{"days": u.days}, "", "  ")
+	if err == nil {
+		u.dirty = false // 写入期间的新变化会重新设置标记
+	}
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := u.write(data); err != nil {
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// write 先写临时文件再替换
+func (u *usageStore) write(data []byte) error {
+	if dir := filepath.Dir(u.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.path)
+}
+
+// quota 解析后的配额规则
+type quota struct {
+	name      string
+	per       string
+	tokens    []string
+	hostnames []string
+	limit     int64
+	action    string
+	rate      float64
+
+	mu       sync.Mutex
+	limiters map[string]*byteLimiter // 超额的令牌或路由 -> 限速器
+	notified map[string]string       // 已记录超额日志的令牌或路由 -> 月份
+}
+
+// newQuota 解析配额配置
+func newQuota(cfg QuotaConfig) (*quota, error) {
+	q := &quota{
+		name:     cfg.Name,
+		per:      strings.ToLower(strings.TrimSpace(cfg.Per)),
+		tokens:   cfg.Tokens,
+		action:   strings.ToLower(strings.TrimSpace(cfg.Action)),
+		limiters: make(map[string]*byteLimiter),
+		notified: make(map[string]string),
+	}
+	if q.per == "" {
+		q.per = UsageByToken
+	}
+	if q.per != UsageByToken && q.per != UsageByRoute {
+		return nil, fmt.Errorf("未知的配额维度: %s (可用 token、route)", cfg.Per)
+	}
+	for _, host := range cfg.Hostnames {
+		q.hostnames = append(q.hostnames, normalizeHostname(host))
+	}
+
+	var err error
+	if q.limit, err = parseByteSize(cfg.MonthlyBytes); err != nil || q.limit <= 0 {
+		return nil, fmt.Errorf("无效的 monthlyBytes: %q", cfg.MonthlyBytes)
+	}
+	switch q.action {
+	case "", QuotaActionReject:
+		q.action = QuotaActionReject
+	case QuotaActionThrottle:
+		rate := cfg.ThrottleRate
+		if rate == "" {
+			rate = defaultThrottleRate
+		}
+		if q.rate, err = parseByteRate(rate); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("未知的配额动作: %s (可用 reject、throttle)", cfg.Action)
+	}
+	if q.name == "" {
+		q.name = fmt.Sprintf("%s %s", q.per, cfg.MonthlyBytes)
+	}
+	return q, nil
+}
+
+// matches 判断配额是否作用于该路由和客户端
+func (q *quota) matches(route, identity string) bool {
+	if len(q.tokens) > 0 && !containsString(q.tokens, identity) {
+		return false
+	}
+	if len(q.hostnames) == 0 {
+		return true
+	}
+	for _, pattern := range q.hostnames {
+		if matchHostname(pattern, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// limiter 超额对象共享的限速器
+func (q *quota) limiter(key string) *byteLimiter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l, ok := q.limiters[key]
+	if !ok {
+		l = newByteLimiter(q.rate)
+		q.limiters[key] = l
+	}
+	return l
+}
+
+// gc 回收空闲的限速器和以前月份的日志记录
+func (q *quota) gc(now time.Time) {
+	month := now.Format(usageMonthLayout)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for key, l := range q.limiters {
+		if l.idle(now) {
+			delete(q.limiters, key)
+		}
+	}
+	for key, m := range q.notified {
+		if m != month {
+			delete(q.notified, key)
+		}
+	}
+}
+
+// firstExceeded 每个对象每月只记录一次超额日志
+func (q *quota) firstExceeded(key, month string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.notified[key] == month {
+		return false
+	}
+	q.notified[key] = month
+	return true
+}
+
+// loadUsage 启用用量统计和配额
+func (s *TunnelServer) loadUsage() error {
+	cfg := s.config.Usage
+	if cfg.File == "" {
+		if len(cfg.Quotas) > 0 {
+			return fmt.Errorf("配置流量配额需要设置 usage.file")
+		}
+		return nil
+	}
+
+	store, err := loadUsageStore(cfg.File)
+	if err != nil {
+		return err
+	}
+	s.usage = store
+	s.quotas = nil
+	for i, qc := range cfg.Quotas {
+		q, err := newQuota(qc)
+		if err != nil {
+			return fmt.Errorf("流量配额 #%d 配置错误: %v", i+1, err)
+		}
+		s.quotas = append(s.quotas, q)
+	}
+
+	interval := time.Duration(cfg.FlushInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultUsageInterval * time.Second
+	}
+	usageLog.Info("用量统计已启用", "file", cfg.File, "interval", interval, "quotas", len(s.quotas))
+	go s.usageLoop(interval)
+	return nil
+}
+
+// usageLoop 定期累计连接时长并保存用量，同时回收配额的空闲限速器
+func (s *TunnelServer) usageLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		s.saveUsage(now)
+		s.usage.gc(now)
+		for _, q := range s.quotas {
+			q.gc(now)
+		}
+	}
+}
+
+// saveUsage 累计在线客户端的连接时长并写入用量文件，也在退出时调用
+func (s *TunnelServer) saveUsage(now time.Time) {
+	if s.usage == nil {
+		return
+	}
+	s.clientsMux.Lock()
+	for _, client := range s.clients {
+		s.accountConnection(client, now)
+	}
+	s.clientsMux.Unlock()
+	if err := s.usage.flush(); err != nil {
+		usageLog.Error("保存用量失败", "error", err)
+	}
+}
+
+// usageTokenKey 客户端在用量统计中的名称
+func usageTokenKey(client *Client) string {
+	if client.Identity == "" {
+		return anonymousUsageKey
+	}
+	return client.Identity
+}
+
+// usageRouteKey 客户端注册的主机名，未注册时为 *
+func usageRouteKey(client *Client) string {
+	if client.Route.Hostname == "" {
+		return "*"
+	}
+	return client.Route.Hostname
+}
+
+// accountConnection 累计客户端自上次统计以来的连接时长，调用方需持有 clientsMux
+func (s *TunnelServer) accountConnection(client *Client, now time.Time) {
+	if s.usage == nil {
+		return
+	}
+	seconds := now.Sub(client.usageMark).Seconds()
+	client.usageMark = now
+	if seconds <= 0 {
+		return
+	}
+	s.usage.add(UsageByToken, usageTokenKey(client), usageCounters{ConnectionSeconds: seconds}, now)
+	s.usage.add(UsageByRoute, usageRouteKey(client), usageCounters{ConnectionSeconds: seconds}, now)
+}
+
+// recordUsage 记录一次请求的流量。主机名按客户端的路由统计，访客的 Host 头不会产生新的记录
+func (s *TunnelServer) recordUsage(client *Client, bytesIn, bytesOut int64) {
+	if s.usage == nil {
+		return
+	}
+	now := time.Now()
+	delta := usageCounters{Requests: 1, BytesIn: bytesIn, BytesOut: bytesOut}
+	s.usage.add(UsageByToken, usageTokenKey(client), delta, now)
+	s.usage.add(UsageByRoute, usageRouteKey(client), delta, now)
+}
+
+// checkQuota 检查当月流量配额，超额时拒绝 (返回 false) 或返回需要使用的限速器。
+// 与用量统计一样按客户端的路由计算，访客修改 Host 头不能绕过配额
+func (s *TunnelServer) checkQuota(w http.ResponseWriter, client *Client) ([]*byteLimiter, bool) {
+	if len(s.quotas) == 0 {
+		return nil, true
+	}
+	route := usageRouteKey(client)
+	now := time.Now()
+	month := now.Format(usageMonthLayout)
+
+	var limiters []*byteLimiter
+	for _, q := range s.quotas {
+		if !q.matches(route, client.Identity) {
+			continue
+		}
+		key := route
+		if q.per == UsageByToken {
+			key = usageTokenKey(client)
+		}
+		used := s.usage.monthBytes(q.per, key, now)
+		if used < q.limit {
+			continue
+		}
+
+		if q.firstExceeded(key, month) {
+			usageLog.Warn("流量配额: 本月用量超过配额", "per", q.per, "key", key,
+				"used", formatBytes(used), "limit", formatBytes(q.limit), "rule", q.name, "action", q.action)
+		}
+		if q.action == QuotaActionThrottle {
+			limiters = append(limiters, q.limiter(key))
+			continue
+		}
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+		w.Header().Set("Retry-After", strconv.Itoa(int(nextMonth.Sub(now).Seconds())))
+		http.Error(w, "本月流量配额已用完", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return limiters, true
+}
+
+// headerSize 估算请求头或响应头的字节数
+func headerSize(headers map[string]string) int64 {
+	var n int64
+	for k, v := range headers {
+		n += int64(len(k) + len(v) + 4) // ": " 和 "\r\n"
+	}
+	return n
+}
+
+// usageRow 用量报表的一行
+type usageRow struct {
+	Date              string  `json:"date,omitempty"`
+	Name              string  `json:"name"`
+	Requests          int64   `json:"requests"`
+	BytesIn           int64   `json:"bytesIn"`
+	BytesOut          int64   `json:"bytesOut"`
+	ConnectionSeconds float64 `json:"connectionSeconds"`
+}
+
+// usageReport 汇总日期范围内的用量，daily 为 true 时按天分别列出
+func usageReport(store *usageStore, by, from, to string, daily bool) []usageRow {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	rows := make(map[string]*usageRow)
+	for date, day := range store.days {
+		if date < from || date > to {
+			continue
+		}
+		for name, c := range day.group(by) {
+			key := name
+			if daily {
+				key = date + "\x00" + name
+			}
+			row, ok := rows[key]
+			if !ok {
+				row = &usageRow{Name: name}
+				if daily {
+					row.Date = date
+				}
+				rows[key] = row
+			}
+			row.Requests += c.Requests
+			row.BytesIn += c.BytesIn
+			row.BytesOut += c.BytesOut
+			row.ConnectionSeconds += c.ConnectionSeconds
+		}
+	}
+
+	result := make([]usageRow, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Date != result[j].Date {
+			return result[i].Date < result[j].Date
+		}
+		return result[i].BytesIn+result[i].BytesOut > result[j].BytesIn+result[j].BytesOut
+	})
+	return result
+}
+
+// writeUsageReport 以 table、csv 或 json 格式输出用量报表
+func writeUsageReport(w io.Writer, rows []usageRow, format string, daily bool) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := []string{"name", "requests", "bytes_in", "bytes_out", "bytes_total", "connection_seconds"}
+		if daily {
+			header = append([]string{"date"}, header...)
+		}
+		cw.Write(header)
+		for _, row := range rows {
+			record := []string{
+				row.Name,
+				strconv.FormatInt(row.Requests, 10),
+				strconv.FormatInt(row.BytesIn, 10),
+				strconv.FormatInt(row.BytesOut, 10),
+				strconv.FormatInt(row.BytesIn+row.BytesOut, 10),
+				strconv.FormatFloat(row.ConnectionSeconds, 'f', 0, 64),
+			}
+			if daily {
+				record = append([]string{row.Date}, record...)
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		header := "名称\t请求数\t入站\t出站\t合计\t连接时长"
+		if daily {
+			header = "日期\t" + header
+		}
+		fmt.Fprintln(tw, header)
+		for _, row := range rows {
+			line := fmt.Sprintf("%s\t%d\t%s\t%s\t%s\t%v", row.Name, row.Requests,
+				formatBytes(row.BytesIn), formatBytes(row.BytesOut), formatBytes(row.BytesIn+row.BytesOut),
+				time.Duration(row.ConnectionSeconds)*time.Second)
+			if daily {
+				line = row.Date + "\t" + line
+			}
+			fmt.Fprintln(tw, line)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("未知的输出格式: %s (可用 table、csv、json)", format)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newUsageTestServer 创建启用用量统计和给定配额的服务器
+func newUsageTestServer(t *testing.T, quotas ...QuotaConfig) *TunnelServer {
+	t.Helper()
+	config := DefaultConfig()
+	config.Usage.File = filepath.Join(t.TempDir(), "usage.json")
+	config.Usage.Quotas = quotas
+	s := NewTunnelServer(config)
+	store, err := loadUsageStore(config.Usage.File)
+	if err != nil {
+		t.Fatalf("创建用量记录失败: %v", err)
+	}
+	s.usage = store
+	for _, qc := range quotas {
+		q, err := newQuota(qc)
+		if err != nil {
+			t.Fatalf("解析配额失败: %v", err)
+		}
+		s.quotas = append(s.quotas, q)
+	}
+	return s
+}
+
+func TestRecordUsageKeyedByRoute(t *testing.T) {
+	s := newUsageTestServer(t)
+	fallback := &Client{Identity: "ci"}
+	routed := &Client{Identity: "ci", Route: TunnelRoute{Hostname: "app.example.com"}}
+
+	// 未注册主机名的客户端接收任意 Host 的请求，都记在 * 下
+	for i := 0; i < 100; i++ {
+		s.recordUsage(fallback, 10, 20)
+	}
+	s.recordUsage(routed, 1, 2)
+
+	now := time.Now()
+	day := s.usage.days[now.Format(usageDateLayout)]
+	if len(day.Routes) != 2 {
+		t.Fatalf("路由统计应只有客户端的路由，得到 %v", day.Routes)
+	}
+	if c := day.Routes["*"]; c == nil || c.Requests != 100 || c.BytesIn+c.BytesOut != 3000 {
+		t.Errorf("* 的用量不正确: %+v", c)
+	}
+	if got := s.usage.monthBytes(UsageByRoute, "app.example.com", now); got != 3 {
+		t.Errorf("app.example.com 本月流量 = %d, 期望 3", got)
+	}
+	if got := s.usage.monthBytes(UsageByToken, "ci", now); got != 3003 {
+		t.Errorf("令牌 ci 本月流量 = %d, 期望 3003", got)
+	}
+}
+
+func TestCheckQuotaByRoute(t *testing.T) {
+	s := newUsageTestServer(t,
+		QuotaConfig{Per: UsageByRoute, Hostnames: []string{"*"}, MonthlyBytes: "1KB"},
+		QuotaConfig{Per: UsageByRoute, Hostnames: []string{"*.example.com"}, MonthlyBytes: "1KB", Action: QuotaActionThrottle},
+	)
+	fallback := &Client{}
+	routed := &Client{Route: TunnelRoute{Hostname: "app.example.com"}}
+	other := &Client{Route: TunnelRoute{Hostname: "app.example.org"}}
+	s.recordUsage(fallback, 2048, 0)
+	s.recordUsage(routed, 2048, 0)
+	s.recordUsage(other, 2048, 0)
+
+	// 超额的 * 路由拒绝所有请求，与访客的 Host 无关
+	rec := httptest.NewRecorder()
+	if _, ok := s.checkQuota(rec, fallback); ok || rec.Code != http.StatusTooManyRequests {
+		t.Errorf("超过配额的路由应被拒绝，得到 %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("拒绝时应设置 Retry-After")
+	}
+
+	// hostnames 按注册的主机名匹配
+	limiters, ok := s.checkQuota(httptest.NewRecorder(), routed)
+	if !ok || len(limiters) != 1 {
+		t.Errorf("app.example.com 超额后应限速，得到 %d 个限速器, ok=%v", len(limiters), ok)
+	}
+	if again, _ := s.checkQuota(httptest.NewRecorder(), routed); len(again) != 1 || again[0] != limiters[0] {
+		t.Error("同一路由应共享限速器")
+	}
+	if limiters, ok := s.checkQuota(httptest.NewRecorder(), other); !ok || len(limiters) != 0 {
+		t.Error("不匹配任何配额的路由不应受限")
+	}
+}
+
+func TestUsageFlushRetriesAfterFailure(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "data")
+	store, err := loadUsageStore(filepath.Join(blocker, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用量文件的目录位置被普通文件占用，写入失败
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	store.add(UsageByToken, "ci", usageCounters{Requests: 1, BytesIn: 10}, time.Now())
+	if err := store.flush(); err == nil {
+		t.Fatal("目录不可用时保存应失败")
+	}
+
+	// 没有新的变化，恢复后再次保存也应写入之前的用量
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.flush(); err != nil {
+		t.Fatalf("恢复后保存失败: %v", err)
+	}
+	reloaded, err := loadUsageStore(store.path)
+	if err != nil {
+		t.Fatalf("读取用量文件失败: %v", err)
+	}
+	if got := reloaded.monthBytes(UsageByToken, "ci", time.Now()); got != 10 {
+		t.Errorf("重试后保存的用量 = %d, 期望 10", got)
+	}
+}
+
+func TestShutdownSavesUsage(t *testing.T) {
+	s := newUsageTestServer(t)
+	client := &Client{ID: "client_1", Identity: "ci", usageMark: time.Now().Add(-time.Minute)}
+	s.clients[client.ID] = client
+	s.recordUsage(client, 100, 200)
+
+	s.shutdown()
+
+	reloaded, err := loadUsageStore(s.config.Usage.File)
+	if err != nil {
+		t.Fatalf("退出时应保存用量: %v", err)
+	}
+	day := reloaded.days[time.Now().Format(usageDateLayout)]
+	if day == nil || day.Tokens["ci"] == nil {
+		t.Fatal("退出时应保存用量")
+	}
+	if c := day.Tokens["ci"]; c.BytesIn+c.BytesOut != 300 || c.ConnectionSeconds < 59 {
+		t.Errorf("保存的用量应包括请求流量和到退出时的连接时长: %+v", c)
+	}
+}
+
+func TestQuotaGC(t *testing.T) {
+	s := newUsageTestServer(t, QuotaConfig{Per: UsageByRoute, MonthlyBytes: "1KB", Action: QuotaActionThrottle})
+	client := &Client{Route: TunnelRoute{Hostname: "app.example.com"}}
+	s.recordUsage(client, 2048, 0)
+	limiters, _ := s.checkQuota(httptest.NewRecorder(), client)
+	q := s.quotas[0]
+	limiters[0].wait(int(q.rate))
+
+	now := time.Now()
+	q.gc(now)
+	if len(q.limiters) != 1 || len(q.notified) != 1 {
+		t.Fatalf("使用中的限速器和本月的日志记录不应回收: %d/%d", len(q.limiters), len(q.notified))
+	}
+	q.gc(now.AddDate(0, 1, 0))
+	if len(q.limiters) != 0 || len(q.notified) != 0 {
+		t.Errorf("空闲的限速器和以前月份的记录应回收: %d/%d", len(q.limiters), len(q.notified))
+	}
+}
+
+func TestMonthBytesRunningTotals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	store, err := loadUsageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	lastMonth := now.AddDate(0, -1, 0)
+	store.add(UsageByToken, "ci", usageCounters{BytesIn: 100}, lastMonth)
+	store.add(UsageByToken, "ci", usageCounters{BytesIn: 10, BytesOut: 5}, now.AddDate(0, 0, -10))
+	store.add(UsageByToken, "ci", usageCounters{BytesIn: 1}, now)
+	store.add(UsageByRoute, "app.example.com", usageCounters{BytesOut: 7}, now)
+	if err := store.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 加载时按每天的记录重新计算每月累计
+	reloaded, err := loadUsageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*usageStore{store, reloaded} {
+		if got := u.monthBytes(UsageByToken, "ci", now); got != 16 {
+			t.Errorf("本月流量 = %d, 期望 16", got)
+		}
+		if got := u.monthBytes(UsageByToken, "ci", lastMonth); got != 100 {
+			t.Errorf("上月流量 = %d, 期望 100", got)
+		}
+		if got := u.monthBytes(UsageByRoute, "app.example.com", now); got != 7 {
+			t.Errorf("路由本月流量 = %d, 期望 7", got)
+		}
+		if got := u.monthBytes(UsageByToken, "unknown", now.AddDate(1, 0, 0)); got != 0 {
+			t.Errorf("没有记录时流量应为 0, 得到 %d", got)
+		}
+	}
+
+	// 以前月份的累计被回收，每天的记录保留用于报表
+	reloaded.gc(now)
+	if len(reloaded.months) != 1 || len(reloaded.days) != 3 {
+		t.Errorf("应只保留本月累计和所有每天的记录: %d/%d", len(reloaded.months), len(reloaded.days))
+	}
+	if got := reloaded.monthBytes(UsageByToken, "ci", now); got != 16 {
+		t.Errorf("回收后本月流量 = %d, 期望 16", got)
+	}
+}
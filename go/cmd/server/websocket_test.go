@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleWebSocketUniqueClientIDs(t *testing.T) {
+	config := DefaultConfig()
+	config.Auth.RequireAuth = false
+	s := NewTunnelServer(config)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer srv.Close()
+
+	// 同一秒内连接的客户端不应互相覆盖
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+		defer conn.Close()
+		var welcome struct {
+			Data struct {
+				ClientID string `json:"clientId"`
+			} `json:"data"`
+		}
+		if err := conn.ReadJSON(&welcome); err != nil {
+			t.Fatalf("读取欢迎消息失败: %v", err)
+		}
+		ids[welcome.Data.ClientID] = true
+	}
+
+	s.clientsMux.RLock()
+	defer s.clientsMux.RUnlock()
+	if len(ids) != 3 || len(s.clients) != 3 {
+		t.Errorf("每个连接应有不同的客户端ID，得到 %v，当前客户端 %d 个", ids, len(s.clients))
+	}
+}
+
+func TestHandleWebSocketCertOnlyClientUsesTokenScope(t *testing.T) {
+	hash, _ := HashToken("unused")
+	handshake := func(s *TunnelServer, cn, hostname string) int {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		r := httptest.NewRequest(http.MethodGet, "https://tunnel.test/", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		r.Header.Set("X-Tunnel-Hostname", hostname)
+		rec := httptest.NewRecorder()
+		s.handleWebSocket(rec, r)
+		return rec.Code
+	}
+	newServer := func(tokens ...TokenConfig) *TunnelServer {
+		config := DefaultConfig()
+		config.Auth.MTLS.Mode = MTLSModeOptional
+		config.Auth.Tokens = tokens
+		s := NewTunnelServer(config)
+		if err := s.loadTokens(); err != nil {
+			t.Fatalf("加载令牌失败: %v", err)
+		}
+		return s
+	}
+
+	scoped := newServer(TokenConfig{Name: "laptop", Token: hash, Hostnames: []string{"laptop.example.com"}})
+	// 没有 WebSocket 升级头，通过检查后升级失败返回 400
+	tests := []struct {
+		name     string
+		cn       string
+		hostname string
+		want     int
+	}{
+		{"同名令牌允许的主机名", "laptop", "laptop.example.com", http.StatusBadRequest},
+		{"同名令牌不允许的主机名", "laptop", "other.example.com", http.StatusForbidden},
+		{"没有同名令牌", "desktop", "laptop.example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := handshake(scoped, tt.cn, tt.hostname); code != tt.want {
+				t.Errorf("期望 %d，得到 %d", tt.want, code)
+			}
+		})
+	}
+
+	// 所有令牌都不带限制时，证书客户端照常连接
+	open := newServer(TokenConfig{Name: "ci", Token: hash})
+	if code := handshake(open, "desktop", "desktop.example.com"); code != http.StatusBadRequest {
+		t.Errorf("未配置带限制的令牌时证书客户端应被允许，得到 %d", code)
+	}
+
+	// maxConnections 对同名令牌生效
+	limited := newServer(TokenConfig{Name: "laptop", Token: hash, MaxConnections: 1})
+	token, err := limited.certToken("laptop")
+	if err != nil || token == nil || !limited.acquireTokenConn(token) {
+		t.Fatalf("应找到同名令牌并占用连接: %v", err)
+	}
+	if code := handshake(limited, "laptop", ""); code != http.StatusTooManyRequests {
+		t.Errorf("同名令牌连接数已满时应返回 429，得到 %d", code)
+	}
+}
+
+func TestHandleWebSocketLimitsAuthFailures(t *testing.T) {
+	plain, _ := GenerateToken()
+	hash, _ := HashToken(plain)
+	config := DefaultConfig()
+	config.Auth.Tokens = []TokenConfig{{Name: "ci", Token: hash}}
+	s := NewTunnelServer(config)
+	if err := s.loadTokens(); err != nil {
+		t.Fatalf("加载令牌失败: %v", err)
+	}
+	handshake := func(remote, token string) int {
+		r := httptest.NewRequest(http.MethodGet, "http://tunnel.test/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		s.handleWebSocket(rec, r)
+		return rec.Code
+	}
+
+	for i := 0; i < authFailureBurst; i++ {
+		if code := handshake("203.0.113.1:1000", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("第 %d 次失败应返回 401，得到 %d", i+1, code)
+		}
+	}
+	// 超过限制后在校验令牌之前拒绝，正确的令牌也要等待
+	if code := handshake("203.0.113.1:1000", plain); code != http.StatusTooManyRequests {
+		t.Errorf("认证失败次数过多时应返回 429，得到 %d", code)
+	}
+	// 其他地址不受影响 (没有 WebSocket 升级头，认证通过后升级失败返回 400)
+	if code := handshake("203.0.113.2:1000", plain); code != http.StatusBadRequest {
+		t.Errorf("其他地址的认证不应受限，得到 %d", code)
+	}
+}
@@ -0,0 +1,368 @@
+// Package har 把经过隧道的请求和响应写成 HAR 1.2 文件，可以附在问题报告中或在浏览器开发者工具中打开
+package har
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"tunnel/internal/logging"
+)
+
+// DefaultMaxBodySize 默认每个正文保留的字节数
+const DefaultMaxBodySize = 64 * 1024
+
+// 总是隐去的请求头和响应头
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// Options 写入时的正文大小限制和隐去的头部
+type Options struct {
+	MaxBodySize   int      // 每个正文保留的字节数，超出的部分截断，0 表示使用默认值，负数表示不记录正文
+	RedactHeaders []string // 额外隐去的头部 (Authorization、Cookie 等总是隐去)
+}
+
+// Exchange 一次请求和响应
+type Exchange struct {
+	Started          time.Time
+	Method           string
+	URL              string
+	Proto            string // 默认 HTTP/1.1
+	RequestHeaders   http.Header
+	RequestBody      []byte
+	RequestBodySize  int // 原始正文大小，RequestBody 可能已被截断；为 0 时使用 len(RequestBody)
+	Status           int // 没有响应时为 0
+	ResponseHeaders  http.Header
+	ResponseBody     []byte
+	ResponseBodySize int
+	Wait             time.Duration // 发出请求到收到响应
+	Total            time.Duration
+	Error            string            // 请求失败的原因
+	Fields           map[string]string // 附加字段，写为条目中以 _ 开头的自定义字段
+}
+
+// Writer 以流的方式写入 HAR 文件，可以并发调用 Write
+type Writer struct {
+	opts   Options
+	redact map[string]bool
+
+	mu        sync.Mutex
+	w         io.Writer
+	file      *os.File // Create 创建的文件，每条之后写入结尾，文件随时是完整的 HAR
+	trailerAt int64
+	count     int
+	closed    bool
+}
+
+const trailer = "\n]}}\n"
+
+// Create 创建 HAR 文件。每写入一条都会补全文件结尾，进程意外退出时文件仍然可以打开
+func Create(path, creator string, opts Options) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, creator, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.file = f
+	if w.trailerAt, err = f.Seek(0, io.SeekCurrent); err == nil {
+		_, err = io.WriteString(f, trailer)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewWriter 写入 HAR 的开头，Close 时写入结尾
+func NewWriter(w io.Writer, creator string, opts Options) (*Writer, error) {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = DefaultMaxBodySize
+	}
+	hw := &Writer{opts: opts, w: w, redact: make(map[string]bool)}
+	for _, name := range append(defaultRedactHeaders, opts.RedactHeaders...) {
+		hw.redact[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	head, err := json.Marshal(nameVersion{Name: creator, Version: version})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "{\"log\":{\"version\":\"1.2\",\"creator\":%s,\"pages\":[],\"entries\":[", head); err != nil {
+		return nil, err
+	}
+	return hw, nil
+}
+
+// Write 追加一条记录
+func (w *Writer) Write(x *Exchange) error {
+	data, err := json.Marshal(w.entry(x))
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file != nil {
+		if _, err := w.file.Seek(w.trailerAt, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	sep := ","
+	if w.count == 0 {
+		sep = ""
+	}
+	if _, err := fmt.Fprintf(w.w, "%s\n%s", sep, data); err != nil {
+		return err
+	}
+	w.count++
+	if w.file != nil {
+		w.trailerAt += int64(len(sep) + 1 + len(data))
+		if _, err := io.WriteString(w.file, trailer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Count 已写入的条数
+func (w *Writer) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Close 写入结尾，Create 创建的文件同时被关闭
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.file != nil {
+		return w.file.Close()
+	}
+	_, err := io.WriteString(w.w, trailer)
+	return err
+}
+
+// HAR 1.2 结构 (http://www.softwareishard.com/blog/har-12-spec/)
+type (
+	nameVersion struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	nameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	entry struct {
+		StartedDateTime string   `json:"startedDateTime"`
+		Time            float64  `json:"time"`
+		Request         request  `json:"request"`
+		Response        response `json:"response"`
+		Cache           struct{} `json:"cache"`
+		Timings         timings  `json:"timings"`
+		Comment         string   `json:"comment,omitempty"`
+	}
+	request struct {
+		Method      string      `json:"method"`
+		URL         string      `json:"url"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []nameValue `json:"cookies"`
+		Headers     []nameValue `json:"headers"`
+		QueryString []nameValue `json:"queryString"`
+		PostData    *postData   `json:"postData,omitempty"`
+		HeadersSize int         `json:"headersSize"`
+		BodySize    int         `json:"bodySize"`
+	}
+	postData struct {
+		MimeType string      `json:"mimeType"`
+		Params   []nameValue `json:"params"`
+		Text     string      `json:"text"`
+		Comment  string      `json:"comment,omitempty"`
+	}
+	response struct {
+		Status      int         `json:"status"`
+		StatusText  string      `json:"statusText"`
+		HTTPVersion string      `json:"httpVersion"`
+		Cookies     []nameValue `json:"cookies"`
+		Headers     []nameValue `json:"headers"`
+		Content     content     `json:"content"`
+		RedirectURL string      `json:"redirectURL"`
+		HeadersSize int         `json:"headersSize"`
+		BodySize    int         `json:"bodySize"`
+	}
+	content struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+		Comment  string `json:"comment,omitempty"`
+	}
+	timings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+func (w *Writer) entry(x *Exchange) map[string]interface{} {
+	proto := x.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	rawURL := logging.Redact(x.URL)
+
+	req := request{
+		Method:      x.Method,
+		URL:         rawURL,
+		HTTPVersion: proto,
+		Cookies:     []nameValue{},
+		Headers:     w.headers(x.RequestHeaders),
+		QueryString: []nameValue{},
+		HeadersSize: -1,
+		BodySize:    bodySize(x.RequestBody, x.RequestBodySize),
+	}
+	// 隐去后的 URL 可能无法按 URL 解析 (如用户信息中的 [REDACTED])，直接截取查询字符串
+	if _, query, ok := strings.Cut(rawURL, "?"); ok {
+		query, _, _ = strings.Cut(query, "#")
+		for _, kv := range strings.Split(query, "&") {
+			if kv == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(kv, "=")
+			name, _ = url.QueryUnescape(name)
+			value, _ = url.QueryUnescape(value)
+			req.QueryString = append(req.QueryString, nameValue{Name: name, Value: value})
+		}
+	}
+	if req.BodySize > 0 {
+		// postData 没有 encoding 字段，非文本正文在注释中说明
+		text, encoding, note := w.body(x.RequestBody, req.BodySize)
+		if encoding != "" {
+			note = strings.TrimSpace("正文以 base64 编码 " + note)
+		}
+		req.PostData = &postData{
+			MimeType: x.RequestHeaders.Get("Content-Type"),
+			Params:   []nameValue{},
+			Text:     text,
+			Comment:  note,
+		}
+	}
+
+	resp := response{
+		Status:      x.Status,
+		StatusText:  http.StatusText(x.Status),
+		HTTPVersion: proto,
+		Cookies:     []nameValue{},
+		Headers:     w.headers(x.ResponseHeaders),
+		RedirectURL: logging.Redact(x.ResponseHeaders.Get("Location")),
+		HeadersSize: -1,
+		BodySize:    bodySize(x.ResponseBody, x.ResponseBodySize),
+	}
+	resp.Content.Size = resp.BodySize
+	resp.Content.MimeType = x.ResponseHeaders.Get("Content-Type")
+	if resp.BodySize > 0 {
+		resp.Content.Text, resp.Content.Encoding, resp.Content.Comment = w.body(x.ResponseBody, resp.BodySize)
+	}
+	if x.Status == 0 {
+		resp.HTTPVersion, resp.BodySize = "", -1
+	}
+
+	total := ms(x.Total)
+	wait := min(ms(x.Wait), total)
+	e := entry{
+		StartedDateTime: x.Started.Format(time.RFC3339Nano),
+		Time:            total,
+		Request:         req,
+		Response:        resp,
+		Timings:         timings{Send: 0, Wait: wait, Receive: math.Round((total-wait)*1000) / 1000},
+		Comment:         x.Error,
+	}
+
+	// 自定义字段以 _ 开头，和标准字段合并到同一个对象
+	data, _ := json.Marshal(e)
+	var out map[string]interface{}
+	json.Unmarshal(data, &out)
+	if x.Error != "" {
+		out["_error"] = x.Error
+	}
+	for k, v := range x.Fields {
+		if v != "" {
+			out["_"+k] = v
+		}
+	}
+	return out
+}
+
+// headers 按名称排序，隐去凭据 (Location、Referer 等头部中的令牌和查询参数同样隐去)
+func (w *Writer) headers(h http.Header) []nameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]nameValue, 0, len(names))
+	for _, name := range names {
+		for _, value := range h[name] {
+			if w.redact[http.CanonicalHeaderKey(name)] {
+				value = logging.Redacted
+			} else {
+				value = logging.Redact(value)
+			}
+			result = append(result, nameValue{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+// body 按大小限制截取正文，不是文本时以 base64 编码
+func (w *Writer) body(data []byte, size int) (text, encoding, note string) {
+	if w.opts.MaxBodySize < 0 {
+		return "", "", "正文未记录"
+	}
+	if len(data) > w.opts.MaxBodySize {
+		data = data[:w.opts.MaxBodySize]
+	}
+	if len(data) < size {
+		note = fmt.Sprintf("正文已截断，保留了 %d / %d 字节", len(data), size)
+	}
+	if utf8.Valid(data) {
+		return string(data), "", note
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64", note
+}
+
+func bodySize(body []byte, size int) int {
+	if size > 0 {
+		return size
+	}
+	return len(body)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,239 @@
+// Package logging 基于 log/slog 的结构化日志：按组件设置级别，输出 text 或 JSON，并隐去令牌等敏感信息
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// KeyComponent 组件字段名
+const KeyComponent = "component"
+
+// Config 日志配置
+type Config struct {
+	Level      string            `yaml:"level" json:"level"`           // debug / info / warn / error，默认 info
+	Format     string            `yaml:"format" json:"format"`         // text / json，默认 text
+	Components map[string]string `yaml:"components" json:"components"` // 按组件覆盖级别，如 {"http": "debug"}
+}
+
+// ParseLevelSpec 解析 --log-level 参数，如 "debug" 或 "info,http=debug,tls=warn"，
+// 结果合并到配置中 (命令行优先)
+func (c *Config) ParseLevelSpec(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, level, ok := strings.Cut(part, "=")
+		if !ok {
+			if _, err := parseLevel(part); err != nil {
+				return err
+			}
+			c.Level = part
+			continue
+		}
+		if _, err := parseLevel(level); err != nil {
+			return err
+		}
+		if c.Components == nil {
+			c.Components = make(map[string]string)
+		}
+		c.Components[strings.TrimSpace(component)] = strings.TrimSpace(level)
+	}
+	return nil
+}
+
+// settings 当前生效的输出和级别，Setup 时整体替换
+type settings struct {
+	handler    slog.Handler
+	level      slog.Level
+	components map[string]slog.Level
+}
+
+var current atomic.Pointer[settings]
+
+func init() {
+	current.Store(&settings{handler: newHandler(os.Stderr, "text"), level: slog.LevelInfo})
+}
+
+// Setup 按配置初始化日志，并接管标准库 log 包的输出
+func Setup(cfg Config) error {
+	return SetupWriter(os.Stderr, cfg)
+}
+
+// SetupWriter 同 Setup，输出到 w
+func SetupWriter(w io.Writer, cfg Config) error {
+	st := &settings{level: slog.LevelInfo, components: make(map[string]slog.Level)}
+	var err error
+	if cfg.Level != "" {
+		if st.level, err = parseLevel(cfg.Level); err != nil {
+			return err
+		}
+	}
+	for component, value := range cfg.Components {
+		if st.components[component], err = parseLevel(value); err != nil {
+			return fmt.Errorf("组件 %s: %v", component, err)
+		}
+	}
+	format := strings.ToLower(cfg.Format)
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("未知的日志格式: %s (可用 text、json)", cfg.Format)
+	}
+	st.handler = newHandler(w, format)
+	current.Store(st)
+
+	// 标准库 log 包的输出 (如依赖库和 log.Fatalf) 以 info 级别输出
+	slog.SetDefault(slog.New(&componentHandler{}))
+	log.SetFlags(0)
+	return nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return level, fmt.Errorf("未知的日志级别: %s (可用 debug、info、warn、error)", s)
+	}
+	return level, nil
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{ReplaceAttr: jsonAttr})
+	}
+	return slog.NewTextHandler(w, &slog.HandlerOptions{ReplaceAttr: redactAttr})
+}
+
+// jsonAttr JSON 中的时长以秒为单位输出，便于日志系统计算
+func jsonAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	}
+	return redactAttr(groups, a)
+}
+
+// Component 返回组件的日志记录器，级别在每次输出时按当前配置判断，可以在 Setup 之前创建
+func Component(name string) *slog.Logger {
+	return slog.New(&componentHandler{component: name})
+}
+
+// Enabled 组件是否输出该级别的日志，用于跳过开销较大的日志参数
+func Enabled(component string, level slog.Level) bool {
+	return level >= current.Load().levelFor(component)
+}
+
+func (s *settings) levelFor(component string) slog.Level {
+	if level, ok := s.components[component]; ok {
+		return level
+	}
+	return s.level
+}
+
+// componentHandler 按组件过滤级别，把记录交给当前的输出处理器
+type componentHandler struct {
+	component string
+	ops       []func(slog.Handler) slog.Handler // WithAttrs / WithGroup，按顺序应用
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return Enabled(h.component, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := current.Load().handler
+	if h.component != "" {
+		out = out.WithAttrs([]slog.Attr{slog.String(KeyComponent, h.component)})
+	}
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	r.Message = Redact(r.Message)
+	return out.Handle(ctx, r)
+}
+
+func (h *componentHandler) with(op func(slog.Handler) slog.Handler) *componentHandler {
+	ops := append(append([]func(slog.Handler) slog.Handler(nil), h.ops...), op)
+	return &componentHandler{component: h.component, ops: ops}
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
+
+// 值总是敏感的字段
+var sensitiveKeys = map[string]bool{
+	"token":         true,
+	"auth_token":    true,
+	"authorization": true,
+	"password":      true,
+	"secret":        true,
+	"client_secret": true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+// Redacted 替代凭据的文本
+const Redacted = "[REDACTED]"
+
+// 可能出现在消息和字段值中的凭据
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=\-]{8,}`), "$1 " + Redacted},
+	{regexp.MustCompile(`\btk_[A-Za-z0-9_\-.]+`), "tk_" + Redacted},
+	{regexp.MustCompile(`(?i)\b((?:access_|id_|refresh_|auth_)?token|password|secret|code)=[^&\s"]+`), "$1=" + Redacted},
+	{regexp.MustCompile(`://([^:/@\s]+):[^@/\s]+@`), "://$1:" + Redacted + "@"},
+}
+
+// Redact 隐去字符串中的令牌、密码等凭据
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// Summary 生效的级别，如 "info,http=debug"，用于启动日志
+func (c Config) Summary() string {
+	level := c.Level
+	if level == "" {
+		level = "info"
+	}
+	names := make([]string, 0, len(c.Components))
+	for name := range c.Components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{level}
+	for _, name := range names {
+		parts = append(parts, name+"="+c.Components[name])
+	}
+	return strings.Join(parts, ",")
+}
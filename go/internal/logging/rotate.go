@@ -0,0 +1,160 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 按时间轮转的周期
+const (
+	RotateDaily  = "daily"
+	RotateHourly = "hourly"
+)
+
+// 轮转后文件名中的时间格式
+const backupTimeLayout = "2006-01-02T15-04-05.000"
+
+// RotatingFile 按大小和/或时间轮转的日志文件，可以并发写入。
+// 轮转时当前文件重命名为 name-<时间>.ext，超出 maxBackups 的旧文件被删除
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   string
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	period string
+}
+
+// OpenRotatingFile 打开 (或创建) 日志文件。maxSize 为 0 表示不按大小轮转，
+// interval 为空表示不按时间轮转，maxBackups 为 0 表示保留全部旧文件
+func OpenRotatingFile(path string, maxSize int64, interval string, maxBackups int) (*RotatingFile, error) {
+	interval = strings.ToLower(strings.TrimSpace(interval))
+	if interval != "" && interval != RotateDaily && interval != RotateHourly {
+		return nil, fmt.Errorf("未知的轮转周期: %s (可用 daily、hourly)", interval)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open 打开文件，周期按文件的修改时间计算，重启后跨周期的旧内容会在第一次写入时轮转
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	f.period = f.periodOf(info.ModTime())
+	if f.size == 0 {
+		f.period = f.periodOf(time.Now())
+	}
+	return nil
+}
+
+func (f *RotatingFile) periodOf(t time.Time) string {
+	switch f.interval {
+	case RotateDaily:
+		return t.Format("2006-01-02")
+	case RotateHourly:
+		return t.Format("2006-01-02T15")
+	}
+	return ""
+}
+
+// Write 写入一条日志，写入前按需要轮转
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	now := time.Now()
+	if f.size > 0 && (f.periodOf(now) != f.period || (f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize)) {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate 重命名当前文件并打开新文件
+func (f *RotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext)
+	backup := fmt.Sprintf("%s-%s%s", base, now.Format(backupTimeLayout), ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s-%s.%d%s", base, now.Format(backupTimeLayout), i, ext)
+	}
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.period = f.periodOf(now)
+	f.prune(base, ext)
+	return nil
+}
+
+// prune 删除超出保留数量的旧文件
+func (f *RotatingFile) prune(base, ext string) {
+	if f.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil || len(matches) <= f.maxBackups {
+		return
+	}
+	// 按修改时间从旧到新排序
+	modTime := make(map[string]time.Time, len(matches))
+	for _, name := range matches {
+		if info, err := os.Stat(name); err == nil {
+			modTime[name] = info.ModTime()
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return modTime[matches[i]].Before(modTime[matches[j]]) })
+	for _, old := range matches[:len(matches)-f.maxBackups] {
+		os.Remove(old)
+	}
+}
+
+// Close 关闭文件
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
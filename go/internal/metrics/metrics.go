@@ -0,0 +1,334 @@
+// Package metrics 实现 Prometheus 文本格式 (0.0.4) 的计数器、仪表和直方图
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets 默认的延迟直方图桶 (秒)
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry 指标集合，按注册顺序输出
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// metric 一个指标族
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry 创建空的指标集合
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// Write 以文本格式输出所有指标
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP 输出 Prometheus 文本格式
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// desc 指标名称、说明和标签
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// series 同一指标族中按标签值区分的序列
+type series[T any] struct {
+	desc
+	mu     sync.Mutex
+	values map[string]T
+	newFn  func() T
+}
+
+func (s *series[T]) with(labelValues []string) T {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s 需要 %d 个标签值，实际 %d 个", s.name, len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	if !ok {
+		v = s.newFn()
+		s.values[key] = v
+	}
+	return v
+}
+
+// sorted 按标签值排序后的序列，保证输出稳定
+func (s *series[T]) sorted() ([][]string, []T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labelValues := make([][]string, len(keys))
+	values := make([]T, len(keys))
+	for i, k := range keys {
+		if len(s.labels) > 0 {
+			labelValues[i] = strings.Split(k, "\xff")
+		}
+		values[i] = s.values[k]
+	}
+	return labelValues, values
+}
+
+// Counter 只增不减的计数器
+type Counter struct{ bits uint64 }
+
+// Add 增加 v (v 不能为负数)
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	addFloat(&c.bits, v)
+}
+
+// Inc 加 1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Value 当前值
+func (c *Counter) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&c.bits)) }
+
+// CounterVec 带标签的计数器
+type CounterVec struct{ series[*Counter] }
+
+// NewCounterVec 注册带标签的计数器
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{series[*Counter]{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]*Counter),
+		newFn:  func() *Counter { return &Counter{} },
+	}}
+	r.register(v)
+	return v
+}
+
+// NewCounter 注册不带标签的计数器
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With 返回标签值对应的计数器
+func (v *CounterVec) With(labelValues ...string) *Counter { return v.with(labelValues) }
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	labelValues, values := v.sorted()
+	for i, c := range values {
+		writeSample(w, v.name, v.labels, labelValues[i], "", "", c.Value())
+	}
+}
+
+// Gauge 可增可减的仪表
+type Gauge struct{ bits uint64 }
+
+// Set 设置当前值
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+
+// Add 增加 v (可以为负数)
+func (g *Gauge) Add(v float64) { addFloat(&g.bits, v) }
+
+// Inc 加 1
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec 减 1
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value 当前值
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+// GaugeVec 带标签的仪表
+type GaugeVec struct{ series[*Gauge] }
+
+// NewGaugeVec 注册带标签的仪表
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{series[*Gauge]{
+		desc:   desc{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]*Gauge),
+		newFn:  func() *Gauge { return &Gauge{} },
+	}}
+	r.register(v)
+	return v
+}
+
+// NewGauge 注册不带标签的仪表
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// With 返回标签值对应的仪表
+func (v *GaugeVec) With(labelValues ...string) *Gauge { return v.with(labelValues) }
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	labelValues, values := v.sorted()
+	for i, g := range values {
+		writeSample(w, v.name, v.labels, labelValues[i], "", "", g.Value())
+	}
+}
+
+// gaugeFunc 输出时调用函数取值的仪表
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc 注册输出时计算的仪表，如当前连接数
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	writeSample(w, g.name, nil, nil, "", "", g.fn())
+}
+
+// Histogram 累积直方图
+type Histogram struct {
+	buckets []float64
+	counts  []uint64 // 每个桶 (不累积) 的计数，最后一个是 +Inf
+	count   uint64
+	sumBits uint64
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	addFloat(&h.sumBits, v)
+}
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct{ series[*Histogram] }
+
+// NewHistogramVec 注册带标签的直方图，buckets 为各桶上限 (升序)
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	v := &HistogramVec{series[*Histogram]{
+		desc:   desc{name: name, help: help, kind: "histogram", labels: labels},
+		values: make(map[string]*Histogram),
+		newFn: func() *Histogram {
+			return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+		},
+	}}
+	r.register(v)
+	return v
+}
+
+// With 返回标签值对应的直方图
+func (v *HistogramVec) With(labelValues ...string) *Histogram { return v.with(labelValues) }
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	labelValues, values := v.sorted()
+	for i, h := range values {
+		var cumulative uint64
+		for j, upper := range h.buckets {
+			cumulative += atomic.LoadUint64(&h.counts[j])
+			writeSample(w, v.name+"_bucket", v.labels, labelValues[i], "le", formatFloat(upper), float64(cumulative))
+		}
+		count := atomic.LoadUint64(&h.count)
+		writeSample(w, v.name+"_bucket", v.labels, labelValues[i], "le", "+Inf", float64(count))
+		writeSample(w, v.name+"_sum", v.labels, labelValues[i], "", "", math.Float64frombits(atomic.LoadUint64(&h.sumBits)))
+		writeSample(w, v.name+"_count", v.labels, labelValues[i], "", "", float64(count))
+	}
+}
+
+// writeSample 输出一行样本，extraName/extraValue 用于直方图的 le 标签
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// addFloat 原子地给以 uint64 位存储的浮点数加 v
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(bits, old, next) {
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout 等待头部的默认超时
+const DefaultHeaderTimeout = 10 * time.Second
+
+// Listener 接受以 PROXY protocol 头部开始的连接，
+// 连接的 RemoteAddr/LocalAddr 返回头部中的地址
+type Listener struct {
+	net.Listener
+	// Required 判断来自该地址的连接是否必须携带头部，为 nil 时所有连接都必须携带
+	Required func(addr net.Addr) bool
+	// HeaderTimeout 等待头部的超时，为 0 时使用 DefaultHeaderTimeout
+	HeaderTimeout time.Duration
+}
+
+// Accept 接受连接，头部在首次读取或获取地址时解析，不阻塞 Accept
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Required != nil && !l.Required(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := l.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// Conn 携带 PROXY protocol 头部的连接
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// init 读取头部
+func (c *Conn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.header, c.err = Read(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+// Header 返回解析的头部
+func (c *Conn) Header() (*Header, error) {
+	c.init()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr 返回头部中的源地址
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr 返回头部中的目标地址
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
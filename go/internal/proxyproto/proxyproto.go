@@ -0,0 +1,205 @@
+// Package proxyproto 实现 HAProxy PROXY protocol v1/v2 的解析和生成
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// v2 签名
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1 头部最大长度 (含 CRLF)
+const maxV1Length = 107
+
+// ErrNoHeader 连接未以 PROXY protocol 头部开始
+var ErrNoHeader = errors.New("缺少 PROXY protocol 头部")
+
+// Header PROXY protocol 头部；Source/Destination 为 nil 表示 LOCAL 或 UNKNOWN，
+// 此时应使用连接本身的地址
+type Header struct {
+	Version     int
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// Read 从连接开头读取 v1 或 v2 头部
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(signatureV2))
+	if err != nil {
+		return nil, fmt.Errorf("读取 PROXY protocol 头部失败: %v", err)
+	}
+
+	switch {
+	case bytes.Equal(prefix, signatureV2):
+		return readV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		return readV1(r)
+	}
+	return nil, ErrNoHeader
+}
+
+// readV1 解析文本格式：PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < maxV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("读取 PROXY v1 头部失败: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 头部格式错误")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	header := &Header{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY v1 头部格式错误: %q", strings.TrimSpace(string(line)))
+	}
+
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	header.Source, header.Destination = src, dst
+	return header, nil
+}
+
+func parseV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4" && ip.To4() == nil) {
+		return nil, fmt.Errorf("PROXY v1 地址无效: %s", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1 端口无效: %s", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 解析二进制格式
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("读取 PROXY v2 头部失败: %v", err)
+	}
+	verCmd, family := fixed[12], fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("读取 PROXY v2 地址失败: %v", err)
+	}
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("不支持的 PROXY protocol 版本: %d", verCmd>>4)
+	}
+	header := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL: 健康检查等，使用连接本身的地址
+		return header, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("不支持的 PROXY v2 命令: %d", verCmd&0x0f)
+	}
+
+	// 只处理 TCP/UDP over IPv4/IPv6，其他地址族 (如 UNIX) 忽略地址
+	switch family >> 4 {
+	case 0x1:
+		if length < 12 {
+			return nil, fmt.Errorf("PROXY v2 IPv4 地址长度不足")
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x2:
+		if length < 36 {
+			return nil, fmt.Errorf("PROXY v2 IPv6 地址长度不足")
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	}
+	return header, nil
+}
+
+// Format 按指定版本 (1 或 2) 生成头部
+func (h *Header) Format() ([]byte, error) {
+	switch h.Version {
+	case 1:
+		return h.formatV1(), nil
+	case 2:
+		return h.formatV2(), nil
+	}
+	return nil, fmt.Errorf("不支持的 PROXY protocol 版本: %d", h.Version)
+}
+
+// addrFamily 返回头部使用的地址族 (4 或 6)，地址缺失时返回 0；
+// 源和目标地址族不一致时使用 IPv6 (IPv4 地址转换为 IPv4-mapped 形式)
+func (h *Header) addrFamily() int {
+	if h.Source == nil || h.Destination == nil || h.Source.IP.To16() == nil || h.Destination.IP.To16() == nil {
+		return 0
+	}
+	if h.Source.IP.To4() != nil && h.Destination.IP.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+func (h *Header) formatV1() []byte {
+	switch h.addrFamily() {
+	case 4:
+		return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", h.Source.IP.To4(), h.Destination.IP.To4(), h.Source.Port, h.Destination.Port))
+	case 6:
+		return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(h.Source.IP), ipv6String(h.Destination.IP), h.Source.Port, h.Destination.Port))
+	}
+	return []byte("PROXY UNKNOWN\r\n")
+}
+
+// ipv6String 以 IPv6 形式输出地址，IPv4 地址输出为 "::ffff:a.b.c.d"
+func ipv6String(ip net.IP) string {
+	return netip.AddrFrom16([16]byte(ip.To16())).String()
+}
+
+func (h *Header) formatV2() []byte {
+	buf := append([]byte{}, signatureV2...)
+
+	var payload []byte
+	switch h.addrFamily() {
+	case 4:
+		buf = append(buf, 0x21, 0x11) // PROXY, TCP over IPv4
+		payload = append(payload, h.Source.IP.To4()...)
+		payload = append(payload, h.Destination.IP.To4()...)
+	case 6:
+		buf = append(buf, 0x21, 0x21) // PROXY, TCP over IPv6
+		payload = append(payload, h.Source.IP.To16()...)
+		payload = append(payload, h.Destination.IP.To16()...)
+	default:
+		buf = append(buf, 0x20, 0x00) // LOCAL
+	}
+	if payload != nil {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(h.Source.Port))
+		payload = binary.BigEndian.AppendUint16(payload, uint16(h.Destination.Port))
+	}
+
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	return append(buf, payload...)
+}
@@ -0,0 +1,140 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// v2Header 构造 v2 头部，length 为负数时使用 payload 的实际长度
+func v2Header(verCmd, family byte, length int, payload []byte) []byte {
+	if length < 0 {
+		length = len(payload)
+	}
+	buf := append([]byte{}, signatureV2...)
+	buf = append(buf, verCmd, family)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(length))
+	return append(buf, payload...)
+}
+
+func TestRead(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x01, 0xbb}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0x30, 0x39, 0x01, 0xbb)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		version int
+		src     string // 空字符串表示没有地址
+		dst     string
+		wantErr bool
+	}{
+		{name: "v1 TCP4", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\nGET /"), version: 1, src: "192.0.2.1:12345", dst: "198.51.100.2:443"},
+		{name: "v1 TCP6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"), version: 1, src: "[2001:db8::1]:12345", dst: "[2001:db8::2]:443"},
+		{name: "v1 UNKNOWN", input: []byte("PROXY UNKNOWN\r\n"), version: 1},
+		{name: "v1 缺少 CRLF", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\n"), wantErr: true},
+		{name: "v1 截断", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 123"), wantErr: true},
+		{name: "v1 超长", input: []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), wantErr: true},
+		{name: "v1 字段数量错误", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345\r\n"), wantErr: true},
+		{name: "v1 TCP4 使用 IPv6 地址", input: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 12345 443\r\n"), wantErr: true},
+		{name: "v1 端口越界", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 70000 443\r\n"), wantErr: true},
+		{name: "v2 IPv4", input: v2Header(0x21, 0x11, -1, ipv4), version: 2, src: "192.0.2.1:12345", dst: "198.51.100.2:443"},
+		{name: "v2 IPv6", input: v2Header(0x21, 0x21, -1, ipv6), version: 2, src: "[2001:db8::1]:12345", dst: "[2001:db8::2]:443"},
+		{name: "v2 带 TLV", input: v2Header(0x21, 0x11, -1, append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)), version: 2, src: "192.0.2.1:12345", dst: "198.51.100.2:443"},
+		{name: "v2 LOCAL", input: v2Header(0x20, 0x00, -1, nil), version: 2},
+		{name: "v2 UNIX 地址族忽略地址", input: v2Header(0x21, 0x31, -1, make([]byte, 216)), version: 2},
+		{name: "v2 截断的固定头部", input: v2Header(0x21, 0x11, -1, ipv4)[:14], wantErr: true},
+		{name: "v2 截断的地址", input: v2Header(0x21, 0x11, 12, ipv4[:6]), wantErr: true},
+		{name: "v2 声明长度超过实际数据", input: v2Header(0x21, 0x11, 0xffff, ipv4), wantErr: true},
+		{name: "v2 IPv4 地址长度不足", input: v2Header(0x21, 0x11, -1, ipv4[:8]), wantErr: true},
+		{name: "v2 IPv6 地址长度不足", input: v2Header(0x21, 0x21, -1, ipv4), wantErr: true},
+		{name: "v2 版本错误", input: v2Header(0x11, 0x11, -1, ipv4), wantErr: true},
+		{name: "v2 未知命令", input: v2Header(0x22, 0x11, -1, ipv4), wantErr: true},
+		{name: "没有头部", input: []byte("GET / HTTP/1.1\r\n\r\n"), wantErr: true},
+		{name: "数据不足签名长度", input: []byte("PROXY"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := Read(bufio.NewReader(bytes.NewReader(tt.input)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("应返回错误，得到 %+v", header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if header.Version != tt.version {
+				t.Errorf("版本 = %d，期望 %d", header.Version, tt.version)
+			}
+			if got := addrString(header.Source); got != tt.src {
+				t.Errorf("源地址 = %q，期望 %q", got, tt.src)
+			}
+			if got := addrString(header.Destination); got != tt.dst {
+				t.Errorf("目标地址 = %q，期望 %q", got, tt.dst)
+			}
+		})
+	}
+}
+
+func TestReadLeavesPayload(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\nGET / HTTP/1.1\r\n"))
+	if _, err := Read(r); err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	rest, _ := r.ReadString('\n')
+	if rest != "GET / HTTP/1.1\r\n" {
+		t.Errorf("头部之后的数据应保留，得到 %q", rest)
+	}
+
+	if _, err := Read(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))); !errors.Is(err, ErrNoHeader) {
+		t.Errorf("没有头部时应返回 ErrNoHeader，得到 %v", err)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 443}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345}
+
+	tests := []struct {
+		name     string
+		src, dst *net.TCPAddr
+		src2     string // 解析后的源地址
+	}{
+		{"IPv4", v4, v4dst, "192.0.2.1:12345"},
+		{"IPv6", v6, v4dst, "[2001:db8::1]:12345"},
+		{"没有地址", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		for _, version := range []int{1, 2} {
+			data, err := (&Header{Version: version, Source: tt.src, Destination: tt.dst}).Format()
+			if err != nil {
+				t.Fatalf("%s v%d: 生成失败: %v", tt.name, version, err)
+			}
+			header, err := Read(bufio.NewReader(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatalf("%s v%d: 解析失败: %v", tt.name, version, err)
+			}
+			if got := addrString(header.Source); got != tt.src2 {
+				t.Errorf("%s v%d: 源地址 = %q，期望 %q", tt.name, version, got, tt.src2)
+			}
+		}
+	}
+	if _, err := (&Header{Version: 3}).Format(); err == nil {
+		t.Error("不支持的版本应返回错误")
+	}
+}
+
+// addrString 格式化地址，nil 返回空字符串
+func addrString(addr *net.TCPAddr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
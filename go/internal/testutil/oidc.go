@@ -0,0 +1,220 @@
+// Package testutil 测试使用的模拟服务，只被 _test.go 引用，不会编译进服务器和客户端
+package testutil
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockIdP 模拟的 OIDC 身份提供者：授权端点直接以 Email/Groups 登录并跳回，令牌端点校验客户端凭据、
+// 授权码、redirect_uri 和 PKCE 后签发 RS256 ID Token
+type MockIdP struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Email        string
+	Groups       []string
+	// Claims 在签发前修改 ID Token 的声明，用于测试校验失败的情况
+	Claims func(claims map[string]interface{})
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthCode
+}
+
+// mockAuthCode 授权码对应的登录信息
+type mockAuthCode struct {
+	email       string
+	groups      []string
+	nonce       string
+	redirectURI string
+	challenge   string
+	expires     time.Time
+}
+
+// MockIdPKeyID JWKS 中签名公钥的 kid
+const MockIdPKeyID = "mock"
+
+// NewMockIdP 启动模拟身份提供者，测试结束时关闭。clientId/clientSecret 为 tunnel/secret
+func NewMockIdP(t testing.TB) *MockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成签名密钥失败: %v", err)
+	}
+	m := &MockIdP{
+		ClientID:     "tunnel",
+		ClientSecret: "secret",
+		Email:        "alice@example.com",
+		Groups:       []string{"staff"},
+		key:          key,
+		codes:        make(map[string]mockAuthCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.handleDiscovery)
+	mux.HandleFunc("/authorize", m.handleAuthorize)
+	mux.HandleFunc("/token", m.handleToken)
+	mux.HandleFunc("/jwks", m.handleJWKS)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	m.Issuer = server.URL
+	return m
+}
+
+func (m *MockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.Issuer,
+		"authorization_endpoint":                m.Issuer + "/authorize",
+		"token_endpoint":                        m.Issuer + "/token",
+		"jwks_uri":                              m.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// handleAuthorize 以默认用户登录，带授权码跳回 redirect_uri
+func (m *MockIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != m.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	m.mu.Lock()
+	m.codes[code] = mockAuthCode{
+		email:       m.Email,
+		groups:      m.Groups,
+		nonce:       q.Get("nonce"),
+		redirectURI: redirectURI.String(),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	rq := redirectURI.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirectURI.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// handleToken 校验授权码、客户端凭据和 PKCE，签发 ID Token
+func (m *MockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != m.ClientID || clientSecret != m.ClientSecret {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	m.mu.Lock()
+	auth, found := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+	if !found || time.Now().After(auth.expires) || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if auth.challenge != "" {
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	}
+
+	claims := m.DefaultClaims(auth.email, auth.nonce)
+	claims["groups"] = auth.groups
+	if m.Claims != nil {
+		m.Claims(claims)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.Sign(claims),
+	})
+}
+
+func (m *MockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": MockIdPKeyID,
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// DefaultClaims 有效的 ID Token 声明，一小时后过期
+func (m *MockIdP) DefaultClaims(email, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            m.Issuer,
+		"sub":            email,
+		"aud":            m.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          email,
+		"email_verified": true,
+	}
+}
+
+// Sign 以 JWKS 中的密钥生成 RS256 签名的 JWT
+func (m *MockIdP) Sign(claims map[string]interface{}) string {
+	return m.SignWith(map[string]string{"alg": "RS256", "kid": MockIdPKeyID, "typ": "JWT"}, claims)
+}
+
+// SignWith 使用指定的 JWT 头签名，用于测试未知 kid 或不支持的算法
+func (m *MockIdP) SignWith(header map[string]string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
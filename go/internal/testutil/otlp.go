@@ -0,0 +1,183 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockCollector 模拟的 OTLP/HTTP (JSON) 收集器，记录 POST /v1/traces 收到的跨度。
+// 解码使用独立的结构，按 opentelemetry-proto 的 JSON 编码校验导出的数据
+type MockCollector struct {
+	// URL 收集器地址，作为 tracing.Config.Endpoint 使用
+	URL string
+
+	mu      sync.Mutex
+	spans   []CollectedSpan
+	headers http.Header
+}
+
+// CollectedSpan 收集器收到的跨度
+type CollectedSpan struct {
+	Service  string
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Kind     int
+	Duration time.Duration
+	Error    string
+	Attrs    map[string]string
+}
+
+// NewMockCollector 启动模拟收集器，测试结束时关闭
+func NewMockCollector(t testing.TB) *MockCollector {
+	t.Helper()
+	m := &MockCollector{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", m.handleTraces)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	m.URL = server.URL
+	return m
+}
+
+// Spans 收到的全部跨度，按收到的顺序排列
+func (m *MockCollector) Spans() []CollectedSpan {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]CollectedSpan(nil), m.spans...)
+}
+
+// Span 按名称查找跨度，没有时返回 nil
+func (m *MockCollector) Span(name string) *CollectedSpan {
+	for _, s := range m.Spans() {
+		if s.Name == name {
+			return &s
+		}
+	}
+	return nil
+}
+
+// Header 最近一次导出请求的请求头
+func (m *MockCollector) Header() http.Header {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.headers
+}
+
+// OTLP/JSON 请求体中用到的字段
+type (
+	collectorRequest struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []collectorKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID           string              `json:"traceId"`
+					SpanID            string              `json:"spanId"`
+					ParentSpanID      string              `json:"parentSpanId"`
+					Name              string              `json:"name"`
+					Kind              int                 `json:"kind"`
+					StartTimeUnixNano string              `json:"startTimeUnixNano"`
+					EndTimeUnixNano   string              `json:"endTimeUnixNano"`
+					Attributes        []collectorKeyValue `json:"attributes"`
+					Status            struct {
+						Code    int    `json:"code"`
+						Message string `json:"message"`
+					} `json:"status"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	collectorKeyValue struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue *string  `json:"stringValue"`
+			IntValue    *string  `json:"intValue"`
+			DoubleValue *float64 `json:"doubleValue"`
+			BoolValue   *bool    `json:"boolValue"`
+		} `json:"value"`
+	}
+)
+
+// text 属性值的文本形式
+func (kv collectorKeyValue) text() string {
+	v := kv.Value
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	}
+	return ""
+}
+
+func (m *MockCollector) handleTraces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "只支持 OTLP/HTTP JSON 编码", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req collectorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var spans []CollectedSpan
+	for _, rs := range req.ResourceSpans {
+		service := ""
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" {
+				service = kv.text()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				start, err1 := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+				end, err2 := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+				if err1 != nil || err2 != nil {
+					http.Error(w, "时间戳应为十进制字符串", http.StatusBadRequest)
+					return
+				}
+				span := CollectedSpan{
+					Service:  service,
+					TraceID:  s.TraceID,
+					SpanID:   s.SpanID,
+					ParentID: s.ParentSpanID,
+					Name:     s.Name,
+					Kind:     s.Kind,
+					Duration: time.Duration(end - start),
+					Attrs:    make(map[string]string, len(s.Attributes)),
+				}
+				if s.Status.Code == 2 {
+					span.Error = s.Status.Message
+				}
+				for _, kv := range s.Attributes {
+					span.Attrs[kv.Key] = kv.text()
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	m.spans = append(m.spans, spans...)
+	m.headers = r.Header.Clone()
+	m.mu.Unlock()
+	writeJSON(w, http.StatusOK, struct{}{})
+}
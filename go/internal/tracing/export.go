@@ -0,0 +1,251 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"tunnel/internal/logging"
+)
+
+var traceLog = logging.Component("tracing")
+
+// 批量导出的参数
+const (
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+	queueSize     = 2048
+)
+
+// Exporter 按批把跨度以 OTLP/HTTP JSON 格式发送到收集器。
+// 队列满时丢弃新的跨度，不阻塞请求处理
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	queue chan spanData
+	flush chan chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+// spanData 结束后的跨度快照
+type spanData struct {
+	span *Span
+	end  time.Time
+}
+
+// newExporter 创建导出器，endpoint 没有路径时追加 /v1/traces
+func newExporter(endpoint string, headers map[string]string, service string) (*Exporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的 OTLP 地址: %s", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	e := &Exporter{
+		endpoint: u.String(),
+		headers:  headers,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan spanData, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Endpoint 实际发送的地址
+func (t *Tracer) Endpoint() string {
+	if t == nil {
+		return ""
+	}
+	return t.exporter.endpoint
+}
+
+func (e *Exporter) enqueue(s *Span, end time.Time) {
+	select {
+	case e.queue <- spanData{span: s, end: end}:
+	default:
+		traceLog.Warn("链路追踪队列已满，丢弃跨度", "span", s.name)
+	}
+}
+
+// run 收集跨度，达到批量大小或定时发送
+func (e *Exporter) run() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []spanData
+	send := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case sd := <-e.queue:
+			batch = append(batch, sd)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(ack)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// shutdown 发送剩余的跨度，最多等待 5 秒
+func (e *Exporter) shutdown() {
+	e.once.Do(func() {
+		ack := make(chan struct{})
+		select {
+		case e.flush <- ack:
+			select {
+			case <-ack:
+			case <-time.After(5 * time.Second):
+			}
+		case <-time.After(5 * time.Second):
+		}
+		close(e.done)
+	})
+}
+
+// export 发送一批跨度，失败时只记录日志
+func (e *Exporter) export(batch []spanData) {
+	body, err := json.Marshal(e.payload(batch))
+	if err != nil {
+		traceLog.Warn("编码链路追踪数据失败", "error", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		traceLog.Warn("创建链路追踪导出请求失败", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		traceLog.Warn("导出链路追踪数据失败", "endpoint", e.endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		traceLog.Warn("收集器拒绝链路追踪数据", "endpoint", e.endpoint, "spans", len(batch), "status", resp.StatusCode)
+	}
+}
+
+// OTLP/JSON 结构 (opentelemetry-proto ExportTraceServiceRequest)
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // 0 未设置，2 错误
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+func (e *Exporter) payload(batch []spanData) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, sd := range batch {
+		s := sd.span
+		s.mu.Lock()
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              int(s.kind),
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(sd.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			out.Attributes = append(out.Attributes, keyValue(a.key, a.value))
+		}
+		if s.isError {
+			out.Status = otlpStatus{Code: 2, Message: s.errMessage}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{keyValue("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "tunnel"}, Spans: spans}},
+	}}}
+}
+
+func keyValue(key string, value interface{}) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	}
+	return kv
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"testing"
+
+	"tunnel/internal/testutil"
+)
+
+func TestExport(t *testing.T) {
+	collector := testutil.NewMockCollector(t)
+	tracer, err := New(Config{
+		Enabled:  true,
+		Endpoint: collector.URL,
+		Headers:  map[string]string{"Authorization": "Bearer collector-token"},
+	}, "test-service")
+	if err != nil {
+		t.Fatalf("创建 Tracer 失败: %v", err)
+	}
+	if tracer.Endpoint() != collector.URL+"/v1/traces" {
+		t.Errorf("没有路径时应发送到 /v1/traces，得到 %s", tracer.Endpoint())
+	}
+
+	root := tracer.Start("root", SpanKindServer, SpanContext{})
+	root.SetAttributes("http.request.method", "GET", "http.response.status_code", 200, "ratio", 0.5, "cached", true, "odd")
+	child := tracer.Start("child", SpanKindClient, root.Context())
+	child.SetError("上游超时")
+	child.End()
+	child.End() // 重复调用只导出一次
+	root.End()
+	root.SetError("结束后的修改被忽略")
+	root.SetAttributes("late", "value")
+
+	unsampled := tracer.Start("unsampled", SpanKindServer, SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{1}})
+	unsampled.End()
+
+	tracer.Shutdown()
+
+	spans := collector.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("应导出 2 个跨度 (未采样的跨度不导出)，得到 %d: %+v", len(spans), spans)
+	}
+	if got := collector.Header().Get("Authorization"); got != "Bearer collector-token" {
+		t.Errorf("导出时应附加配置的请求头，得到 %q", got)
+	}
+
+	r, c := collector.Span("root"), collector.Span("child")
+	if r == nil || c == nil {
+		t.Fatalf("缺少跨度: %+v", spans)
+	}
+	if r.Service != "test-service" || r.Kind != int(SpanKindServer) || c.Kind != int(SpanKindClient) {
+		t.Errorf("服务名或跨度类型不正确: %+v %+v", r, c)
+	}
+	if r.TraceID != root.Context().Traceparent()[3:35] || r.ParentID != "" {
+		t.Errorf("根跨度的追踪 ID 或父跨度不正确: %+v", r)
+	}
+	if c.TraceID != r.TraceID || c.ParentID != r.SpanID {
+		t.Errorf("子跨度应在同一追踪中并以根跨度为父跨度: root=%+v child=%+v", r, c)
+	}
+	if r.Duration < 0 || r.Error != "" || c.Error != "上游超时" {
+		t.Errorf("耗时或错误状态不正确: %+v %+v", r, c)
+	}
+	want := map[string]string{"http.request.method": "GET", "http.response.status_code": "200", "ratio": "0.5", "cached": "true"}
+	for k, v := range want {
+		if r.Attrs[k] != v {
+			t.Errorf("属性 %s = %q, 期望 %q", k, r.Attrs[k], v)
+		}
+	}
+	if len(r.Attrs) != len(want) {
+		t.Errorf("缺少值的属性应被忽略，得到 %v", r.Attrs)
+	}
+}
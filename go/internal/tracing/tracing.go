@@ -0,0 +1,243 @@
+// Package tracing 实现 W3C Trace Context 传播和 OTLP/HTTP (JSON) 导出的最小链路追踪
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader W3C Trace Context 请求头
+const TraceparentHeader = "traceparent"
+
+// Config 链路追踪配置
+type Config struct {
+	Enabled     bool              `yaml:"enabled" json:"enabled"`
+	Endpoint    string            `yaml:"endpoint" json:"endpoint"`       // OTLP/HTTP 地址，如 http://localhost:4318
+	Headers     map[string]string `yaml:"headers" json:"headers"`         // 导出时附加的请求头，如认证信息
+	ServiceName string            `yaml:"serviceName" json:"serviceName"` // 默认为程序名
+	SampleRatio *float64          `yaml:"sampleRatio" json:"sampleRatio"` // 没有上游追踪时的采样比例，默认 1
+}
+
+// SpanKind 跨度类型，取值与 OTLP 一致
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+	SpanKindProducer SpanKind = 4
+	SpanKindConsumer SpanKind = 5
+)
+
+// SpanContext 跨服务传播的追踪上下文
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid 追踪 ID 和跨度 ID 均非零
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent 格式化为 traceparent 请求头，无效时返回空字符串
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent 解析 traceparent 请求头 (版本 00，未知的更高版本按 00 的前缀解析)
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Tracer 创建跨度并交给导出器。nil Tracer 表示未启用追踪，此时只传播上游的上下文
+type Tracer struct {
+	exporter    *Exporter
+	sampleRatio float64
+}
+
+// New 按配置创建 Tracer，未启用时返回 nil
+func New(cfg Config, defaultService string) (*Tracer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("启用链路追踪时需要设置 endpoint")
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("sampleRatio 应在 0 到 1 之间: %v", ratio)
+	}
+	service := cfg.ServiceName
+	if service == "" {
+		service = defaultService
+	}
+	exporter, err := newExporter(cfg.Endpoint, cfg.Headers, service)
+	if err != nil {
+		return nil, err
+	}
+	return &Tracer{exporter: exporter, sampleRatio: ratio}, nil
+}
+
+// Start 开始一个跨度。parent 无效时开始新的追踪，按采样比例决定是否记录；
+// 有上游上下文时沿用其追踪 ID 和采样决定
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext) *Span {
+	if t == nil {
+		return &Span{sc: parent}
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+// sample 按追踪 ID 的低 8 字节决定是否采样，同一追踪在各处的决定一致
+func (t *Tracer) sample(id [16]byte) bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	var n uint64
+	for _, b := range id[8:] {
+		n = n<<8 | uint64(b)
+	}
+	return float64(n>>11) < t.sampleRatio*float64(uint64(1)<<53)
+}
+
+// Shutdown 导出剩余的跨度
+func (t *Tracer) Shutdown() {
+	if t != nil {
+		t.exporter.shutdown()
+	}
+}
+
+// Span 一个计时的操作。未启用追踪时 Span 只携带上游的上下文，所有方法都可以安全调用
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   SpanKind
+	sc     SpanContext
+	parent [8]byte
+	start  time.Time
+
+	mu         sync.Mutex
+	attrs      []attribute
+	errMessage string
+	isError    bool
+	ended      bool
+}
+
+// attribute 跨度属性，value 为 string、int64、float64 或 bool
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Context 用于传播的上下文
+func (s *Span) Context() SpanContext {
+	return s.sc
+}
+
+// Traceparent 传播给下游的 traceparent 值，没有追踪上下文时为空
+func (s *Span) Traceparent() string {
+	return s.sc.Traceparent()
+}
+
+// SetAttributes 设置属性，参数为键值对交替排列。跨度结束后的修改被忽略
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s.tracer == nil || !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		var value interface{}
+		switch v := kv[i+1].(type) {
+		case string, int64, float64, bool:
+			value = v
+		case int:
+			value = int64(v)
+		case time.Duration:
+			value = v.Seconds()
+		default:
+			value = fmt.Sprint(v)
+		}
+		s.attrs = append(s.attrs, attribute{key: key, value: value})
+	}
+}
+
+// SetError 标记跨度失败，需要在 End 之前调用
+func (s *Span) SetError(message string) {
+	if s.tracer == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.ended {
+		s.isError, s.errMessage = true, message
+	}
+	s.mu.Unlock()
+}
+
+// End 结束跨度并交给导出器 (只有采样的跨度会导出)
+func (s *Span) End() {
+	if s.tracer == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exporter.enqueue(s, time.Now())
+	}
+}
@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		name    string
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"已采样", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"未采样", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"其他标志位", "00-" + traceID + "-" + spanID + "-03", true, true},
+		{"前后空白", "  00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"大写十六进制", "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", true, true},
+		{"更高版本带额外字段", "01-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"版本 00 带额外字段", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"版本 ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"全零追踪 ID", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"全零跨度 ID", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"追踪 ID 长度错误", "00-" + traceID[:30] + "-" + spanID + "-01", false, false},
+		{"跨度 ID 不是十六进制", "00-" + traceID + "-" + "zzf067aa0ba902b7" + "-01", false, false},
+		{"标志位不是十六进制", "00-" + traceID + "-" + spanID + "-0g", false, false},
+		{"字段不足", "00-" + traceID + "-" + spanID, false, false},
+		{"空值", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.value)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok = %v, 期望 %v", tt.value, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("Sampled = %v, 期望 %v", sc.Sampled, tt.sampled)
+			}
+			if got := sc.Traceparent(); !strings.Contains(got, traceID) || !strings.Contains(got, spanID) {
+				t.Errorf("Traceparent() = %q，应保留追踪 ID 和跨度 ID", got)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, value := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	} {
+		sc, ok := ParseTraceparent(value)
+		if !ok {
+			t.Fatalf("解析 %q 失败", value)
+		}
+		if got := sc.Traceparent(); got != value {
+			t.Errorf("Traceparent() = %q, 期望 %q", got, value)
+		}
+	}
+	if got := (SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("无效的上下文应返回空字符串，得到 %q", got)
+	}
+}
+
+func TestStartWithParent(t *testing.T) {
+	tracer := &Tracer{sampleRatio: 0}
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	span := tracer.Start("child", SpanKindServer, parent)
+	sc := span.Context()
+	if sc.TraceID != parent.TraceID {
+		t.Error("子跨度应沿用上游的追踪 ID")
+	}
+	if sc.SpanID == parent.SpanID || sc.SpanID == [8]byte{} {
+		t.Error("子跨度应有新的跨度 ID")
+	}
+	if span.parent != parent.SpanID {
+		t.Error("子跨度的父跨度应为上游跨度")
+	}
+	if !sc.Sampled {
+		t.Error("有上游上下文时应沿用其采样决定，而不是本地的采样比例")
+	}
+
+	parent.Sampled = false
+	tracer.sampleRatio = 1
+	if tracer.Start("child", SpanKindServer, parent).Context().Sampled {
+		t.Error("上游未采样时不应采样")
+	}
+}
+
+func TestStartNewTrace(t *testing.T) {
+	a := (&Tracer{sampleRatio: 1}).Start("root", SpanKindServer, SpanContext{})
+	b := (&Tracer{sampleRatio: 1}).Start("root", SpanKindServer, SpanContext{})
+	if !a.Context().IsValid() || a.Context().TraceID == b.Context().TraceID {
+		t.Error("没有上游上下文时应开始新的追踪")
+	}
+	if a.parent != [8]byte{} {
+		t.Error("根跨度不应有父跨度")
+	}
+	if !a.Context().Sampled {
+		t.Error("采样比例为 1 时应采样")
+	}
+	if (&Tracer{sampleRatio: 0}).Start("root", SpanKindServer, SpanContext{}).Context().Sampled {
+		t.Error("采样比例为 0 时不应采样")
+	}
+}
+
+func TestSampleConsistentPerTrace(t *testing.T) {
+	tracer := &Tracer{sampleRatio: 0.5}
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		span := tracer.Start("root", SpanKindServer, SpanContext{})
+		if span.Context().Sampled {
+			sampled++
+		}
+		if tracer.sample(span.Context().TraceID) != span.Context().Sampled {
+			t.Fatal("同一追踪 ID 的采样决定应一致")
+		}
+	}
+	if sampled < 400 || sampled > 600 {
+		t.Errorf("采样比例 0.5 时 1000 个追踪中采样了 %d 个", sampled)
+	}
+}
+
+func TestNilTracerPropagatesParent(t *testing.T) {
+	var tracer *Tracer
+	const value = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	parent, _ := ParseTraceparent(value)
+
+	span := tracer.Start("child", SpanKindServer, parent)
+	span.SetAttributes("key", "value")
+	span.SetError("error")
+	span.End()
+	if got := span.Traceparent(); got != value {
+		t.Errorf("未启用追踪时应原样透传上游的 traceparent，得到 %q", got)
+	}
+	if got := tracer.Start("root", SpanKindServer, SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("未启用追踪且没有上游时不应生成 traceparent，得到 %q", got)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	if tracer, err := New(Config{}, "svc"); tracer != nil || err != nil {
+		t.Errorf("未启用时应返回 nil, nil，得到 %v, %v", tracer, err)
+	}
+	ratio := 1.5
+	for name, cfg := range map[string]Config{
+		"没有 endpoint":      {Enabled: true},
+		"不支持的协议":           {Enabled: true, Endpoint: "grpc://localhost:4317"},
+		"sampleRatio 超出范围": {Enabled: true, Endpoint: "http://localhost:4318", SampleRatio: &ratio},
+	} {
+		if _, err := New(cfg, "svc"); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}